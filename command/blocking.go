@@ -0,0 +1,236 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+// Package command is to handle the command from client.
+package command
+
+import (
+	"container/list"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rod6/rodis/resp"
+	"github.com/rod6/rodis/storage"
+)
+
+// Blocking list operations.
+// A client blocked on keys registers one waiter, which is queued (FIFO) on every key it
+// waits for. A command pushing to a list calls serveBlocked under the db write lock, which
+// serves the waiters of the key in order while the list is not empty: the element is popped
+// on behalf of the blocked client and the reply is handed over by a channel. So a blocked
+// client never loses an element to a client coming later.
+
+type blockedKey struct {
+	db  *storage.LevelDB
+	key string
+}
+
+// popFunc pops from the key for a blocked client under the db write lock, returns the reply
+// and the key it pushed to if any (blmove), which may serve other blocked clients.
+type popFunc func(key []byte) (resp.Value, []byte)
+
+type waiter struct {
+	pop   popFunc
+	reply chan resp.Value
+	elems map[blockedKey]*list.Element
+	done  bool // served or canceled, guarded by blocked
+}
+
+var blocked = struct {
+	sync.Mutex
+	queues  map[blockedKey]*list.List
+	clients int // the waiters not served or canceled yet
+}{queues: make(map[blockedKey]*list.List)}
+
+func newWaiter(db *storage.LevelDB, keys [][]byte, pop popFunc) *waiter {
+	w := &waiter{pop: pop, reply: make(chan resp.Value, 1), elems: make(map[blockedKey]*list.Element)}
+
+	blocked.Lock()
+	defer blocked.Unlock()
+
+	blocked.clients++
+	for _, key := range keys {
+		bk := blockedKey{db, string(key)}
+		if _, ok := w.elems[bk]; ok { // the same key is given more than once
+			continue
+		}
+		queue, ok := blocked.queues[bk]
+		if !ok {
+			queue = list.New()
+			blocked.queues[bk] = queue
+		}
+		w.elems[bk] = queue.PushBack(w)
+	}
+	return w
+}
+
+// unlink removes w from all queues, should be called with blocked locked.
+func (w *waiter) unlink() {
+	for bk, elem := range w.elems {
+		queue := blocked.queues[bk]
+		queue.Remove(elem)
+		if queue.Len() == 0 {
+			delete(blocked.queues, bk)
+		}
+	}
+	w.elems = nil
+	blocked.clients--
+}
+
+// cancel cancels the waiter on timeout, returns false if it has been served.
+func (w *waiter) cancel() bool {
+	blocked.Lock()
+	defer blocked.Unlock()
+
+	if w.done {
+		return false
+	}
+	w.done = true
+	w.unlink()
+	return true
+}
+
+// wait waits until the waiter is served, timeout (0 for ever) or the connection is closed.
+// It replies a nil array when it is not served.
+func (w *waiter) wait(timeout time.Duration, ex *CommandExtras) resp.Value {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	var closed <-chan struct{}
+	if ex.WatchClose != nil {
+		c, stop := ex.WatchClose()
+		defer stop()
+		closed = c
+	}
+
+	select {
+	case reply := <-w.reply:
+		return reply
+	case <-timer:
+	case <-closed:
+	}
+
+	if w.cancel() {
+		return resp.Array(nil)
+	}
+	return <-w.reply // served right before canceled
+}
+
+// claimWaiter takes the first waiter of the key out of the queues.
+func claimWaiter(db *storage.LevelDB, key []byte) *waiter {
+	blocked.Lock()
+	defer blocked.Unlock()
+
+	queue, ok := blocked.queues[blockedKey{db, string(key)}]
+	if !ok {
+		return nil
+	}
+	w := queue.Front().Value.(*waiter)
+	w.done = true
+	w.unlink()
+	return w
+}
+
+// serveBlocked serves the clients blocked on the key after a push, the caller should hold
+// the db write lock.
+func serveBlocked(db *storage.LevelDB, key []byte) {
+	ready := [][]byte{key}
+	for len(ready) > 0 {
+		key := ready[0]
+		ready = ready[1:]
+
+		for {
+			exists, tipe, _ := db.Has(key)
+			if !exists || tipe != storage.List {
+				break
+			}
+			w := claimWaiter(db, key)
+			if w == nil {
+				break
+			}
+			reply, pushed := w.pop(key)
+			w.reply <- reply
+			if pushed != nil {
+				ready = append(ready, pushed)
+			}
+		}
+	}
+}
+
+// serveAllBlocked serves the clients blocked on any key of the db, for the pushes which do
+// not serve them at once, i.e. the ones in a transaction. The caller should hold the db
+// write lock.
+func serveAllBlocked(db *storage.LevelDB) {
+	keys := [][]byte{}
+	blocked.Lock()
+	for bk := range blocked.queues {
+		if bk.db == db {
+			keys = append(keys, []byte(bk.key))
+		}
+	}
+	blocked.Unlock()
+
+	for _, key := range keys {
+		serveBlocked(db, key)
+	}
+}
+
+// popFirst pops from the first non empty list of keys, the caller should hold the write lock.
+// Returns false if all the lists are empty.
+func popFirst(ex *CommandExtras, keys [][]byte, pop popFunc) (resp.Value, bool) {
+	for _, key := range keys {
+		exists, tipe, _ := ex.DB.Has(key)
+		if !exists {
+			continue
+		}
+		if tipe != storage.List {
+			return resp.NewError(ErrWrongType), true
+		}
+
+		reply, pushed := pop(key)
+		if pushed != nil {
+			serveBlocked(ex.DB, pushed)
+		}
+		return reply, true
+	}
+	return nil, false
+}
+
+// blockingHelper pops from the first non empty list of keys, or blocks the client until a
+// push serves it, timeout or the connection is closed. In a transaction it never blocks, but
+// times out at once.
+func blockingHelper(ex *CommandExtras, keys [][]byte, timeout time.Duration, pop popFunc) error {
+	ex.DB.Lock()
+	if reply, ok := popFirst(ex, keys, pop); ok {
+		ex.DB.Unlock()
+		return ex.write(reply)
+	}
+	if ex.inExec {
+		ex.DB.Unlock()
+		return ex.write(resp.Array(nil))
+	}
+
+	w := newWaiter(ex.DB, keys, pop)
+	ex.DB.Unlock()
+
+	return ex.write(w.wait(timeout, ex))
+}
+
+func parseTimeout(arg resp.BulkString) (time.Duration, error) {
+	f, err := strconv.ParseFloat(arg.String(), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f*float64(time.Second) > math.MaxInt64 {
+		return 0, resp.NewError(ErrTimeoutNotFloat)
+	}
+	if f < 0 {
+		return 0, resp.NewError(ErrTimeoutNegative)
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
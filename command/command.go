@@ -21,8 +21,55 @@ import (
 type CommandExtras struct {
 	DB           *storage.LevelDB
 	Buffer       *bytes.Buffer
-	IsConnAuthed bool
-	Password     string
+	IsConnAuthed bool // the password is checked by requirePass, so it may change at runtime
+
+	ClientID   int64  // unique id of the connection
+	ClientName []byte // set by HELLO SETNAME
+	Protocol   int    // RESP version of the connection, 2 or 3, negotiated by HELLO
+
+	PubSub PubSub // the subscriptions of the connection
+
+	Quit bool // set by QUIT, the connection is closed after the reply is written
+
+	// WatchClose is called by a blocked command, it returns a channel closed when the client
+	// closes the connection, stop should be called before the command returns.
+	WatchClose func() (closed <-chan struct{}, stop func())
+
+	multi  bool            // in MULTI, the commands are queued until EXEC
+	queued []queuedCommand // the queued commands
+	dirty  bool            // a command is rejected while queuing, EXEC aborts
+	inExec bool            // running the queued commands, which never block
+
+	watch   *storage.Watch // the keys watched by WATCH, nil if none
+	watched []watchedKey
+
+	noWrites bool // in a read-only script, the commands writing are not allowed
+
+	inScript    bool       // the commands are run from a script, the reply is kept, see write
+	scriptReply resp.Value // the reply of the last command from the script
+}
+
+// Release releases what the connection keeps out of itself, it is called when the
+// connection is closed.
+func (ex *CommandExtras) Release() {
+	ex.unwatch()
+}
+
+// write writes the reply v in the protocol of the connection to the buffer, or keeps it for the
+// script running the command, so a null reply is Null in RESP3 and the RESP3 types are
+// replaced in RESP2.
+func (ex *CommandExtras) write(v resp.Value) error {
+	v = resp.Protocol(v, ex.Protocol)
+	if ex.inScript {
+		ex.scriptReply = v
+		return nil
+	}
+	return v.WriteTo(ex.Buffer)
+}
+
+// reply is write, for the replies with RESP3 types.
+func (ex *CommandExtras) reply(v resp.Value) error {
+	return ex.write(v)
 }
 
 // command handle function
@@ -31,7 +78,12 @@ type commandFunc func(v resp.CommandArgs, ex *CommandExtras) error
 // command map attr struct
 type attr struct {
 	f commandFunc // func for the command
-	c int         // arg count for the command
+	c int         // arg count for the command, -N for at least N, the rest is checked in f
+}
+
+// arity checks the number of the arguments, n includes the command name.
+func (a *attr) arity(n int) bool {
+	return a.c > 0 && n == a.c || a.c < 0 && n >= -a.c
 }
 
 // commands, a map type with name as the key
@@ -39,37 +91,68 @@ var commands = map[string]*attr{
 	// connection
 	"auth":   &attr{auth, 2},
 	"echo":   &attr{echo, 2},
+	"hello":  &attr{hello, -1},
 	"ping":   &attr{ping, 1},
+	"quit":   &attr{quit, 1},
+	"reset":  &attr{reset, 1},
 	"select": &attr{selectDB, 2},
 
+	// transactions
+	"discard": &attr{discard, 1},
+	"exec":    &attr{exec, 1},
+	"multi":   &attr{multi, 1},
+	"unwatch": &attr{unwatch, 1},
+	"watch":   &attr{watch, -2},
+
+	// pub/sub
+	"psubscribe":   &attr{psubscribe, -2},
+	"publish":      &attr{publish, 3},
+	"pubsub":       &attr{pubsub, -2},
+	"punsubscribe": &attr{punsubscribe, -1},
+	"spublish":     &attr{spublish, 3},
+	"ssubscribe":   &attr{ssubscribe, -2},
+	"subscribe":    &attr{subscribe, -2},
+	"sunsubscribe": &attr{sunsubscribe, -1},
+	"unsubscribe":  &attr{unsubscribe, -1},
+
+	// scripting: eval, evalsha and script are added in scripting.go
+	// functions: function, fcall and fcall_ro are added in functions.go
+
 	// server
+	"dbsize":  &attr{dbsize, 1},
 	"flushdb": &attr{flushdb, 1},
+	"config":  &attr{configx, -2},
+	"info":    &attr{info, -1},
 
 	// strings
 	"append":      &attr{appendx, 3},
-	"bitcount":    &attr{bitcount, 0},
-	"bitop":       &attr{bitop, 0},
-	"bitpos":      &attr{bitpos, 0},
+	"bitcount":    &attr{bitcount, -2},
+	"bitop":       &attr{bitop, -4},
+	"bitpos":      &attr{bitpos, -3},
 	"decr":        &attr{decr, 2},
 	"decrby":      &attr{decrby, 3},
 	"get":         &attr{get, 2},
 	"getbit":      &attr{getbit, 3},
+	"getdel":      &attr{getdel, 2},
+	"getex":       &attr{getex, -2},
 	"getrange":    &attr{getrange, 4},
 	"getset":      &attr{getset, 3},
 	"incr":        &attr{incr, 2},
 	"incrby":      &attr{incrby, 3},
 	"incrbyfloat": &attr{incrbyfloat, 3},
-	"mget":        &attr{mget, 0},
-	"mset":        &attr{mset, 0},
-	"msetnx":      &attr{msetnx, 0},
-	"set":         &attr{set, 0},
+	"mget":        &attr{mget, -2},
+	"mset":        &attr{mset, -3},
+	"msetnx":      &attr{msetnx, -3},
+	"psetex":      &attr{psetex, 4},
+	"set":         &attr{set, -3},
 	"setbit":      &attr{setbit, 4},
+	"setex":       &attr{setex, 4},
 	"setnx":       &attr{setnx, 3},
 	"setrange":    &attr{setrange, 4},
 	"strlen":      &attr{strlen, 2},
 
 	// hashes
-	"hdel":         &attr{hdel, 0},
+	"hdel":         &attr{hdel, -3},
 	"hexists":      &attr{hexists, 3},
 	"hget":         &attr{hget, 3},
 	"hgetall":      &attr{hgetall, 2},
@@ -77,16 +160,139 @@ var commands = map[string]*attr{
 	"hincrbyfloat": &attr{hincrbyfloat, 4},
 	"hkeys":        &attr{hkeys, 2},
 	"hlen":         &attr{hlen, 2},
-	"hmget":        &attr{hmget, 0},
-	"hmset":        &attr{hmset, 0},
+	"hmget":        &attr{hmget, -3},
+	"hmset":        &attr{hmset, -4},
 	"hset":         &attr{hset, 4},
 	"hsetnx":       &attr{hsetnx, 4},
 	"hstrlen":      &attr{hstrlen, 3},
+	"hscan":        &attr{hscan, -3},
 	"hvals":        &attr{hvals, 2},
+
+	// lists
+	"blmove":     &attr{blmove, 6},
+	"blmpop":     &attr{blmpop, -5},
+	"blpop":      &attr{blpop, -3},
+	"brpop":      &attr{brpop, -3},
+	"brpoplpush": &attr{brpoplpush, 4},
+	"lindex":     &attr{lindex, 3},
+	"linsert":    &attr{linsert, 5},
+	"llen":       &attr{llen, 2},
+	"lmove":      &attr{lmove, 5},
+	"lmpop":      &attr{lmpop, -4},
+	"lpop":       &attr{lpop, -2},
+	"lpos":       &attr{lpos, -3},
+	"lpush":      &attr{lpush, -3},
+	"lpushx":     &attr{lpushx, -3},
+	"lrange":     &attr{lrange, 4},
+	"lrem":       &attr{lrem, 4},
+	"lset":       &attr{lset, 4},
+	"ltrim":      &attr{ltrim, 4},
+	"rpop":       &attr{rpop, -2},
+	"rpoplpush":  &attr{rpoplpush, 3},
+	"rpush":      &attr{rpush, -3},
+	"rpushx":     &attr{rpushx, -3},
+
+	// sets
+	"sadd":        &attr{sadd, -3},
+	"scard":       &attr{scard, 2},
+	"sdiff":       &attr{sdiff, -2},
+	"sdiffstore":  &attr{sdiffstore, -3},
+	"sinter":      &attr{sinter, -2},
+	"sintercard":  &attr{sintercard, -3},
+	"sinterstore": &attr{sinterstore, -3},
+	"sismember":   &attr{sismember, 3},
+	"smembers":    &attr{smembers, 2},
+	"smismember":  &attr{smismember, -3},
+	"smove":       &attr{smove, 4},
+	"spop":        &attr{spop, -2},
+	"srandmember": &attr{srandmember, -2},
+	"srem":        &attr{srem, -3},
+	"sunion":      &attr{sunion, -2},
+	"sunionstore": &attr{sunionstore, -3},
+
+	// sorted sets
+	"zadd":        &attr{zadd, -4},
+	"zcard":       &attr{zcard, 2},
+	"zcount":      &attr{zcount, 4},
+	"zincrby":     &attr{zincrby, 4},
+	"zinterstore": &attr{zinterstore, -4},
+	"zpopmax":     &attr{zpopmax, -2},
+	"zpopmin":     &attr{zpopmin, -2},
+	"zrange":      &attr{zrange, -4},
+	"zrangestore": &attr{zrangestore, -5},
+	"zrank":       &attr{zrank, -3},
+	"zrem":        &attr{zrem, -3},
+	"zrevrank":    &attr{zrevrank, -3},
+	"zscore":      &attr{zscore, 3},
+	"zunionstore": &attr{zunionstore, -4},
+
 	// keys
-	"del":    &attr{del, 0},
-	"exists": &attr{exists, 0},
-	"type":   &attr{tipe, 2},
+	"del":         &attr{del, -2},
+	"exists":      &attr{exists, -2},
+	"keys":        &attr{keys, 2},
+	"expire":      &attr{expire, -3},
+	"expireat":    &attr{expireat, -3},
+	"expiretime":  &attr{expiretime, 2},
+	"persist":     &attr{persist, 2},
+	"pexpire":     &attr{pexpire, -3},
+	"pexpireat":   &attr{pexpireat, -3},
+	"pexpiretime": &attr{pexpiretime, 2},
+	"pttl":        &attr{pttl, 2},
+	"scan":        &attr{scan, -2},
+	"ttl":         &attr{ttl, 2},
+	"type":        &attr{tipe, 2},
+}
+
+// readOnly are the commands which never write, the only ones allowed in a read-only script.
+var readOnly = map[string]bool{
+	"echo":        true,
+	"ping":        true,
+	"pubsub":      true,
+	"dbsize":      true,
+	"info":        true,
+	"bitcount":    true,
+	"bitpos":      true,
+	"get":         true,
+	"getbit":      true,
+	"getrange":    true,
+	"mget":        true,
+	"strlen":      true,
+	"hexists":     true,
+	"hget":        true,
+	"hgetall":     true,
+	"hkeys":       true,
+	"hlen":        true,
+	"hmget":       true,
+	"hscan":       true,
+	"hstrlen":     true,
+	"hvals":       true,
+	"lindex":      true,
+	"llen":        true,
+	"lpos":        true,
+	"lrange":      true,
+	"scard":       true,
+	"sdiff":       true,
+	"sinter":      true,
+	"sintercard":  true,
+	"sismember":   true,
+	"smembers":    true,
+	"smismember":  true,
+	"srandmember": true,
+	"sunion":      true,
+	"zcard":       true,
+	"zcount":      true,
+	"zrange":      true,
+	"zrank":       true,
+	"zrevrank":    true,
+	"zscore":      true,
+	"exists":      true,
+	"expiretime":  true,
+	"keys":        true,
+	"pexpiretime": true,
+	"pttl":        true,
+	"scan":        true,
+	"ttl":         true,
+	"type":        true,
 }
 
 // Get command handler
@@ -98,34 +304,52 @@ func findCmdFunc(c string) (*attr, error) {
 	return a, nil
 }
 
+// noAuth are the commands allowed before the connection is authenticated.
+var noAuth = map[string]bool{
+	"auth":  true,
+	"hello": true,
+	"quit":  true,
+	"reset": true,
+}
+
 // Handle command
-func Handle(v resp.Array, ex *CommandExtras) error {
+func Handle(args resp.CommandArgs, ex *CommandExtras) error {
 	ex.Buffer.Truncate(0) // Truncate all data in the buffer
 
-	if len(v) == 0 {
+	if len(args) == 0 {
 		log6.Debug("Command handler, len of the input array is 0")
-		return resp.NewError(ErrFmtNoCommand).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtNoCommand))
 	}
 
-	args := v.ToArgs()
 	//log6.Debug("Command handling:%v", humanArgs(args))
 
 	cmd := strings.ToLower(args[0].String())
 	a, err := findCmdFunc(cmd)
 	if err != nil {
 		log6.Debug("Command handler, cannt found command: %v", cmd)
-		return resp.NewError(ErrFmtUnknownCommand, cmd).WriteTo(ex.Buffer)
+		ex.flagMulti()
+		return ex.write(resp.NewError(ErrFmtUnknownCommand, cmd))
 	}
 
-	if a.c != 0 && len(v) != a.c { //a.c = 0 means to check the number in f
-		return resp.NewError(ErrFmtWrongNumberArgument, cmd).WriteTo(ex.Buffer)
+	if !a.arity(len(args)) {
+		ex.flagMulti()
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
 	}
 
-	if !ex.IsConnAuthed && ex.Password != "" && cmd != "auth" {
-		return resp.NewError(ErrAuthed).WriteTo(ex.Buffer)
+	if !ex.IsConnAuthed && !noAuth[cmd] && requirePass() != "" {
+		ex.flagMulti()
+		return ex.write(resp.NewError(ErrAuthed))
 	}
 
-	return a.f(args[1:], ex)
+	if ex.subscribed() && !inSubscribed[cmd] {
+		ex.flagMulti()
+		return ex.write(resp.NewError(ErrFmtInSubscribed, cmd))
+	}
+
+	if ex.multi {
+		return queue(cmd, a, args[1:], ex)
+	}
+	return call(cmd, a.f, args[1:], ex)
 }
 
 func humanArgs(args resp.CommandArgs) string {
@@ -155,4 +379,90 @@ const (
 	ErrBitValueInvalid        = `ERR bit is not an integer or out of range`
 	ErrStringExccedLimit      = `ERR string exceeds maximum allowed size (512MB)`
 	ErrOffsetOutRange         = `ERR offset is out of range`
+	ErrFmtUnsupportedOption   = `ERR Unsupported option %s`
+	ErrNXAndXXGTLT            = `ERR NX and XX, GT or LT options at the same time are not compatible`
+	ErrGTAndLT                = `ERR GT and LT options at the same time are not compatible`
+	ErrFmtInvalidExpireTime   = `ERR invalid expire time in '%s' command`
+	ErrNoSuchKey              = `ERR no such key`
+	ErrIndexOutRange          = `ERR index out of range`
+	ErrMustBePositive         = `ERR value is out of range, must be positive`
+	ErrLPosRankZero           = `ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list`
+	ErrLPosCountNegative      = `ERR COUNT can't be negative`
+	ErrLPosMaxLenNegative     = `ERR MAXLEN can't be negative`
+	ErrTimeoutNotFloat        = `ERR timeout is not a float or out of range`
+	ErrTimeoutNegative        = `ERR timeout is negative`
+	ErrNumkeysNotPositive     = `ERR numkeys should be greater than 0`
+	ErrCountNotPositive       = `ERR count should be greater than 0`
+	ErrNumkeysExceedArgs      = `ERR Number of keys can't be greater than number of args`
+	ErrLimitNegative          = `ERR LIMIT can't be negative`
+	ErrZAddNXAndXX            = `ERR XX and NX options at the same time are not compatible`
+	ErrZAddGTLTNX             = `ERR GT, LT, and/or NX options at the same time are not compatible`
+	ErrZAddIncrPair           = `ERR INCR option supports a single increment-element pair`
+	ErrScoreNaN               = `ERR resulting score is not a number (NaN)`
+	ErrMinMaxNotFloat         = `ERR min or max is not a float`
+	ErrMinMaxNotString        = `ERR min or max not valid string range item`
+	ErrZRangeLimit            = `ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX`
+	ErrZRangeWithScoresLex    = `ERR syntax error, WITHSCORES not supported in combination with BYLEX`
+	ErrFmtAtLeastOneKey       = `ERR at least 1 input key is needed for '%s' command`
+	ErrWeightNotFloat         = `ERR weight value is not a float`
+	ErrInvalidCursor          = `ERR invalid cursor`
+	ErrNoProto                = `NOPROTO unsupported protocol version`
+	ErrProtoNotInt            = `ERR Protocol version is not an integer or out of range`
+	ErrFmtHelloOption         = `ERR Syntax error in HELLO option '%s'`
+	ErrHelloNoAuth            = `NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time`
+	ErrWrongPass              = `WRONGPASS invalid username-password pair or user is disabled.`
+	ErrClientName             = `ERR Client names cannot contain spaces, newlines or special characters.`
+	ErrMultiNested            = `ERR MULTI calls can not be nested`
+	ErrExecWithoutMulti       = `ERR EXEC without MULTI`
+	ErrDiscardWithoutMulti    = `ERR DISCARD without MULTI`
+	ErrExecAbort              = `EXECABORT Transaction discarded because of previous errors.`
+	ErrNotInMulti             = `ERR Command not allowed inside a transaction`
+	ErrFmtUnknownSubcommand   = `ERR unknown subcommand '%s'. Try %s HELP.`
+	ErrFmtNotifyLetter        = `ERR Invalid event class character '%s'. Use 'Ag$lshzxeKEtmdn'.`
+	ErrNoScript               = `NOSCRIPT No matching script. Please use EVAL.`
+	ErrNumkeysNegative        = `ERR Number of keys can't be negative`
+	ErrFmtScriptCompile       = `ERR Error compiling script (new function): %s`
+	ErrFmtScriptRun           = `ERR Error running script (call to %s): %s`
+	ErrScriptKilled           = `ERR Script killed by user with SCRIPT KILL...`
+	ErrNotBusy                = `NOTBUSY No scripts in execution right now.`
+	ErrBusyScript             = `BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.`
+	ErrBusyFunction           = `BUSY Redis is busy running a script. You can only call FUNCTION KILL or SHUTDOWN NOSAVE.`
+	ErrScriptNoArgs           = `ERR Please specify at least one argument for this redis lib call`
+	ErrScriptArgs             = `ERR Lua redis lib command arguments must be strings or integers`
+	ErrScriptUnknownCommand   = `ERR Unknown Redis command called from script`
+	ErrScriptNotAllowed       = `ERR This Redis command is not allowed from script`
+	ErrScriptWrite            = `ERR Write commands are not allowed from read-only scripts.`
+	ErrFmtUnknownArgument     = `ERR Unknown argument %s`
+	ErrLibraryMetadata        = `ERR Missing library metadata`
+	ErrFmtEngineNotFound      = `ERR Engine '%s' not found`
+	ErrFmtMetadataValue       = `ERR Invalid metadata value given: %s`
+	ErrLibraryName            = `ERR Library name was not given`
+	ErrLibraryNameChars       = `ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long`
+	ErrFunctionNameChars      = `ERR Function names can only contain letters, numbers, or underscores(_) and must be at least one character long`
+	ErrFmtFunctionCompile     = `ERR Error compiling function: %s`
+	ErrFmtFunctionLoad        = `ERR Error registering functions: %s`
+	ErrFunctionLoadTimeout    = `ERR FUNCTION LOAD timeout`
+	ErrNoFunctions            = `ERR No functions registered`
+	ErrFunctionInLibrary      = `ERR Function already exists in the library`
+	ErrRegisterArgs           = `ERR wrong number of arguments to redis.register_function`
+	ErrRegisterUnknownArg     = `ERR unknown argument given to redis.register_function`
+	ErrRegisterCallback       = `ERR callback argument given to redis.register_function must be a function`
+	ErrRegisterDescription    = `ERR description argument given to redis.register_function must be a string`
+	ErrRegisterFlags          = `ERR flags argument to redis.register_function must be a table representing function flags`
+	ErrUnknownFlag            = `ERR unknown flag given`
+	ErrFmtLibraryExists       = `ERR Library '%s' already exists`
+	ErrFmtFunctionExists      = `ERR Function %s already exists`
+	ErrLibraryNotFound        = `ERR Library not found`
+	ErrFunctionNotFound       = `ERR Function not found`
+	ErrFcallRoWrite           = `ERR Can not execute a script with write flag using *_ro command.`
+	ErrFunctionPayload        = `ERR payload version or checksum are wrong`
+	ErrRestorePolicy          = `ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.`
+	ErrFmtConfigUnknown       = `ERR Unknown option or number of arguments for CONFIG SET - '%s'`
+	ErrFmtConfigSet           = `ERR CONFIG SET failed (possibly related to argument '%s') - %s`
+	ErrConfigNotInt           = `ERR argument couldn't be parsed into an integer`
+	ErrFmtConfigRange         = `ERR argument must be between %d and %d inclusive`
+	ErrFmtConfigOneOf         = `ERR argument(s) must be one of the following: %s`
+	ErrNoConfigFile           = `ERR The server is running without a config file`
+	ErrFmtConfigRewrite       = `ERR Rewriting config file: %s`
+	ErrFmtInSubscribed        = `ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context`
 )
@@ -0,0 +1,323 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package command
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rod6/log6"
+
+	"github.com/rod6/rodis/config"
+	"github.com/rod6/rodis/resp"
+	"github.com/rod6/rodis/storage"
+)
+
+// CONFIG.
+// The parameters are the ones of config.RodisConfig, by their names in redis if they have
+// one, or else by their keys in the config file with the words hyphenated. CONFIG SET
+// changes config.Config and applies the values at once, so they take effect on the live
+// connections as well: the password is checked by requirePass on every command and AUTH.
+// ReloadConfig applies the config file the same way, on SIGHUP.
+
+// configParam is a parameter of CONFIG. get returns its value in a config, set checks a value
+// and returns the function applying it, set is nil if the parameter can not be changed at
+// runtime.
+type configParam struct {
+	name string
+	get  func(c *config.RodisConfig) string
+	set  func(value string) (func(), error)
+}
+
+// configMu serializes CONFIG SET, REWRITE and ReloadConfig.
+var configMu sync.Mutex
+
+// logLevels are the levels of log6 for loglevel.
+var logLevels = []string{"debug", "info", "warn", "error", "fatal"}
+
+var configParams = []configParam{
+	{"app", func(c *config.RodisConfig) string { return c.App }, nil},
+	{"version", func(c *config.RodisConfig) string { return strconv.FormatFloat(float64(c.Version), 'f', -1, 32) }, nil},
+	{"owner", func(c *config.RodisConfig) string { return c.Owner }, nil},
+	{"listen", func(c *config.RodisConfig) string { return c.Listen }, nil},
+	{"requirepass", func(c *config.RodisConfig) string { return c.RequirePass }, func(value string) (func(), error) {
+		return func() {
+			config.Update(func(c *config.RodisConfig) { c.RequirePass = value })
+		}, nil
+	}},
+	{"timeout", func(c *config.RodisConfig) string { return strconv.Itoa(c.Timeout) }, func(value string) (func(), error) {
+		n, err := configRange(value, 0, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			config.Update(func(c *config.RodisConfig) { c.Timeout = int(n) })
+		}, nil
+	}},
+	{"loglevel", func(c *config.RodisConfig) string { return c.LogLevel }, func(value string) (func(), error) {
+		level := strings.ToLower(value)
+		for _, l := range logLevels {
+			if level == l {
+				return func() {
+					config.Update(func(c *config.RodisConfig) { c.LogLevel = level })
+					log6.ParseLevel(level)
+				}, nil
+			}
+		}
+		return nil, resp.NewError(ErrFmtConfigOneOf, strings.Join(logLevels, ", "))
+	}},
+	{"leveldb-path", func(c *config.RodisConfig) string { return c.LevelDBPath }, nil},
+	{"expire-cycle-interval", func(c *config.RodisConfig) string {
+		return orDefault(int64(c.ExpireCycleInterval), int64(storage.DefaultExpireInterval/time.Millisecond))
+	}, func(value string) (func(), error) {
+		n, err := configInt(value, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			config.Update(func(c *config.RodisConfig) { c.ExpireCycleInterval = int(n) })
+			setExpireParams()
+		}, nil
+	}},
+	{"expire-max-keys", func(c *config.RodisConfig) string {
+		return orDefault(int64(c.ExpireMaxKeys), storage.DefaultExpireMaxKeys)
+	}, func(value string) (func(), error) {
+		n, err := configInt(value, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			config.Update(func(c *config.RodisConfig) { c.ExpireMaxKeys = int(n) })
+			setExpireParams()
+		}, nil
+	}},
+	{"proto-max-bulk-len", func(c *config.RodisConfig) string {
+		return orDefault(c.ProtoMaxBulkLen, resp.DefaultMaxBulkLen)
+	}, func(value string) (func(), error) {
+		n, err := configInt(value, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			config.Update(func(c *config.RodisConfig) { c.ProtoMaxBulkLen = n })
+			resp.SetMaxBulkLen(n)
+		}, nil
+	}},
+	{"proto-max-multibulk-len", func(c *config.RodisConfig) string {
+		return orDefault(c.ProtoMaxMultibulkLen, resp.DefaultMaxMultibulkLen)
+	}, func(value string) (func(), error) {
+		n, err := configInt(value, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			config.Update(func(c *config.RodisConfig) { c.ProtoMaxMultibulkLen = n })
+			resp.SetMaxMultibulkLen(n)
+		}, nil
+	}},
+	{"proto-max-nesting", func(c *config.RodisConfig) string {
+		return orDefault(int64(c.ProtoMaxNesting), resp.DefaultMaxNesting)
+	}, func(value string) (func(), error) {
+		n, err := configInt(value, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			config.Update(func(c *config.RodisConfig) { c.ProtoMaxNesting = int(n) })
+			resp.SetMaxNesting(int(n))
+		}, nil
+	}},
+	{"notify-keyspace-events", func(c *config.RodisConfig) string {
+		flags, err := parseNotifyFlags(c.NotifyKeyspaceEvents)
+		if err != nil {
+			return c.NotifyKeyspaceEvents // left to set to tell
+		}
+		return notifyFlagLetters(flags)
+	}, func(value string) (func(), error) {
+		if _, err := parseNotifyFlags(value); err != nil {
+			return nil, err
+		}
+		return func() {
+			config.Update(func(c *config.RodisConfig) { c.NotifyKeyspaceEvents = value })
+			SetNotifyKeyspaceEvents(value)
+		}, nil
+	}},
+}
+
+// requirePass returns the password of the server, "" for none.
+func requirePass() string {
+	return config.Get().RequirePass
+}
+
+// orDefault returns n of an integer parameter, or def if n is 0 for the default.
+func orDefault(n, def int64) string {
+	if n == 0 {
+		n = def
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// configInt parses the value of an integer parameter, between 1 and max.
+func configInt(value string, max int64) (int64, error) {
+	return configRange(value, 1, max)
+}
+
+// configRange parses the value of an integer parameter, between min and max.
+func configRange(value string, min, max int64) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, resp.NewError(ErrConfigNotInt)
+	}
+	if n < min || n > max {
+		return 0, resp.NewError(ErrFmtConfigRange, min, max)
+	}
+	return n, nil
+}
+
+// setExpireParams passes the expire parameters of the config to the running expirers, which
+// read them in every cycle, so it never waits for the expirers holding the locks of the dbs.
+func setExpireParams() {
+	c := config.Get()
+	storage.SetExpireParams(time.Duration(c.ExpireCycleInterval)*time.Millisecond, c.ExpireMaxKeys)
+}
+
+// CONFIG GET parameter [parameter ...] | SET parameter value [parameter value ...] |
+// RESETSTAT | REWRITE
+// use configx for config command, because config is the package of the config
+func configx(v resp.CommandArgs, ex *CommandExtras) error {
+	sub := strings.ToLower(v[0].String())
+	args := v[1:]
+
+	switch {
+	case sub == "get" && len(args) > 0:
+		c := config.Get()
+		reply := resp.Map{}
+		for _, p := range configParams {
+			for _, pattern := range args {
+				if MatchPattern([]byte(strings.ToLower(pattern.String())), []byte(p.name)) {
+					reply = append(reply, resp.BulkString(p.name), resp.BulkString(p.get(&c)))
+					break
+				}
+			}
+		}
+		return ex.reply(reply)
+	case sub == "set" && len(args) > 0 && len(args)%2 == 0:
+		return configSet(args, ex)
+	case sub == "resetstat" && len(args) == 0:
+		resetStats()
+		return ex.write(resp.OkSimpleString)
+	case sub == "rewrite" && len(args) == 0:
+		configMu.Lock()
+		defer configMu.Unlock()
+
+		if err := config.Rewrite(); err == config.ErrNoFile {
+			return ex.write(resp.NewError(ErrNoConfigFile))
+		} else if err != nil {
+			return ex.write(resp.NewError(ErrFmtConfigRewrite, err.Error()))
+		}
+		return ex.write(resp.OkSimpleString)
+	case sub == "get" || sub == "set" || sub == "resetstat" || sub == "rewrite":
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "config|"+sub))
+	}
+	return ex.write(resp.NewError(ErrFmtUnknownSubcommand, v[0].String(), "CONFIG"))
+}
+
+// configSet checks all the values before applying any, so none is applied if one is wrong.
+func configSet(args resp.CommandArgs, ex *CommandExtras) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	applies := []func(){}
+	seen := make(map[string]bool)
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(args[i].String())
+		var param *configParam
+		for j := range configParams {
+			if configParams[j].name == name {
+				param = &configParams[j]
+			}
+		}
+
+		switch {
+		case param == nil:
+			return ex.write(resp.NewError(ErrFmtConfigUnknown, args[i].String()))
+		case seen[name]:
+			return ex.write(resp.NewError(ErrFmtConfigSet, args[i].String(), "duplicate parameter"))
+		case param.set == nil:
+			return ex.write(resp.NewError(ErrFmtConfigSet, args[i].String(), "can't set immutable config"))
+		}
+		seen[name] = true
+
+		apply, err := param.set(args[i+1].String())
+		if err != nil {
+			reason := strings.TrimPrefix(err.Error(), "ERR ")
+			return ex.write(resp.NewError(ErrFmtConfigSet, args[i].String(), reason))
+		}
+		applies = append(applies, apply)
+	}
+
+	for _, apply := range applies {
+		apply()
+	}
+	return ex.write(resp.OkSimpleString)
+}
+
+// ReloadConfig applies c, the config file read again, to the running server as CONFIG SET
+// does: all the values changed are checked before any is applied, so none is applied if one
+// is wrong. The server listens on the new address of listen with the connections kept, the
+// other parameters which can not be changed at runtime are kept until a restart. It returns
+// the changes, to be logged.
+func ReloadConfig(c config.RodisConfig) ([]string, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	running := config.Get()
+	changes := []string{}
+	applies := []func(){}
+	listen := ""
+	for _, p := range configParams {
+		old, value := p.get(&running), p.get(&c)
+		if value == old {
+			continue
+		}
+
+		change := fmt.Sprintf("%s: %q -> %q", p.name, old, value)
+		if p.name == "requirepass" {
+			change = p.name + ": changed" // never logged
+		}
+		switch {
+		case p.name == "listen" && server != nil:
+			listen = value
+		case p.set == nil:
+			change += " (kept until a restart)"
+		default:
+			apply, err := p.set(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", p.name, strings.TrimPrefix(err.Error(), "ERR "))
+			}
+			applies = append(applies, apply)
+		}
+		changes = append(changes, change)
+	}
+
+	// listen is the last, so the server listens on the new address only if the others are right
+	if listen != "" {
+		if err := server.Listen(listen); err != nil {
+			return nil, fmt.Errorf("listen: %v", err)
+		}
+		applies = append(applies, func() {
+			config.Update(func(c *config.RodisConfig) { c.Listen = listen })
+		})
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	return changes, nil
+}
@@ -7,41 +7,138 @@
 package command
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/rod6/rodis/resp"
 	"github.com/rod6/rodis/storage"
-	"strconv"
 )
 
 func auth(v resp.CommandArgs, ex *CommandExtras) error {
-	if ex.Password == "" {
-		return resp.NewError(ErrNoNeedPassword).WriteTo(ex.Buffer)
+	password := requirePass()
+	if password == "" {
+		return ex.write(resp.NewError(ErrNoNeedPassword))
 	}
-	if v[0].String() != ex.Password {
+	if v[0].String() != password {
 		ex.IsConnAuthed = false
-		return resp.NewError(ErrWrongPassword).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongPassword))
 	}
 	ex.IsConnAuthed = true
-	return resp.OkSimpleString.WriteTo(ex.Buffer)
+	return ex.write(resp.OkSimpleString)
 }
 
 func echo(v resp.CommandArgs, ex *CommandExtras) error {
-	return v[0].WriteTo(ex.Buffer)
+	return ex.write(v[0])
 }
 
 func ping(v resp.CommandArgs, ex *CommandExtras) error {
-	return resp.PongSimpleString.WriteTo(ex.Buffer)
+	if ex.subscribed() { // not told from a message otherwise
+		return ex.write(resp.Array{resp.BulkString("pong"), resp.BulkString("")})
+	}
+	return ex.write(resp.PongSimpleString)
 }
 
 func selectDB(v resp.CommandArgs, ex *CommandExtras) error {
 	s := v[0].String()
 	index, err := strconv.Atoi(s)
 	if err != nil {
-		return resp.NewError(ErrSelectInvalidIndex).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrSelectInvalidIndex))
 	}
 
 	if index < 0 || index > 15 {
-		return resp.NewError(ErrSelectInvalidIndex).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrSelectInvalidIndex))
 	}
 	ex.DB = storage.SelectStorage(index)
-	return resp.OkSimpleString.WriteTo(ex.Buffer)
+	return ex.write(resp.OkSimpleString)
+}
+
+func quit(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.Quit = true
+	return ex.write(resp.OkSimpleString)
+}
+
+// reset sets the connection back to its state when connected: the transaction and the
+// watched keys are discarded, the subscriptions dropped without replies, the db, the
+// protocol and the name are the defaults, and the connection is authenticated again.
+func reset(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.resetMulti()
+	ex.unwatch()
+	if ex.PubSub != nil {
+		for kind := range subscribeNames {
+			for _, name := range ex.PubSub.Subscriptions(kind) {
+				ex.PubSub.Unsubscribe(kind, name)
+			}
+		}
+	}
+	ex.DB = storage.SelectStorage(0)
+	ex.Protocol, ex.ClientName = 2, nil
+	ex.IsConnAuthed = requirePass() == ""
+	return ex.write(resp.SimpleString("RESET"))
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func hello(v resp.CommandArgs, ex *CommandExtras) error {
+	proto := ex.Protocol
+	if len(v) > 0 {
+		p, err := strconv.Atoi(v[0].String())
+		if err != nil {
+			return ex.write(resp.NewError(ErrProtoNotInt))
+		}
+		if p != 2 && p != 3 {
+			return ex.write(resp.NewError(ErrNoProto))
+		}
+		proto = p
+	}
+
+	var user, pass, name []byte
+	for i := 1; i < len(v); i++ {
+		switch strings.ToLower(v[i].String()) {
+		case "auth":
+			if i+2 >= len(v) {
+				return ex.write(resp.NewError(ErrFmtHelloOption, v[i].String()))
+			}
+			user, pass = v[i+1], v[i+2]
+			i += 2
+		case "setname":
+			if i+1 >= len(v) {
+				return ex.write(resp.NewError(ErrFmtHelloOption, v[i].String()))
+			}
+			name = v[i+1]
+			i++
+		default:
+			return ex.write(resp.NewError(ErrFmtHelloOption, v[i].String()))
+		}
+	}
+
+	password := requirePass()
+	if user != nil {
+		// no ACL, the only user is "default" with the password of the server
+		if string(user) != "default" || (password != "" && string(pass) != password) {
+			return ex.write(resp.NewError(ErrWrongPass))
+		}
+		ex.IsConnAuthed = true
+	}
+	if !ex.IsConnAuthed && password != "" {
+		return ex.write(resp.NewError(ErrHelloNoAuth))
+	}
+
+	if name != nil {
+		for _, c := range name {
+			if c <= ' ' || c > '~' {
+				return ex.write(resp.NewError(ErrClientName))
+			}
+		}
+		ex.ClientName = name
+	}
+
+	ex.Protocol = proto
+	return ex.reply(resp.Map{
+		resp.BulkString("server"), resp.BulkString("rodis"),
+		resp.BulkString("version"), resp.BulkString(redisVersion),
+		resp.BulkString("proto"), resp.Integer(proto),
+		resp.BulkString("id"), resp.Integer(ex.ClientID),
+		resp.BulkString("mode"), resp.BulkString("standalone"),
+		resp.BulkString("role"), resp.BulkString("master"),
+		resp.BulkString("modules"), resp.EmptyArray,
+	})
 }
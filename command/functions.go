@@ -0,0 +1,647 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/rod6/rodis/resp"
+	"github.com/rod6/rodis/storage"
+)
+
+// Functions.
+// A library is Lua code starting with the metadata line "#!lua name=<library>", which
+// registers its functions by redis.register_function when run. The libraries are global, not
+// of a db, and saved in the storage, so they are loaded again at startup by LoadFunctions.
+// The code of a library is compiled once when loaded. The states the library is run in to
+// register the callbacks are kept by the library for the next FCALL, see library.state, and
+// the function called runs as a script does, see Scripting.
+
+func init() {
+	commands["function"] = &attr{function, -2}
+	commands["fcall"] = &attr{fcall, -3}
+	commands["fcall_ro"] = &attr{fcallRo, -3}
+}
+
+// functionName is the name of the chunk of every library, in the errors.
+const functionName = "user_function"
+
+// loadTimeout is the time a library may take to register its functions.
+const loadTimeout = 500 * time.Millisecond
+
+// dumpVersion is the version of the payload of FUNCTION DUMP, before its checksum.
+const dumpVersion = 1
+
+// functionFlags are the flags a function may be registered with.
+var functionFlags = map[string]bool{
+	"no-writes":             true,
+	"allow-oom":             true,
+	"allow-stale":           true,
+	"no-cluster":            true,
+	"allow-cross-slot-keys": true,
+}
+
+type library struct {
+	name      string
+	code      []byte
+	proto     *lua.FunctionProto
+	functions map[string]*luaFunction
+	states    chan *libraryState // the idle states of the library, see state
+}
+
+// libraryState is a state the library has run in, with the callbacks of its functions.
+type libraryState struct {
+	L         *lua.LState
+	ex        CommandExtras // the commands run with, see runLua
+	callbacks map[string]*lua.LFunction
+}
+
+// maxIdleStates is the number of the idle states kept by a library, for the FCALLs at the
+// same time on different dbs.
+const maxIdleStates = 4
+
+// state returns an idle state of the library, or a new one with the library run.
+func (lib *library) state() (*libraryState, error) {
+	select {
+	case s := <-lib.states:
+		return s, nil
+	default:
+	}
+
+	s := &libraryState{}
+	s.L = newScriptState(&s.ex)
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+	s.L.SetContext(ctx)
+	defer s.L.RemoveContext()
+
+	var err error
+	if _, s.callbacks, err = registerFunctions(s.L, lib.proto); err != nil {
+		s.L.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// putState puts back the state got by state, it is closed if enough are idle.
+func (lib *library) putState(s *libraryState) {
+	select {
+	case lib.states <- s:
+	default:
+		s.L.Close()
+	}
+}
+
+type luaFunction struct {
+	name        string
+	description string
+	flags       []string
+	library     *library
+}
+
+func (f *luaFunction) noWrites() bool {
+	for _, flag := range f.flags {
+		if flag == "no-writes" {
+			return true
+		}
+	}
+	return false
+}
+
+var functions = struct {
+	sync.RWMutex
+	libraries map[string]*library
+	functions map[string]*luaFunction
+}{libraries: make(map[string]*library), functions: make(map[string]*luaFunction)}
+
+// LoadFunctions loads the libraries saved in the storage, it should be called after the
+// storage is opened.
+func LoadFunctions() error {
+	codes, err := storage.FunctionLibraries()
+	if err != nil {
+		return err
+	}
+
+	libs := []*library{}
+	for _, code := range codes {
+		lib, err := loadLibrary(code)
+		if err != nil {
+			return err
+		}
+		libs = append(libs, lib)
+	}
+
+	functions.Lock()
+	defer functions.Unlock()
+	libraries, funcs, err := addLibraries(libs, false, true)
+	if err != nil {
+		return err
+	}
+	functions.libraries, functions.functions = libraries, funcs
+	return nil
+}
+
+// FCALL function numkeys [key ...] [arg ...]
+func fcall(v resp.CommandArgs, ex *CommandExtras) error {
+	return callFunction(v, false, ex)
+}
+
+// FCALL_RO function numkeys [key ...] [arg ...]
+func fcallRo(v resp.CommandArgs, ex *CommandExtras) error {
+	return callFunction(v, true, ex)
+}
+
+func callFunction(v resp.CommandArgs, ro bool, ex *CommandExtras) error {
+	keys, args, err := splitKeys(v[1:])
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	functions.RLock()
+	fn := functions.functions[v[0].String()]
+	functions.RUnlock()
+
+	if fn == nil {
+		return ex.write(resp.NewError(ErrFunctionNotFound))
+	}
+	if ro && !fn.noWrites() {
+		return ex.write(resp.NewError(ErrFcallRoWrite))
+	}
+
+	s, err := fn.library.state()
+	if err != nil {
+		return ex.write(scriptError(fn.name, err))
+	}
+	defer fn.library.putState(s)
+
+	L := s.L
+	return runLua(ex, functionKind, L, &s.ex, fn.noWrites(), fn.name, func() error {
+		if s.callbacks[fn.name] == nil {
+			return resp.NewError(ErrFunctionNotFound) // registered when loaded only
+		}
+		L.Push(s.callbacks[fn.name])
+		L.Push(argsTable(L, keys))
+		L.Push(argsTable(L, args))
+		return L.PCall(2, 1, nil)
+	})
+}
+
+// FUNCTION LOAD [REPLACE] code | DELETE library | FLUSH [ASYNC|SYNC] | KILL |
+// LIST [LIBRARYNAME pattern] [WITHCODE] | DUMP | RESTORE payload [FLUSH|APPEND|REPLACE]
+func function(v resp.CommandArgs, ex *CommandExtras) error {
+	sub := strings.ToLower(v[0].String())
+	args := v[1:]
+
+	switch {
+	case sub == "load" && (len(args) == 1 || len(args) == 2):
+		if len(args) == 2 && strings.ToLower(args[0].String()) != "replace" {
+			return ex.write(resp.NewError(ErrFmtUnknownArgument, args[0].String()))
+		}
+		lib, err := loadLibrary(args[len(args)-1])
+		if err != nil {
+			return ex.write(err.(resp.Error))
+		}
+		if err := saveLibraries([]*library{lib}, len(args) == 2, false); err != nil {
+			return replyError(err, ex)
+		}
+		return ex.write(resp.BulkString(lib.name))
+	case sub == "delete" && len(args) == 1:
+		functions.Lock()
+		defer functions.Unlock()
+
+		name := args[0].String()
+		lib := functions.libraries[name]
+		if lib == nil {
+			return ex.write(resp.NewError(ErrLibraryNotFound))
+		}
+		if err := storage.SaveFunctionLibraries(map[string][]byte{name: nil}, false); err != nil {
+			return err
+		}
+		delete(functions.libraries, name)
+		for fname := range lib.functions {
+			delete(functions.functions, fname)
+		}
+		return ex.write(resp.OkSimpleString)
+	case sub == "flush" && len(args) <= 1:
+		if len(args) == 1 {
+			mode := strings.ToLower(args[0].String())
+			if mode != "async" && mode != "sync" {
+				return ex.write(resp.NewError(ErrFmtSyntax))
+			}
+		}
+		if err := saveLibraries(nil, false, true); err != nil {
+			return replyError(err, ex)
+		}
+		return ex.write(resp.OkSimpleString)
+	case sub == "kill" && len(args) == 0:
+		return kill(functionKind, ex)
+	case sub == "list":
+		return listLibraries(args, ex)
+	case sub == "dump" && len(args) == 0:
+		return ex.write(resp.BulkString(dumpLibraries()))
+	case sub == "restore" && (len(args) == 1 || len(args) == 2):
+		replace, flush := false, false
+		if len(args) == 2 {
+			switch strings.ToLower(args[1].String()) {
+			case "flush":
+				flush = true
+			case "replace":
+				replace = true
+			case "append":
+			default:
+				return ex.write(resp.NewError(ErrRestorePolicy))
+			}
+		}
+		libs, err := restoreLibraries(args[0])
+		if err != nil {
+			return ex.write(err.(resp.Error))
+		}
+		if err := saveLibraries(libs, replace, flush); err != nil {
+			return replyError(err, ex)
+		}
+		return ex.write(resp.OkSimpleString)
+	case sub == "load" || sub == "delete" || sub == "flush" || sub == "kill" || sub == "dump" || sub == "restore":
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "function|"+sub))
+	}
+	return ex.write(resp.NewError(ErrFmtUnknownSubcommand, v[0].String(), "FUNCTION"))
+}
+
+// replyError replies the error if it is a reply, or returns it if it is of the storage.
+func replyError(err error, ex *CommandExtras) error {
+	if reply, ok := err.(resp.Error); ok {
+		return ex.write(reply)
+	}
+	return err
+}
+
+// listLibraries replies FUNCTION LIST, the libraries sorted by their names.
+func listLibraries(args resp.CommandArgs, ex *CommandExtras) error {
+	var pattern []byte
+	withCode := false
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i].String()) {
+		case "withcode":
+			withCode = true
+		case "libraryname":
+			if i+1 == len(args) || pattern != nil {
+				return ex.write(resp.NewError(ErrFmtSyntax))
+			}
+			i++
+			pattern = args[i]
+		default:
+			return ex.write(resp.NewError(ErrFmtUnknownArgument, args[i].String()))
+		}
+	}
+
+	functions.RLock()
+	defer functions.RUnlock()
+
+	reply := resp.Array{}
+	for _, lib := range sortedLibraries() {
+		if pattern != nil && !MatchPattern(pattern, []byte(lib.name)) {
+			continue
+		}
+
+		fnames := make([]string, 0, len(lib.functions))
+		for name := range lib.functions {
+			fnames = append(fnames, name)
+		}
+		sort.Strings(fnames)
+
+		funcs := make(resp.Array, len(fnames))
+		for i, name := range fnames {
+			fn := lib.functions[name]
+			description := resp.NilBulkString
+			if fn.description != "" {
+				description = resp.BulkString(fn.description)
+			}
+			flags := make(resp.Set, len(fn.flags))
+			for j, flag := range fn.flags {
+				flags[j] = resp.BulkString(flag)
+			}
+			funcs[i] = resp.Map{
+				resp.BulkString("name"), resp.BulkString(fn.name),
+				resp.BulkString("description"), description,
+				resp.BulkString("flags"), flags,
+			}
+		}
+
+		entry := resp.Map{
+			resp.BulkString("library_name"), resp.BulkString(lib.name),
+			resp.BulkString("engine"), resp.BulkString("LUA"),
+			resp.BulkString("functions"), funcs,
+		}
+		if withCode {
+			entry = append(entry, resp.BulkString("library_code"), resp.BulkString(lib.code))
+		}
+		reply = append(reply, entry)
+	}
+	return ex.reply(reply)
+}
+
+// sortedLibraries should be called with functions locked.
+func sortedLibraries() []*library {
+	libs := make([]*library, 0, len(functions.libraries))
+	for _, lib := range functions.libraries {
+		libs = append(libs, lib)
+	}
+	sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+	return libs
+}
+
+// dumpLibraries returns the payload of FUNCTION DUMP: the length and the code of every library,
+// then the version and the CRC32 of all before it.
+func dumpLibraries() []byte {
+	functions.RLock()
+	defer functions.RUnlock()
+
+	payload := []byte{}
+	for _, lib := range sortedLibraries() {
+		payload = binary.AppendUvarint(payload, uint64(len(lib.code)))
+		payload = append(payload, lib.code...)
+	}
+	payload = append(payload, dumpVersion)
+	return binary.LittleEndian.AppendUint32(payload, crc32.ChecksumIEEE(payload))
+}
+
+// restoreLibraries loads the libraries in the payload of FUNCTION DUMP.
+func restoreLibraries(payload []byte) ([]*library, error) {
+	n := len(payload) - 5
+	if n < 0 || payload[n] != dumpVersion || binary.LittleEndian.Uint32(payload[n+1:]) != crc32.ChecksumIEEE(payload[:n+1]) {
+		return nil, resp.NewError(ErrFunctionPayload)
+	}
+
+	libs := []*library{}
+	for data := payload[:n]; len(data) > 0; {
+		size, m := binary.Uvarint(data)
+		if m <= 0 || size > uint64(len(data)-m) {
+			return nil, resp.NewError(ErrFunctionPayload)
+		}
+		lib, err := loadLibrary(data[m : m+int(size)])
+		if err != nil {
+			return nil, err
+		}
+		libs = append(libs, lib)
+		data = data[m+int(size):]
+	}
+	return libs, nil
+}
+
+// saveLibraries adds the libraries as addLibraries does, and saves them.
+func saveLibraries(libs []*library, replace, flush bool) error {
+	functions.Lock()
+	defer functions.Unlock()
+
+	libraries, funcs, err := addLibraries(libs, replace, flush)
+	if err != nil {
+		return err
+	}
+
+	codes := make(map[string][]byte, len(libs))
+	for _, lib := range libs {
+		codes[lib.name] = lib.code
+	}
+	if err := storage.SaveFunctionLibraries(codes, flush); err != nil {
+		return err
+	}
+	functions.libraries, functions.functions = libraries, funcs
+	return nil
+}
+
+// addLibraries returns the libraries and the functions with libs added, to the ones before
+// unless flush, replacing the libraries of the same names if replace. It should be called with
+// functions locked, and changes nothing.
+func addLibraries(libs []*library, replace, flush bool) (map[string]*library, map[string]*luaFunction, error) {
+	libraries := make(map[string]*library)
+	funcs := make(map[string]*luaFunction)
+	if !flush {
+		for name, lib := range functions.libraries {
+			libraries[name] = lib
+		}
+		for name, fn := range functions.functions {
+			funcs[name] = fn
+		}
+	}
+
+	for _, lib := range libs {
+		if old := libraries[lib.name]; old != nil {
+			if !replace {
+				return nil, nil, resp.NewError(ErrFmtLibraryExists, lib.name)
+			}
+			for name := range old.functions {
+				delete(funcs, name)
+			}
+		}
+		for name, fn := range lib.functions {
+			if funcs[name] != nil {
+				return nil, nil, resp.NewError(ErrFmtFunctionExists, name)
+			}
+			funcs[name] = fn
+		}
+		libraries[lib.name] = lib
+	}
+	return libraries, funcs, nil
+}
+
+// loadLibrary compiles the library and runs it to get its functions.
+func loadLibrary(code []byte) (*library, error) {
+	if !bytes.HasPrefix(code, []byte("#!")) {
+		return nil, resp.NewError(ErrLibraryMetadata)
+	}
+	line := code
+	if i := bytes.IndexByte(code, '\n'); i >= 0 {
+		line = code[:i]
+	}
+
+	lib := &library{code: code, states: make(chan *libraryState, maxIdleStates)}
+	fields := strings.Fields(string(line[2:]))
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "lua") {
+		engine := ""
+		if len(fields) > 0 {
+			engine = fields[0]
+		}
+		return nil, resp.NewError(ErrFmtEngineNotFound, engine)
+	}
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "name=") {
+			return nil, resp.NewError(ErrFmtMetadataValue, field)
+		}
+		lib.name = field[len("name="):]
+	}
+	if lib.name == "" {
+		return nil, resp.NewError(ErrLibraryName)
+	}
+	if !validName(lib.name) {
+		return nil, resp.NewError(ErrLibraryNameChars)
+	}
+
+	// the metadata line is left out but its newline, to keep the line numbers
+	chunk, err := parse.Parse(bytes.NewReader(code[len(line):]), functionName)
+	if err != nil {
+		return nil, resp.NewError(ErrFmtFunctionCompile, oneLine(err.Error()))
+	}
+	lib.proto, err = lua.Compile(chunk, functionName)
+	if err != nil {
+		return nil, resp.NewError(ErrFmtFunctionCompile, oneLine(err.Error()))
+	}
+
+	// the library is run without redis.call and redis.pcall, and in a limited time
+	L := newScriptState(nil)
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	lib.functions, _, err = registerFunctions(L, lib.proto)
+	if ctx.Err() != nil {
+		return nil, resp.NewError(ErrFunctionLoadTimeout)
+	}
+	switch e := err.(type) {
+	case resp.Error:
+		return nil, e
+	case *lua.ApiError:
+		return nil, resp.NewError(ErrFmtFunctionLoad, oneLine(e.Object.String()))
+	case error:
+		return nil, resp.NewError(ErrFmtFunctionLoad, oneLine(e.Error()))
+	}
+	for _, fn := range lib.functions {
+		fn.library = lib
+	}
+	if len(lib.functions) == 0 {
+		return nil, resp.NewError(ErrNoFunctions)
+	}
+	return lib, nil
+}
+
+// registerFunctions runs the library in L, returns the functions it registers with their
+// callbacks in L. An error of redis.register_function is returned as its reply.
+func registerFunctions(L *lua.LState, proto *lua.FunctionProto) (map[string]*luaFunction, map[string]*lua.LFunction, error) {
+	funcs := make(map[string]*luaFunction)
+	callbacks := make(map[string]*lua.LFunction)
+	var reply error
+
+	register := func(L *lua.LState) int {
+		fn, callback, err := registerArgs(L)
+		if err == nil && funcs[fn.name] != nil {
+			err = resp.NewError(ErrFunctionInLibrary)
+		}
+		if err != nil {
+			reply = err
+			L.RaiseError("%s", err.Error())
+		}
+		funcs[fn.name], callbacks[fn.name] = fn, callback
+		return 0
+	}
+
+	redis := L.GetGlobal("redis").(*lua.LTable)
+	redis.RawSetString("register_function", L.NewFunction(register))
+	L.Push(L.NewFunctionFromProto(proto))
+	err := L.PCall(0, 0, nil)
+	redis.RawSetString("register_function", lua.LNil) // only when loading
+	if reply != nil {
+		return nil, nil, reply
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return funcs, callbacks, nil
+}
+
+// registerArgs gets the function of redis.register_function(name, callback), or of
+// redis.register_function{function_name=name, callback=callback, flags={...}, description=...}.
+func registerArgs(L *lua.LState) (*luaFunction, *lua.LFunction, error) {
+	fn := &luaFunction{flags: []string{}}
+	var callback *lua.LFunction
+
+	switch arg := L.Get(1).(type) {
+	case *lua.LTable:
+		if L.GetTop() != 1 {
+			return nil, nil, resp.NewError(ErrRegisterArgs)
+		}
+		var err error
+		arg.ForEach(func(k, v lua.LValue) {
+			if err != nil {
+				return
+			}
+			switch k.String() {
+			case "function_name":
+				name, _ := v.(lua.LString)
+				fn.name = string(name)
+			case "callback":
+				callback, _ = v.(*lua.LFunction)
+			case "description":
+				description, ok := v.(lua.LString)
+				if !ok {
+					err = resp.NewError(ErrRegisterDescription)
+				}
+				fn.description = string(description)
+			case "flags":
+				flags, ok := v.(*lua.LTable)
+				if !ok {
+					err = resp.NewError(ErrRegisterFlags)
+					return
+				}
+				err = registerFlags(flags, fn)
+			default:
+				err = resp.NewError(ErrRegisterUnknownArg)
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	case lua.LString:
+		if L.GetTop() != 2 {
+			return nil, nil, resp.NewError(ErrRegisterArgs)
+		}
+		fn.name = string(arg)
+		callback, _ = L.Get(2).(*lua.LFunction)
+	default:
+		return nil, nil, resp.NewError(ErrRegisterArgs)
+	}
+
+	if !validName(fn.name) {
+		return nil, nil, resp.NewError(ErrFunctionNameChars)
+	}
+	if callback == nil {
+		return nil, nil, resp.NewError(ErrRegisterCallback)
+	}
+	return fn, callback, nil
+}
+
+// registerFlags sets the flags of fn.
+func registerFlags(flags *lua.LTable, fn *luaFunction) error {
+	for i := 1; i <= flags.Len(); i++ {
+		flag, ok := flags.RawGetInt(i).(lua.LString)
+		if !ok || !functionFlags[string(flag)] {
+			return resp.NewError(ErrUnknownFlag)
+		}
+		fn.flags = append(fn.flags, string(flag))
+	}
+	return nil
+}
+
+// validName tells if name is made of letters, numbers and underscores.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -17,7 +17,7 @@ import (
 
 func hdel(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) < 2 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "hdel").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "hdel"))
 	}
 
 	ex.DB.Lock()
@@ -25,85 +25,75 @@ func hdel(v resp.CommandArgs, ex *CommandExtras) error {
 
 	keyExists, tipe, _ := ex.DB.Has(v[0])
 	if !keyExists {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
-	fields := [][]byte{}
-	for _, field := range v[1:] {
-		fields = append(fields, []byte(field))
+	count := ex.DB.DeleteHashFields(v[0], v[1:].ToBytes())
+	if count > 0 {
+		ex.notify(notifyHash, "hdel", v[0])
+		notifyEmptied(ex.DB, v[0])
 	}
-	hash := ex.DB.GetHashFields(v[0], fields)
-
-	count := 0
-	for _, value := range hash {
-		if value != nil {
-			count++
-		}
-	}
-	ex.DB.DeleteHashFields(v[0], fields)
-	return resp.Integer(count).WriteTo(ex.Buffer)
+	return ex.write(resp.Integer(count))
 }
 
 func hexists(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	keyExists, tipe, _ := ex.DB.Has(v[0])
+	keyExists, tipe, _ := ex.lookupRead(v[0])
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	hash := ex.DB.GetHashFields(v[0], [][]byte{v[1]})
 	if hash[string(v[1])] == nil {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
-	return resp.OneInteger.WriteTo(ex.Buffer)
+	return ex.write(resp.OneInteger)
 }
 
 func hget(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	keyExists, tipe, _ := ex.DB.Has(v[0])
+	keyExists, tipe, _ := ex.lookupRead(v[0])
 	if !keyExists {
-		return resp.NilBulkString.WriteTo(ex.Buffer)
+		return ex.write(resp.NilBulkString)
 	}
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	hash := ex.DB.GetHashFields(v[0], [][]byte{v[1]})
-	return resp.BulkString(hash[string(v[1])]).WriteTo(ex.Buffer)
+	return ex.write(resp.BulkString(hash[string(v[1])]))
 }
 
 func hgetall(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	keyExists, tipe, _ := ex.DB.Has(v[0])
+	keyExists, tipe, _ := ex.lookupRead(v[0])
 	if !keyExists {
-		return resp.EmptyArray.WriteTo(ex.Buffer)
+		return ex.reply(resp.Map{})
 	}
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
-	hash := ex.DB.GetHash(v[0])
-	arr := resp.Array{}
-
-	for field, value := range hash {
-		arr = append(arr, resp.BulkString([]byte(field)), resp.BulkString(value))
-	}
-	return arr.WriteTo(ex.Buffer)
+	m := resp.Map{}
+	ex.DB.HashScan(v[0], nil, 0, func(field, value []byte) {
+		m = append(m, resp.BulkString(field), resp.BulkString(value))
+	})
+	return ex.reply(m)
 }
 
 func hincrby(v resp.CommandArgs, ex *CommandExtras) error {
 	by, err := strconv.ParseInt(v[2].String(), 10, 64)
 	if err != nil {
-		return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidInt))
 	}
 
 	ex.DB.Lock()
@@ -111,7 +101,7 @@ func hincrby(v resp.CommandArgs, ex *CommandExtras) error {
 
 	keyExists, tipe, expireAt := ex.DB.Has(v[0])
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	hash := ex.DB.GetHashFields(v[0], [][]byte{v[1]})
@@ -122,20 +112,21 @@ func hincrby(v resp.CommandArgs, ex *CommandExtras) error {
 	} else {
 		i, err := strconv.ParseInt(string(hash[string(v[1])]), 10, 64)
 		if err != nil {
-			return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrNotValidInt))
 		}
 		newVal = i + by
 	}
 	hash[string(v[1])] = []byte(strconv.FormatInt(newVal, 10))
 
 	ex.DB.PutHash(v[0], hash, expireAt)
-	return resp.Integer(newVal).WriteTo(ex.Buffer)
+	ex.notify(notifyHash, "hincrby", v[0])
+	return ex.write(resp.Integer(newVal))
 }
 
 func hincrbyfloat(v resp.CommandArgs, ex *CommandExtras) error {
 	by, err := strconv.ParseFloat(v[2].String(), 64)
 	if err != nil {
-		return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidInt))
 	}
 
 	ex.DB.Lock()
@@ -143,7 +134,7 @@ func hincrbyfloat(v resp.CommandArgs, ex *CommandExtras) error {
 
 	keyExists, tipe, expireAt := ex.DB.Has(v[0])
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	hash := ex.DB.GetHashFields(v[0], [][]byte{v[1]})
@@ -154,26 +145,27 @@ func hincrbyfloat(v resp.CommandArgs, ex *CommandExtras) error {
 	} else {
 		f, err := strconv.ParseFloat(string(hash[string(v[1])]), 64)
 		if err != nil {
-			return resp.NewError(ErrNotValidFloat).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrNotValidFloat))
 		}
 		newVal = f + by
 	}
 	hash[string(v[1])] = []byte(strconv.FormatFloat(newVal, 'f', -1, 64))
 
 	ex.DB.PutHash(v[0], hash, expireAt)
-	return resp.BulkString(hash[string(v[1])]).WriteTo(ex.Buffer)
+	ex.notify(notifyHash, "hincrbyfloat", v[0])
+	return ex.write(resp.BulkString(hash[string(v[1])]))
 }
 
 func hkeys(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	keyExists, tipe, _ := ex.DB.Has(v[0])
+	keyExists, tipe, _ := ex.lookupRead(v[0])
 	if !keyExists {
-		return resp.EmptyArray.WriteTo(ex.Buffer)
+		return ex.write(resp.EmptyArray)
 	}
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	fields := ex.DB.GetHashFieldNames(v[0])
@@ -182,72 +174,69 @@ func hkeys(v resp.CommandArgs, ex *CommandExtras) error {
 	for _, field := range fields {
 		arr = append(arr, resp.BulkString(field))
 	}
-	return arr.WriteTo(ex.Buffer)
+	return ex.write(arr)
 }
 
 func hvals(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	keyExists, tipe, _ := ex.DB.Has(v[0])
+	keyExists, tipe, _ := ex.lookupRead(v[0])
 	if !keyExists {
-		return resp.EmptyArray.WriteTo(ex.Buffer)
+		return ex.write(resp.EmptyArray)
 	}
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
-	hash := ex.DB.GetHash(v[0])
 	arr := resp.Array{}
-
-	for _, value := range hash {
-		arr = append(arr, resp.BulkString([]byte(value)))
-	}
-	return arr.WriteTo(ex.Buffer)
+	ex.DB.HashScan(v[0], nil, 0, func(field, value []byte) {
+		arr = append(arr, resp.BulkString(value))
+	})
+	return ex.write(arr)
 }
 
 func hlen(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	keyExists, tipe, _ := ex.DB.Has(v[0])
+	keyExists, tipe, _ := ex.lookupRead(v[0])
 	if !keyExists {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
-	fields := ex.DB.GetHashFieldNames(v[0])
-	return resp.Integer(len(fields)).WriteTo(ex.Buffer)
+	return ex.write(resp.Integer(ex.DB.HashLength(v[0])))
 }
 
 func hmget(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) < 2 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "hmget").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "hmget"))
 	}
 
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	keyExists, tipe, _ := ex.DB.Has(v[0])
+	keyExists, tipe, _ := ex.lookupRead(v[0])
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	fields := v[1:].ToBytes()
 	hash := ex.DB.GetHashFields(v[0], fields)
 
 	arr := resp.Array{}
-	for _, value := range hash {
-		arr = append(arr, resp.BulkString(value))
+	for _, field := range fields {
+		arr = append(arr, resp.BulkString(hash[string(field)]))
 	}
-	return arr.WriteTo(ex.Buffer)
+	return ex.write(arr)
 }
 
 func hmset(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) <= 1 || len(v)%2 != 1 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "hmset").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "hmset"))
 	}
 
 	ex.DB.Lock()
@@ -255,7 +244,7 @@ func hmset(v resp.CommandArgs, ex *CommandExtras) error {
 
 	keyExists, tipe, expireAt := ex.DB.Has(v[0])
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	hash := make(map[string][]byte)
@@ -264,7 +253,8 @@ func hmset(v resp.CommandArgs, ex *CommandExtras) error {
 		i += 2
 	}
 	ex.DB.PutHash(v[0], hash, expireAt)
-	return resp.OkSimpleString.WriteTo(ex.Buffer)
+	ex.notify(notifyHash, "hset", v[0])
+	return ex.write(resp.OkSimpleString)
 }
 
 func hset(v resp.CommandArgs, ex *CommandExtras) error {
@@ -273,7 +263,7 @@ func hset(v resp.CommandArgs, ex *CommandExtras) error {
 
 	keyExists, tipe, expireAt := ex.DB.Has(v[0])
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	fieldExists := false
@@ -285,11 +275,12 @@ func hset(v resp.CommandArgs, ex *CommandExtras) error {
 
 	hash[string(v[1])] = v[2]
 	ex.DB.PutHash(v[0], hash, expireAt)
+	ex.notify(notifyHash, "hset", v[0])
 
 	if !fieldExists {
-		return resp.OneInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.OneInteger)
 	}
-	return resp.ZeroInteger.WriteTo(ex.Buffer)
+	return ex.write(resp.ZeroInteger)
 }
 
 func hsetnx(v resp.CommandArgs, ex *CommandExtras) error {
@@ -298,7 +289,7 @@ func hsetnx(v resp.CommandArgs, ex *CommandExtras) error {
 
 	keyExists, tipe, expireAt := ex.DB.Has(v[0])
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	fieldExists := false
@@ -311,23 +302,59 @@ func hsetnx(v resp.CommandArgs, ex *CommandExtras) error {
 	if !fieldExists {
 		hash[string(v[1])] = v[2]
 		ex.DB.PutHash(v[0], hash, expireAt)
-		return resp.OneInteger.WriteTo(ex.Buffer)
+		ex.notify(notifyHash, "hset", v[0])
+		return ex.write(resp.OneInteger)
 	}
-	return resp.ZeroInteger.WriteTo(ex.Buffer)
+	return ex.write(resp.ZeroInteger)
 }
 
 func hstrlen(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	keyExists, tipe, _ := ex.DB.Has(v[0])
+	keyExists, tipe, _ := ex.lookupRead(v[0])
 	if !keyExists {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	hash := ex.DB.GetHashFields(v[0], [][]byte{v[1]})
-	return resp.Integer(len(hash[string(v[1])])).WriteTo(ex.Buffer)
+	return ex.write(resp.Integer(len(hash[string(v[1])])))
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func hscan(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "hscan"))
+	}
+
+	args, err := parseScanArgs(v[1:], "novalues")
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	keyExists, tipe, _ := ex.lookupRead(v[0])
+	if !keyExists {
+		return ex.write(resp.Array{encodeCursor(nil), resp.EmptyArray})
+	}
+	if tipe != storage.Hash {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	arr := resp.Array{}
+	next := ex.DB.HashScan(v[0], args.cursor, args.count, func(field, value []byte) {
+		if args.pattern != nil && !MatchPattern(args.pattern, field) {
+			return
+		}
+		arr = append(arr, resp.BulkString(field))
+		if !args.novalue {
+			arr = append(arr, resp.BulkString(value))
+		}
+	})
+	return ex.write(resp.Array{encodeCursor(next), arr})
 }
@@ -0,0 +1,251 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rod6/rodis/config"
+	"github.com/rod6/rodis/resp"
+	"github.com/rod6/rodis/storage"
+)
+
+// INFO.
+// The sections are made of "field:value" lines as redis does. The stats are counted by the
+// commands: call counts every command run, also from EXEC and the scripts, and lookupRead
+// the keys looked up by the commands reading them.
+
+// Server tells the state of the connections, which the commands do not keep, and listens for
+// them.
+type Server interface {
+	ConnectedClients() int
+	TotalConnections() int64
+
+	// Listen makes the server listen on addr instead, the connections accepted are kept.
+	Listen(addr string) error
+}
+
+var server Server
+
+// SetServer sets the server of INFO and ReloadConfig.
+func SetServer(s Server) {
+	server = s
+}
+
+// startTime is the time the server started, for the uptime.
+var startTime = time.Now()
+
+var stats struct {
+	connections    int64 // the connections before CONFIG RESETSTAT
+	commands       int64 // the commands run
+	keyspaceHits   int64
+	keyspaceMisses int64
+	expiredKeys    int64
+}
+
+type commandStat struct {
+	calls int64
+	usec  int64
+}
+
+var commandStats = struct {
+	sync.Mutex
+	m map[string]*commandStat
+}{m: make(map[string]*commandStat)}
+
+// infoSections are the sections of INFO in order, the ones in the default INFO are marked.
+var infoSections = []struct {
+	name      string
+	byDefault bool
+	section   func(w *bytes.Buffer)
+}{
+	{"server", true, infoServer},
+	{"clients", true, infoClients},
+	{"stats", true, infoStats},
+	{"commandstats", false, infoCommandStats},
+	{"keyspace", true, infoKeyspace},
+	{"leveldb", false, infoLevelDB},
+}
+
+// call runs the command, counted in the stats.
+func call(cmd string, f commandFunc, args resp.CommandArgs, ex *CommandExtras) error {
+	start := time.Now()
+	err := f(args, ex)
+	usec := time.Since(start).Nanoseconds() / 1000
+
+	atomic.AddInt64(&stats.commands, 1)
+	commandStats.Lock()
+	stat, ok := commandStats.m[cmd]
+	if !ok {
+		stat = &commandStat{}
+		commandStats.m[cmd] = stat
+	}
+	stat.calls++
+	stat.usec += usec
+	commandStats.Unlock()
+	return err
+}
+
+// resetStats resets the stats, for CONFIG RESETSTAT.
+func resetStats() {
+	if server != nil {
+		atomic.StoreInt64(&stats.connections, server.TotalConnections())
+	}
+	atomic.StoreInt64(&stats.commands, 0)
+	atomic.StoreInt64(&stats.keyspaceHits, 0)
+	atomic.StoreInt64(&stats.keyspaceMisses, 0)
+	atomic.StoreInt64(&stats.expiredKeys, 0)
+
+	commandStats.Lock()
+	commandStats.m = make(map[string]*commandStat)
+	commandStats.Unlock()
+}
+
+// lookupRead looks up the key to read it, counted in the keyspace hits or misses.
+func (ex *CommandExtras) lookupRead(key []byte) (bool, byte, *time.Time) {
+	exists, tipe, expireAt := ex.DB.Has(key)
+	if exists {
+		atomic.AddInt64(&stats.keyspaceHits, 1)
+	} else {
+		atomic.AddInt64(&stats.keyspaceMisses, 1)
+	}
+	return exists, tipe, expireAt
+}
+
+// INFO [section [section ...]]
+func info(v resp.CommandArgs, ex *CommandExtras) error {
+	wanted := make(map[string]bool)
+	all, byDefault := false, len(v) == 0
+	for _, arg := range v {
+		switch section := strings.ToLower(arg.String()); section {
+		case "all", "everything":
+			all = true
+		case "default":
+			byDefault = true
+		default:
+			wanted[section] = true
+		}
+	}
+
+	var w bytes.Buffer
+	for _, s := range infoSections {
+		if !all && !(byDefault && s.byDefault) && !wanted[s.name] {
+			continue
+		}
+		if w.Len() > 0 {
+			w.WriteString("\r\n")
+		}
+		fmt.Fprintf(&w, "# %s\r\n", strings.ToUpper(s.name[:1])+s.name[1:])
+		s.section(&w)
+	}
+	return ex.reply(resp.Verbatim{Format: "txt", Text: []byte(w.String())}) // never nil, even if empty
+}
+
+// redisVersion is the version of redis whose commands rodis implements, for the clients
+// checking it in INFO and HELLO. The version of rodis itself is rodis_version.
+const redisVersion = "7.2.0"
+
+func infoServer(w *bytes.Buffer) {
+	uptime := int64(time.Since(startTime) / time.Second)
+	fmt.Fprintf(w, "redis_version:%s\r\n", redisVersion)
+	fmt.Fprintf(w, "rodis_version:%s\r\n", strconv.FormatFloat(float64(config.Get().Version), 'f', -1, 32))
+	w.WriteString("redis_mode:standalone\r\n")
+	fmt.Fprintf(w, "os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(w, "arch_bits:%d\r\n", strconv.IntSize)
+	fmt.Fprintf(w, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(w, "process_id:%d\r\n", os.Getpid())
+	if _, port, err := net.SplitHostPort(config.Get().Listen); err == nil {
+		fmt.Fprintf(w, "tcp_port:%s\r\n", port)
+	}
+	fmt.Fprintf(w, "uptime_in_seconds:%d\r\n", uptime)
+	fmt.Fprintf(w, "uptime_in_days:%d\r\n", uptime/(24*3600))
+}
+
+func infoClients(w *bytes.Buffer) {
+	clients := 0
+	if server != nil {
+		clients = server.ConnectedClients()
+	}
+	blocked.Lock()
+	blockedClients := blocked.clients
+	blocked.Unlock()
+
+	fmt.Fprintf(w, "connected_clients:%d\r\n", clients)
+	fmt.Fprintf(w, "blocked_clients:%d\r\n", blockedClients)
+}
+
+func infoStats(w *bytes.Buffer) {
+	connections := int64(0)
+	if server != nil {
+		connections = server.TotalConnections() - atomic.LoadInt64(&stats.connections)
+	}
+
+	fmt.Fprintf(w, "total_connections_received:%d\r\n", connections)
+	fmt.Fprintf(w, "total_commands_processed:%d\r\n", atomic.LoadInt64(&stats.commands))
+	fmt.Fprintf(w, "expired_keys:%d\r\n", atomic.LoadInt64(&stats.expiredKeys))
+	fmt.Fprintf(w, "keyspace_hits:%d\r\n", atomic.LoadInt64(&stats.keyspaceHits))
+	fmt.Fprintf(w, "keyspace_misses:%d\r\n", atomic.LoadInt64(&stats.keyspaceMisses))
+}
+
+func infoCommandStats(w *bytes.Buffer) {
+	commandStats.Lock()
+	defer commandStats.Unlock()
+
+	cmds := make([]string, 0, len(commandStats.m))
+	for cmd := range commandStats.m {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+
+	for _, cmd := range cmds {
+		stat := commandStats.m[cmd]
+		fmt.Fprintf(w, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f\r\n", cmd, stat.calls, stat.usec, float64(stat.usec)/float64(stat.calls))
+	}
+}
+
+// infoKeyspace tells the dbs with keys. The counters are read under their own lock, not the
+// one of the db, which EXEC or a script running INFO holds already.
+func infoKeyspace(w *bytes.Buffer) {
+	for i := 0; i < 16; i++ {
+		db := storage.SelectStorage(i)
+		keys := db.KeyCount()
+		expires, avgTTL := db.Expires()
+
+		if keys > 0 {
+			fmt.Fprintf(w, "db%d:keys=%d,expires=%d,avg_ttl=%d\r\n", i, keys, expires, avgTTL/time.Millisecond)
+		}
+	}
+}
+
+// leveldbProperties are the properties of the LevelDB of every db in INFO, the ones of many
+// lines are quoted to fit in one.
+var leveldbProperties = []string{"stats", "sstables", "cachedblock"}
+
+func infoLevelDB(w *bytes.Buffer) {
+	for i := 0; i < 16; i++ {
+		db := storage.SelectStorage(i)
+		for _, name := range leveldbProperties {
+			value, err := db.Property("leveldb." + name)
+			if err != nil {
+				continue
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(w, "db%d_%s:%s\r\n", i, name, value)
+		}
+	}
+}
@@ -7,6 +7,12 @@
 package command
 
 import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/rod6/rodis/resp"
 	"github.com/rod6/rodis/storage"
 )
@@ -15,7 +21,7 @@ import (
 
 func del(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) == 0 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "del").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "del"))
 	}
 
 	ex.DB.Lock()
@@ -23,25 +29,17 @@ func del(v resp.CommandArgs, ex *CommandExtras) error {
 
 	count := 0
 	for _, key := range v {
-		exists, tipe, _ := ex.DB.Has(key)
-		if !exists {
-			continue
-		}
-		switch tipe {
-		case storage.String:
-			ex.DB.DeleteString(key)
-		case storage.Hash:
-			ex.DB.DeleteHash(key)
+		if ex.DB.Delete(key) {
+			ex.notify(notifyGeneric, "del", key)
+			count++
 		}
-
-		count++
 	}
-	return resp.Integer(count).WriteTo(ex.Buffer)
+	return ex.write(resp.Integer(count))
 }
 
 func exists(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) == 0 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "exists").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "exists"))
 	}
 
 	ex.DB.RLock()
@@ -49,23 +47,381 @@ func exists(v resp.CommandArgs, ex *CommandExtras) error {
 
 	count := 0
 	for _, key := range v {
-		exists, _, _ := ex.DB.Has(key)
+		exists, _, _ := ex.lookupRead(key)
 		if !exists {
 			continue
 		}
 		count++
 	}
-	return resp.Integer(count).WriteTo(ex.Buffer)
+	return ex.write(resp.Integer(count))
 }
 
 func tipe(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	exists, tipe, _ := ex.DB.Has(v[0])
+	exists, tipe, _ := ex.lookupRead(v[0])
 
 	if !exists {
-		return resp.SimpleString(storage.TypeString[storage.None]).WriteTo(ex.Buffer)
+		return ex.write(resp.SimpleString(storage.TypeString[storage.None]))
+	}
+	return ex.write(resp.SimpleString(storage.TypeString[tipe]))
+}
+
+func keys(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	arr := resp.Array{}
+	ex.DB.Scan(nil, 0, func(key []byte, tipe byte) {
+		if MatchPattern(v[0], key) {
+			arr = append(arr, resp.BulkString(key))
+		}
+	})
+	return ex.write(arr)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func scan(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 1 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "scan"))
+	}
+
+	args, err := parseScanArgs(v, "type")
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	arr := resp.Array{}
+	next := ex.DB.Scan(args.cursor, args.count, func(key []byte, tipe byte) {
+		if args.pattern != nil && !MatchPattern(args.pattern, key) {
+			return
+		}
+		if args.tipe != "" && args.tipe != storage.TypeString[tipe] {
+			return
+		}
+		arr = append(arr, resp.BulkString(key))
+	})
+	return ex.write(resp.Array{encodeCursor(next), arr})
+}
+
+// keys.expire group, including expire, pexpire, expireat, pexpireat, ttl, pttl,
+// expiretime, pexpiretime, persist
+
+func expire(v resp.CommandArgs, ex *CommandExtras) error {
+	return expireHelper(v, ex, "expire", time.Second, false)
+}
+
+func pexpire(v resp.CommandArgs, ex *CommandExtras) error {
+	return expireHelper(v, ex, "pexpire", time.Millisecond, false)
+}
+
+func expireat(v resp.CommandArgs, ex *CommandExtras) error {
+	return expireHelper(v, ex, "expireat", time.Second, true)
+}
+
+func pexpireat(v resp.CommandArgs, ex *CommandExtras) error {
+	return expireHelper(v, ex, "pexpireat", time.Millisecond, true)
+}
+
+func ttl(v resp.CommandArgs, ex *CommandExtras) error {
+	return ttlHelper(v, ex, time.Second, false)
+}
+
+func pttl(v resp.CommandArgs, ex *CommandExtras) error {
+	return ttlHelper(v, ex, time.Millisecond, false)
+}
+
+func expiretime(v resp.CommandArgs, ex *CommandExtras) error {
+	return ttlHelper(v, ex, time.Second, true)
+}
+
+func pexpiretime(v resp.CommandArgs, ex *CommandExtras) error {
+	return ttlHelper(v, ex, time.Millisecond, true)
+}
+
+func persist(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, _, expireAt := ex.DB.Has(v[0])
+	if !exists || expireAt == nil {
+		return ex.write(resp.ZeroInteger)
+	}
+
+	ex.DB.SetExpire(v[0], nil)
+	ex.notify(notifyGeneric, "persist", v[0])
+	return ex.write(resp.OneInteger)
+}
+
+// keys.helper
+
+// expireHelper handles EXPIRE key when [NX|XX|GT|LT], `when` is in unit, and it is a
+// unix timestamp if absolute is true, otherwise it is relative to now.
+func expireHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, unit time.Duration, absolute bool) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
+	}
+
+	when, err := strconv.ParseInt(v[1].String(), 10, 64)
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+
+	nx, xx, gt, lt := false, false, false, false
+	for _, option := range v[2:] {
+		switch strings.ToLower(option.String()) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		default:
+			return ex.write(resp.NewError(ErrFmtUnsupportedOption, option.String()))
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return ex.write(resp.NewError(ErrNXAndXXGTLT))
+	}
+	if gt && lt {
+		return ex.write(resp.NewError(ErrGTAndLT))
+	}
+
+	expireAt, ok := expireTime(when, unit, absolute)
+	if !ok {
+		return ex.write(resp.NewError(ErrFmtInvalidExpireTime, cmd))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, _, curExpireAt := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+
+	// A key without expire time has an infinite ttl for GT and LT.
+	switch {
+	case nx && curExpireAt != nil,
+		xx && curExpireAt == nil,
+		gt && (curExpireAt == nil || !expireAt.After(*curExpireAt)),
+		lt && curExpireAt != nil && !expireAt.Before(*curExpireAt):
+		return ex.write(resp.ZeroInteger)
+	}
+
+	if !expireAt.After(time.Now()) { // expire time in the past, delete the key
+		ex.DB.Delete(v[0])
+		ex.notify(notifyGeneric, "del", v[0])
+	} else {
+		ex.DB.SetExpire(v[0], &expireAt)
+		ex.notify(notifyGeneric, "expire", v[0])
+	}
+	return ex.write(resp.OneInteger)
+}
+
+// expireTime converts the expire argument to time, returns false if it is out of range.
+func expireTime(when int64, unit time.Duration, absolute bool) (time.Time, bool) {
+	if when > math.MaxInt64/int64(unit) || when < math.MinInt64/int64(unit) {
+		return time.Time{}, false
+	}
+
+	d := time.Duration(when) * unit
+	if absolute {
+		return time.Unix(0, int64(d)), true
+	}
+
+	now := time.Now()
+	if d > 0 && now.UnixNano() > math.MaxInt64-int64(d) {
+		return time.Time{}, false
+	}
+	return now.Add(d), true
+}
+
+// ttlHelper replies the remaining time to live of a key in unit, or the absolute unix
+// time at which the key will expire if absolute is true. -2 if the key does not exist,
+// -1 if the key exists but has no expire time.
+func ttlHelper(v resp.CommandArgs, ex *CommandExtras, unit time.Duration, absolute bool) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, _, expireAt := ex.lookupRead(v[0])
+	if !exists {
+		return ex.write(resp.Integer(-2))
+	}
+	if expireAt == nil {
+		return ex.write(resp.NegativeOneInteger)
+	}
+
+	if absolute {
+		return ex.write(resp.Integer(expireAt.UnixNano() / int64(unit)))
+	}
+
+	ms := int64(expireAt.Sub(time.Now()) / time.Millisecond)
+	if ms < 0 {
+		ms = 0
+	}
+	if unit == time.Second {
+		return ex.write(resp.Integer((ms + 500) / 1000))
+	}
+	return ex.write(resp.Integer(ms))
+}
+
+// The cursor of SCAN and HSCAN is the last key (or field) returned, read as a big endian number
+// after a 0x01 byte, which keeps its leading zero bytes, and written in decimal. So the
+// iteration continues after it even if keys are added or deleted in between, or the server
+// restarts, and the clients parsing the cursor as a number work. "0" is to start, and is
+// returned when the iteration is done.
+func encodeCursor(next []byte) resp.BulkString {
+	if next == nil {
+		return resp.BulkString("0")
+	}
+	n := new(big.Int).SetBytes(append([]byte{0x01}, next...))
+	return resp.BulkString(n.String())
+}
+
+func decodeCursor(cursor resp.BulkString) ([]byte, bool) {
+	if cursor.String() == "0" {
+		return nil, true
+	}
+	for i, c := range cursor {
+		if c < '0' || c > '9' || i == 0 && c == '0' {
+			return nil, false
+		}
+	}
+	n, ok := new(big.Int).SetString(cursor.String(), 10)
+	if !ok {
+		return nil, false
+	}
+	b := n.Bytes()
+	if len(b) == 0 || b[0] != 0x01 {
+		return nil, false
+	}
+	return b[1:], true
+}
+
+type scanArgs struct {
+	cursor  []byte
+	pattern []byte
+	count   int
+	tipe    string
+	novalue bool
+}
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count] and the extra option of the
+// command, which is "type" for SCAN, "novalues" for HSCAN.
+func parseScanArgs(v resp.CommandArgs, extra string) (*scanArgs, error) {
+	cursor, ok := decodeCursor(v[0])
+	if !ok {
+		return nil, resp.NewError(ErrInvalidCursor)
+	}
+	args := &scanArgs{cursor: cursor, count: 10}
+
+	for i := 1; i < len(v); i++ {
+		option := strings.ToLower(v[i].String())
+		if option == "novalues" && extra == option {
+			args.novalue = true
+			continue
+		}
+		if i+1 >= len(v) {
+			return nil, resp.NewError(ErrFmtSyntax)
+		}
+
+		switch {
+		case option == "match":
+			args.pattern = v[i+1]
+		case option == "count":
+			count, err := strconv.Atoi(v[i+1].String())
+			if err != nil {
+				return nil, resp.NewError(ErrNotValidInt)
+			}
+			if count < 1 {
+				return nil, resp.NewError(ErrFmtSyntax)
+			}
+			args.count = count
+		case option == "type" && extra == option:
+			args.tipe = strings.ToLower(v[i+1].String())
+		default:
+			return nil, resp.NewError(ErrFmtSyntax)
+		}
+		i++
+	}
+	return args, nil
+}
+
+// MatchPattern reports if s matches the glob style pattern as redis does: '*', '?', '[abc]',
+// '[^abc]', '[a-z]', and '\' to escape.
+func MatchPattern(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if MatchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) > 1:
+					pattern = pattern[1:]
+					match = match || pattern[0] == s[0]
+				case len(pattern) > 2 && pattern[1] == '-':
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					match = match || s[0] >= start && s[0] <= end
+					pattern = pattern[2:]
+				default:
+					match = match || pattern[0] == s[0]
+				}
+				pattern = pattern[1:]
+			}
+			if match == not {
+				return false
+			}
+			s = s[1:]
+			if len(pattern) == 0 { // no closing ']', the class ends the pattern
+				return len(s) == 0
+			}
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
 	}
-	return resp.SimpleString(storage.TypeString[tipe]).WriteTo(ex.Buffer)
+	return len(s) == 0
 }
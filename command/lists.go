@@ -7,29 +7,627 @@
 package command
 
 import (
-	//"strconv"
+	"bytes"
+	"strconv"
+	"strings"
 
-	//"github.com/rod6/rodis/resp"
-	//"github.com/rod6/rodis/storage"
+	"github.com/rod6/rodis/resp"
+	"github.com/rod6/rodis/storage"
 )
 
 // Implement for command list in http://redis.io/commands#list
 
-/*
+// lists.push group, including lpush, rpush, lpushx, rpushx
 func lpush(v resp.CommandArgs, ex *CommandExtras) error {
-	if len(v) <= 1 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "lpush").WriteTo(ex.Buffer)
+	return pushHelper(v, ex, "lpush", true, false)
+}
+
+func rpush(v resp.CommandArgs, ex *CommandExtras) error {
+	return pushHelper(v, ex, "rpush", false, false)
+}
+
+func lpushx(v resp.CommandArgs, ex *CommandExtras) error {
+	return pushHelper(v, ex, "lpushx", true, true)
+}
+
+func rpushx(v resp.CommandArgs, ex *CommandExtras) error {
+	return pushHelper(v, ex, "rpushx", false, true)
+}
+
+// lists.pop group, including lpop, rpop, lmove, rpoplpush
+func lpop(v resp.CommandArgs, ex *CommandExtras) error {
+	return popHelper(v, ex, "lpop", true)
+}
+
+func rpop(v resp.CommandArgs, ex *CommandExtras) error {
+	return popHelper(v, ex, "rpop", false)
+}
+
+func lmove(v resp.CommandArgs, ex *CommandExtras) error {
+	fromLeft, ok := parseListDirection(v[2])
+	if !ok {
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+	toLeft, ok := parseListDirection(v[3])
+	if !ok {
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	return moveHelper(v[0], v[1], fromLeft, toLeft, ex)
+}
+
+func rpoplpush(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	return moveHelper(v[0], v[1], false, true, ex)
+}
+
+// lists.blocking group, including blpop, brpop, blmove, brpoplpush, blmpop, and lmpop
+func blpop(v resp.CommandArgs, ex *CommandExtras) error {
+	return bpopHelper(v, ex, "blpop", true)
+}
+
+func brpop(v resp.CommandArgs, ex *CommandExtras) error {
+	return bpopHelper(v, ex, "brpop", false)
+}
+
+func blmove(v resp.CommandArgs, ex *CommandExtras) error {
+	fromLeft, ok := parseListDirection(v[2])
+	if !ok {
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+	toLeft, ok := parseListDirection(v[3])
+	if !ok {
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+	timeout, err := parseTimeout(v[4])
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	return blockingHelper(ex, [][]byte{v[0]}, timeout, movePop(ex.DB, v[1], fromLeft, toLeft))
+}
+
+func brpoplpush(v resp.CommandArgs, ex *CommandExtras) error {
+	timeout, err := parseTimeout(v[2])
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	return blockingHelper(ex, [][]byte{v[0]}, timeout, movePop(ex.DB, v[1], false, true))
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func blmpop(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 4 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "blmpop"))
+	}
+
+	timeout, err := parseTimeout(v[0])
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+	keys, left, count, err := parseMpop(v[1:])
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	return blockingHelper(ex, keys, timeout, mpop(ex.DB, left, count))
+}
+
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func lmpop(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 3 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "lmpop"))
+	}
+
+	keys, left, count, err := parseMpop(v)
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	if reply, ok := popFirst(ex, keys, mpop(ex.DB, left, count)); ok {
+		return ex.write(reply)
+	}
+	return ex.write(resp.Array(nil))
+}
+
+// lists.read group, including llen, lindex, lrange, lpos
+func llen(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+	if tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	return ex.write(resp.Integer(ex.DB.ListLength(v[0])))
+}
+
+func lindex(v resp.CommandArgs, ex *CommandExtras) error {
+	index, err := strconv.Atoi(v[1].String())
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if !exists {
+		return ex.write(resp.NilBulkString)
+	}
+	if tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	return ex.write(resp.BulkString(ex.DB.ListIndex(v[0], index)))
+}
+
+func lrange(v resp.CommandArgs, ex *CommandExtras) error {
+	start, err := strconv.Atoi(v[1].String())
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+	stop, err := strconv.Atoi(v[2].String())
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if !exists {
+		return ex.write(resp.EmptyArray)
+	}
+	if tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	values := ex.DB.ListRange(v[0], start, stop)
+	arr := make(resp.Array, len(values))
+	for i, value := range values {
+		arr[i] = resp.BulkString(value)
+	}
+	return ex.write(arr)
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func lpos(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "lpos"))
+	}
+
+	rank := 1
+	count := -1 // -1 means no COUNT option, reply an integer instead of an array
+	maxlen := 0
+
+	for i := 2; i < len(v); i += 2 {
+		if i == len(v)-1 {
+			return ex.write(resp.NewError(ErrFmtSyntax))
+		}
+		n, err := strconv.Atoi(v[i+1].String())
+		if err != nil {
+			return ex.write(resp.NewError(ErrNotValidInt))
+		}
+
+		switch strings.ToLower(v[i].String()) {
+		case "rank":
+			if n == 0 {
+				return ex.write(resp.NewError(ErrLPosRankZero))
+			}
+			rank = n
+		case "count":
+			if n < 0 {
+				return ex.write(resp.NewError(ErrLPosCountNegative))
+			}
+			count = n
+		case "maxlen":
+			if n < 0 {
+				return ex.write(resp.NewError(ErrLPosMaxLenNegative))
+			}
+			maxlen = n
+		default:
+			return ex.write(resp.NewError(ErrFmtSyntax))
+		}
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if exists && tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	var values [][]byte
+	if exists {
+		values = ex.DB.GetList(v[0])
+	}
+
+	// Scan from the head for a positive rank, from the tail for a negative one, skip the
+	// first |rank|-1 matches, compare at most maxlen elements (0 means all).
+	arr := resp.Array{}
+	skip := rank - 1
+	step, i := 1, 0
+	if rank < 0 {
+		skip = -rank - 1
+		step, i = -1, len(values)-1
+	}
+	for compared := 0; i >= 0 && i < len(values); i += step {
+		if maxlen != 0 && compared == maxlen {
+			break
+		}
+		compared++
+
+		if !bytes.Equal(values[i], v[1]) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		arr = append(arr, resp.Integer(i))
+		if count == -1 || count != 0 && len(arr) == count {
+			break
+		}
+	}
+
+	if count != -1 {
+		return ex.write(arr)
+	}
+	if len(arr) == 0 {
+		return ex.write(resp.NilBulkString)
+	}
+	return ex.write(arr[0])
+}
+
+// lists.write group, including lset, ltrim, lrem, linsert
+func lset(v resp.CommandArgs, ex *CommandExtras) error {
+	index, err := strconv.Atoi(v[1].String())
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, _ := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.NewError(ErrNoSuchKey))
+	}
+	if tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	if !ex.DB.ListSet(v[0], index, v[2]) {
+		return ex.write(resp.NewError(ErrIndexOutRange))
+	}
+	ex.notify(notifyList, "lset", v[0])
+	return ex.write(resp.OkSimpleString)
+}
+
+func ltrim(v resp.CommandArgs, ex *CommandExtras) error {
+	start, err := strconv.Atoi(v[1].String())
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+	stop, err := strconv.Atoi(v[2].String())
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, _ := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.OkSimpleString)
+	}
+	if tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	ex.DB.ListTrim(v[0], start, stop)
+	ex.notify(notifyList, "ltrim", v[0])
+	notifyEmptied(ex.DB, v[0])
+	return ex.write(resp.OkSimpleString)
+}
+
+func lrem(v resp.CommandArgs, ex *CommandExtras) error {
+	count, err := strconv.Atoi(v[1].String())
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, expireAt := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+	if tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	// count > 0: remove from head to tail, count < 0: from tail to head, count = 0: all.
+	values := ex.DB.GetList(v[0])
+	removed := make([]bool, len(values))
+	n := 0
+	step, i := 1, 0
+	if count < 0 {
+		count = -count
+		step, i = -1, len(values)-1
+	}
+	for ; i >= 0 && i < len(values) && (count == 0 || n < count); i += step {
+		if bytes.Equal(values[i], v[2]) {
+			removed[i] = true
+			n++
+		}
+	}
+
+	if n == 0 {
+		return ex.write(resp.ZeroInteger)
+	}
+
+	kept := make([][]byte, 0, len(values)-n)
+	for i, value := range values {
+		if !removed[i] {
+			kept = append(kept, value)
+		}
+	}
+	ex.DB.PutList(v[0], kept, expireAt)
+	ex.notify(notifyList, "lrem", v[0])
+	notifyEmptied(ex.DB, v[0])
+	return ex.write(resp.Integer(n))
+}
+
+func linsert(v resp.CommandArgs, ex *CommandExtras) error {
+	var before bool
+	switch strings.ToLower(v[1].String()) {
+	case "before":
+		before = true
+	case "after":
+		before = false
+	default:
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, expireAt := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+	if tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	values := ex.DB.GetList(v[0])
+	pos := -1
+	for i, value := range values {
+		if bytes.Equal(value, v[2]) {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		return ex.write(resp.NegativeOneInteger)
+	}
+	if !before {
+		pos++
+	}
+
+	values = append(values, nil)
+	copy(values[pos+1:], values[pos:])
+	values[pos] = v[3]
+	ex.DB.PutList(v[0], values, expireAt)
+	ex.notify(notifyList, "linsert", v[0])
+	return ex.write(resp.Integer(len(values)))
+}
+
+// lists.helper
+
+func pushHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, left bool, onlyExists bool) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
 	}
 
 	ex.DB.Lock()
 	defer ex.DB.Unlock()
 
-	keyExists, tipe, expireAt := ex.DB.Has(v[0])
-	if keyExists && tipe != storage.Hash {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+	exists, tipe, expireAt := ex.DB.Has(v[0])
+	if exists && tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
 	}
+	if !exists && onlyExists {
+		return ex.write(resp.ZeroInteger)
+	}
+
+	l := ex.DB.ListPush(v[0], v[1:].ToBytes(), left, expireAt)
+	ex.notify(notifyList, pushEvent(left), v[0])
+	serveBlocked(ex.DB, v[0])
+	return ex.write(resp.Integer(l))
+}
 
-	l := ex.DB.ListPush(v[0], v[1:].ToBytes())
-	return resp.Integer(l).WriteTo(ex.Buffer)
+func popHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, left bool) error {
+	if len(v) != 1 && len(v) != 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
+	}
+
+	count := 1
+	if len(v) == 2 {
+		i, err := strconv.Atoi(v[1].String())
+		if err != nil || i < 0 {
+			return ex.write(resp.NewError(ErrMustBePositive))
+		}
+		count = i
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, _ := ex.DB.Has(v[0])
+	if !exists && len(v) == 1 {
+		return ex.write(resp.NilBulkString)
+	}
+	if !exists {
+		return ex.write(resp.Array(nil))
+	}
+	if tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	values := ex.DB.ListPop(v[0], count, left)
+	if len(values) > 0 {
+		notifyPop(ex.DB, v[0], left)
+	}
+	if len(v) == 1 {
+		return ex.write(resp.BulkString(values[0]))
+	}
+
+	arr := make(resp.Array, len(values))
+	for i, value := range values {
+		arr[i] = resp.BulkString(value)
+	}
+	return ex.write(arr)
+}
+
+// moveHelper pops an element from src and pushes it to dst, the caller should hold the
+// write lock.
+func moveHelper(src, dst []byte, fromLeft, toLeft bool, ex *CommandExtras) error {
+	exists, tipe, _ := ex.DB.Has(src)
+	if !exists {
+		return ex.write(resp.NilBulkString)
+	}
+	if tipe != storage.List {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	reply, pushed := movePop(ex.DB, dst, fromLeft, toLeft)(src)
+	if pushed != nil {
+		serveBlocked(ex.DB, pushed)
+	}
+	return ex.write(reply)
+}
+
+// movePop returns the popFunc which moves an element from the key to dst.
+func movePop(db *storage.LevelDB, dst []byte, fromLeft, toLeft bool) popFunc {
+	dst = append([]byte{}, dst...) // the args may be overwritten while the client is blocked
+	return func(key []byte) (resp.Value, []byte) {
+		dstExists, dstTipe, dstExpireAt := db.Has(dst)
+		if dstExists && dstTipe != storage.List {
+			return resp.NewError(ErrWrongType), nil
+		}
+
+		values := db.ListPop(key, 1, fromLeft)
+		notifyPop(db, key, fromLeft)
+		db.ListPush(dst, values, toLeft, dstExpireAt)
+		notify(db, notifyList, pushEvent(toLeft), dst)
+		return resp.BulkString(values[0]), dst
+	}
+}
+
+// mpop returns the popFunc which pops at most count elements, it replies the key and the
+// elements.
+func mpop(db *storage.LevelDB, left bool, count int) popFunc {
+	return func(key []byte) (resp.Value, []byte) {
+		values := db.ListPop(key, count, left)
+		notifyPop(db, key, left)
+		arr := make(resp.Array, len(values))
+		for i, value := range values {
+			arr[i] = resp.BulkString(value)
+		}
+		return resp.Array{resp.BulkString(append([]byte{}, key...)), arr}, nil
+	}
+}
+
+func bpopHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, left bool) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
+	}
+
+	timeout, err := parseTimeout(v[len(v)-1])
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	db := ex.DB
+	return blockingHelper(ex, v[:len(v)-1].ToBytes(), timeout, func(key []byte) (resp.Value, []byte) {
+		values := db.ListPop(key, 1, left)
+		notifyPop(db, key, left)
+		return resp.Array{resp.BulkString(append([]byte{}, key...)), resp.BulkString(values[0])}, nil
+	})
+}
+
+// parseMpop parses numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func parseMpop(v resp.CommandArgs) ([][]byte, bool, int, error) {
+	numkeys, err := strconv.Atoi(v[0].String())
+	if err != nil || numkeys <= 0 {
+		return nil, false, 0, resp.NewError(ErrNumkeysNotPositive)
+	}
+	if len(v) < numkeys+2 {
+		return nil, false, 0, resp.NewError(ErrFmtSyntax)
+	}
+
+	keys := v[1 : numkeys+1].ToBytes()
+	left, ok := parseListDirection(v[numkeys+1])
+	if !ok {
+		return nil, false, 0, resp.NewError(ErrFmtSyntax)
+	}
+
+	count := 1
+	switch rest := v[numkeys+2:]; {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToLower(rest[0].String()) == "count":
+		count, err = strconv.Atoi(rest[1].String())
+		if err != nil || count <= 0 {
+			return nil, false, 0, resp.NewError(ErrCountNotPositive)
+		}
+	default:
+		return nil, false, 0, resp.NewError(ErrFmtSyntax)
+	}
+	return keys, left, count, nil
+}
+
+// pushEvent is the event of the push to the left or the right.
+func pushEvent(left bool) string {
+	if left {
+		return "lpush"
+	}
+	return "rpush"
+}
+
+// notifyPop publishes the events of the pop from the left or the right of the list.
+func notifyPop(db *storage.LevelDB, key []byte, left bool) {
+	event := "rpop"
+	if left {
+		event = "lpop"
+	}
+	notify(db, notifyList, event, key)
+	notifyEmptied(db, key)
+}
+
+func parseListDirection(arg resp.BulkString) (bool, bool) {
+	switch strings.ToLower(arg.String()) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+	return false, false
 }
-*/
@@ -0,0 +1,162 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+// Package command is to handle the command from client.
+package command
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rod6/rodis/resp"
+	"github.com/rod6/rodis/storage"
+)
+
+// Keyspace notifications.
+// An event on a key is published to two channels: the event to __keyspace@<db>__:<key> and
+// the key to __keyevent@<db>__:<event>, for the classes of the events enabled by
+// notify-keyspace-events, with the flag letters of redis.
+
+// The classes of the events, and K, E for the two channels.
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g, the commands on any type, e.g. del, expire
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x
+	notifyEvicted              // e, never, no eviction in rodis
+	notifyStream               // t, never, no stream in rodis
+	notifyKeyMiss              // m, never, the reads are not told
+	notifyModule               // d, never, no module in rodis
+	notifyNew                  // n
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet |
+		notifyExpired | notifyEvicted | notifyStream | notifyModule // A
+)
+
+// notifyLetters are the flag letters, in the order of the classes.
+const notifyLetters = "KEg$lshzxetmdn"
+
+var notifyFlags int32
+
+// Publisher publishes a message to the subscribers of a channel, returns the number of the
+// receivers.
+type Publisher interface {
+	Publish(kind int, channel []byte, message []byte) int
+}
+
+var publisher Publisher
+
+// SetPublisher sets the publisher of the keyspace notifications, the broker of the server.
+func SetPublisher(p Publisher) {
+	publisher = p
+}
+
+// SetNotifyKeyspaceEvents enables the classes of the events by the flag letters, "" for none.
+func SetNotifyKeyspaceEvents(letters string) error {
+	flags, err := parseNotifyFlags(letters)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&notifyFlags, int32(flags))
+	return nil
+}
+
+func parseNotifyFlags(letters string) (int, error) {
+	flags := 0
+	for _, c := range letters {
+		if c == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		i := strings.IndexRune(notifyLetters, c)
+		if i < 0 {
+			return 0, resp.NewError(ErrFmtNotifyLetter, string(c))
+		}
+		flags |= 1 << uint(i)
+	}
+	return flags, nil
+}
+
+// notifyFlagLetters returns the flag letters of the classes of flags, as redis does.
+func notifyFlagLetters(flags int) string {
+	letters := ""
+	if flags&notifyAll == notifyAll {
+		letters = "A"
+	} else {
+		for i, c := range notifyLetters {
+			if class := 1 << uint(i); class&notifyAll != 0 && flags&class != 0 {
+				letters += string(c)
+			}
+		}
+	}
+	for _, c := range "KEmn" {
+		if flags&(1<<uint(strings.IndexRune(notifyLetters, c))) != 0 {
+			letters += string(c)
+		}
+	}
+	return letters
+}
+
+// notify publishes the event of class on key, if the class is enabled.
+func (ex *CommandExtras) notify(class int, event string, key []byte) {
+	notify(ex.DB, class, event, key)
+}
+
+// notifyEmptied publishes del if key is gone, after the last of its elements is removed.
+func notifyEmptied(db *storage.LevelDB, key []byte) {
+	if exists, _, _ := db.Has(key); !exists {
+		notify(db, notifyGeneric, "del", key)
+	}
+}
+
+// notifyStore publishes the event of a *store command on its destination key, which is deleted
+// rather than stored if the result is empty.
+func (ex *CommandExtras) notifyStore(class int, event string, key []byte, n int, existed bool) {
+	if n > 0 {
+		ex.notify(class, event, key)
+	} else if existed {
+		ex.notify(notifyGeneric, "del", key)
+	}
+}
+
+// notify publishes the event of class on key in db. In a transaction or a script, db is a
+// view and the event is published when it is committed, so never for the writes dropped.
+func notify(db *storage.LevelDB, class int, event string, key []byte) {
+	flags := int(atomic.LoadInt32(&notifyFlags))
+	if flags&class == 0 || publisher == nil {
+		return
+	}
+
+	key = append([]byte{}, key...)
+	db.Defer(func() {
+		index := strconv.Itoa(db.Index())
+		if flags&notifyKeyspace != 0 {
+			channel := append([]byte("__keyspace@"+index+"__:"), key...)
+			publisher.Publish(ChannelKind, channel, []byte(event))
+		}
+		if flags&notifyKeyevent != 0 {
+			channel := []byte("__keyevent@" + index + "__:" + event)
+			publisher.Publish(ChannelKind, channel, key)
+		}
+	})
+}
+
+// NotifyKeyEvent publishes the events of the keys found by the storage, see
+// storage.SetKeyEventFunc. The expired keys are counted for INFO as well.
+func NotifyKeyEvent(db *storage.LevelDB, event string, key []byte) {
+	switch event {
+	case "expired":
+		atomic.AddInt64(&stats.expiredKeys, 1)
+		notify(db, notifyExpired, event, key)
+	case "new":
+		notify(db, notifyNew, event, key)
+	}
+}
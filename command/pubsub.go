@@ -0,0 +1,180 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+// Package command is to handle the command from client.
+package command
+
+import (
+	"strings"
+
+	"github.com/rod6/rodis/resp"
+)
+
+// The kinds of the subscriptions.
+const (
+	ChannelKind = iota // SUBSCRIBE and PUBLISH
+	PatternKind        // PSUBSCRIBE, the messages published by PUBLISH to the matched channels
+	ShardKind          // SSUBSCRIBE and SPUBLISH, in their own namespace of channels
+)
+
+// PubSub is the subscriptions of a connection and the broker behind, it is implemented by the
+// server, which pushes the messages published to the subscribed connections.
+type PubSub interface {
+	// Subscribe and Unsubscribe add and remove the channel or pattern of kind for the
+	// connection.
+	Subscribe(kind int, name []byte)
+	Unsubscribe(kind int, name []byte)
+	// Subscriptions returns the channels or patterns of kind subscribed by the connection.
+	Subscriptions(kind int) [][]byte
+	// Count returns the number of the subscriptions of the connection, the patterns are
+	// counted with the channels as redis does.
+	Count(kind int) int
+
+	// Publish sends the message to the subscribers of the channel of kind, and of the patterns
+	// matching the channel for ChannelKind.
+	Publisher
+	// Channels returns the channels of kind with any subscriber matching pattern, all of them
+	// for a nil pattern.
+	Channels(kind int, pattern []byte) [][]byte
+	// NumSub returns the number of the subscribers of the channel of kind.
+	NumSub(kind int, channel []byte) int
+	// NumPat returns the number of the patterns with any subscriber.
+	NumPat() int
+}
+
+// In RESP2 a subscribed connection can only run the commands to manage the subscriptions, as
+// the messages pushed are not told from the replies.
+var inSubscribed = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+	"reset":        true,
+}
+
+// subscribed reports if the connection is in the subscribed mode of RESP2.
+func (ex *CommandExtras) subscribed() bool {
+	return ex.Protocol == 2 && ex.PubSub != nil && ex.PubSub.Count(ChannelKind)+ex.PubSub.Count(ShardKind) > 0
+}
+
+// The subscribe and unsubscribe commands of each kind, the name is the first element of the
+// replies.
+var subscribeNames = [...]struct{ subscribe, unsubscribe string }{
+	ChannelKind: {"subscribe", "unsubscribe"},
+	PatternKind: {"psubscribe", "punsubscribe"},
+	ShardKind:   {"ssubscribe", "sunsubscribe"},
+}
+
+// subscribeHelper subscribes to the channels or patterns, there is a reply for each.
+func subscribeHelper(kind int, v resp.CommandArgs, ex *CommandExtras) error {
+	for _, name := range v {
+		ex.PubSub.Subscribe(kind, name)
+		reply := resp.Push{resp.BulkString(subscribeNames[kind].subscribe), name, resp.Integer(ex.PubSub.Count(kind))}
+		if err := ex.reply(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsubscribeHelper unsubscribes from the channels or patterns, all of them if none is given.
+// There is a reply for each, or one with a nil name if there is no subscription.
+func unsubscribeHelper(kind int, v resp.CommandArgs, ex *CommandExtras) error {
+	names := v.ToBytes()
+	if len(names) == 0 {
+		names = ex.PubSub.Subscriptions(kind)
+	}
+	if len(names) == 0 {
+		reply := resp.Push{resp.BulkString(subscribeNames[kind].unsubscribe), resp.BulkString(nil), resp.Integer(ex.PubSub.Count(kind))}
+		return ex.reply(reply)
+	}
+
+	for _, name := range names {
+		ex.PubSub.Unsubscribe(kind, name)
+		reply := resp.Push{resp.BulkString(subscribeNames[kind].unsubscribe), resp.BulkString(name), resp.Integer(ex.PubSub.Count(kind))}
+		if err := ex.reply(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func subscribe(v resp.CommandArgs, ex *CommandExtras) error {
+	return subscribeHelper(ChannelKind, v, ex)
+}
+
+func unsubscribe(v resp.CommandArgs, ex *CommandExtras) error {
+	return unsubscribeHelper(ChannelKind, v, ex)
+}
+
+func psubscribe(v resp.CommandArgs, ex *CommandExtras) error {
+	return subscribeHelper(PatternKind, v, ex)
+}
+
+func punsubscribe(v resp.CommandArgs, ex *CommandExtras) error {
+	return unsubscribeHelper(PatternKind, v, ex)
+}
+
+func ssubscribe(v resp.CommandArgs, ex *CommandExtras) error {
+	return subscribeHelper(ShardKind, v, ex)
+}
+
+func sunsubscribe(v resp.CommandArgs, ex *CommandExtras) error {
+	return unsubscribeHelper(ShardKind, v, ex)
+}
+
+func publish(v resp.CommandArgs, ex *CommandExtras) error {
+	return ex.write(resp.Integer(ex.PubSub.Publish(ChannelKind, v[0], v[1])))
+}
+
+func spublish(v resp.CommandArgs, ex *CommandExtras) error {
+	return ex.write(resp.Integer(ex.PubSub.Publish(ShardKind, v[0], v[1])))
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT | SHARDCHANNELS [pattern] |
+// SHARDNUMSUB [channel ...]
+func pubsub(v resp.CommandArgs, ex *CommandExtras) error {
+	sub := strings.ToLower(v[0].String())
+	args := v[1:]
+
+	kind := ChannelKind
+	switch sub {
+	case "shardchannels", "shardnumsub":
+		kind = ShardKind
+	}
+
+	switch sub {
+	case "channels", "shardchannels":
+		if len(args) > 1 {
+			return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "pubsub|"+sub))
+		}
+		var pattern []byte
+		if len(args) == 1 {
+			pattern = args[0]
+		}
+		channels := ex.PubSub.Channels(kind, pattern)
+		reply := make(resp.Array, len(channels))
+		for i, channel := range channels {
+			reply[i] = resp.BulkString(channel)
+		}
+		return ex.write(reply)
+	case "numsub", "shardnumsub":
+		reply := make(resp.Array, 0, 2*len(args))
+		for _, channel := range args {
+			reply = append(reply, channel, resp.Integer(ex.PubSub.NumSub(kind, channel)))
+		}
+		return ex.write(reply)
+	case "numpat":
+		if len(args) > 0 {
+			return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "pubsub|"+sub))
+		}
+		return ex.write(resp.Integer(ex.PubSub.NumPat()))
+	}
+	return ex.write(resp.NewError(ErrFmtUnknownSubcommand, v[0].String(), "PUBSUB"))
+}
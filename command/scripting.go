@@ -0,0 +1,504 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+// Package command is to handle the command from client.
+package command
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rod6/log6"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/rod6/rodis/resp"
+)
+
+// Scripting.
+// EVAL runs a Lua script as a transaction does: on a view of the db under its write lock, so
+// no other command runs in between, with its writes committed in one batch at the end. The
+// writes are committed even if the script fails half way, as redis does, but not if it is
+// killed by SCRIPT KILL, or FUNCTION KILL for a function, so unlike redis a script which has
+// written can be killed as well. In a transaction the script writes to the view of EXEC, so
+// the writes before it is killed are committed with the other commands by EXEC.
+// redis.call and redis.pcall run the commands in the command table, with the replies in
+// RESP2 converted to the Lua values by the rules of redis. The scripts are compiled once and
+// cached by their SHA1, shared by all the connections.
+
+// The scripting commands look up the command table, so they are added to it in init.
+func init() {
+	commands["eval"] = &attr{eval, -3}
+	commands["evalsha"] = &attr{evalsha, -3}
+	commands["script"] = &attr{script, -2}
+}
+
+// notInScript are the commands not allowed from a script, besides the ones not allowed in a
+// transaction.
+var notInScript = map[string]bool{
+	"auth":     true,
+	"hello":    true,
+	"quit":     true,
+	"reset":    true,
+	"multi":    true,
+	"exec":     true,
+	"discard":  true,
+	"unwatch":  true,
+	"eval":     true,
+	"evalsha":  true,
+	"script":   true,
+	"function": true,
+	"fcall":    true,
+	"fcall_ro": true,
+	"config":   true,
+}
+
+// scriptName is the name of the chunk of every script, in the script errors.
+const scriptName = "user_script"
+
+var scripts = struct {
+	sync.RWMutex
+	protos map[string]*lua.FunctionProto // by SHA1 in hex
+}{protos: make(map[string]*lua.FunctionProto)}
+
+// The kinds of the Lua code running, killed by SCRIPT KILL and FUNCTION KILL respectively.
+const (
+	scriptKind = iota
+	functionKind
+)
+
+// running are the scripts and the functions running by kind, to be canceled by SCRIPT KILL
+// or FUNCTION KILL.
+var running = struct {
+	sync.Mutex
+	cancels [2]map[*lua.LState]context.CancelFunc
+}{cancels: [2]map[*lua.LState]context.CancelFunc{
+	scriptKind:   make(map[*lua.LState]context.CancelFunc),
+	functionKind: make(map[*lua.LState]context.CancelFunc),
+}}
+
+// busyErrors are the errors of killing the kind when only the other kind is running.
+var busyErrors = [2]string{scriptKind: ErrBusyFunction, functionKind: ErrBusyScript}
+
+// EVAL script numkeys [key ...] [arg ...]
+func eval(v resp.CommandArgs, ex *CommandExtras) error {
+	sha, proto, err := loadScript(v[0])
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+	return runScript(sha, proto, v[1:], ex)
+}
+
+// EVALSHA sha1 numkeys [key ...] [arg ...]
+func evalsha(v resp.CommandArgs, ex *CommandExtras) error {
+	sha := strings.ToLower(v[0].String())
+
+	scripts.RLock()
+	proto := scripts.protos[sha]
+	scripts.RUnlock()
+
+	if proto == nil {
+		return ex.write(resp.NewError(ErrNoScript))
+	}
+	return runScript(sha, proto, v[1:], ex)
+}
+
+// SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH [ASYNC|SYNC] | KILL
+func script(v resp.CommandArgs, ex *CommandExtras) error {
+	sub := strings.ToLower(v[0].String())
+	args := v[1:]
+
+	switch {
+	case sub == "load" && len(args) == 1:
+		sha, _, err := loadScript(args[0])
+		if err != nil {
+			return ex.write(err.(resp.Error))
+		}
+		return ex.write(resp.BulkString(sha))
+	case sub == "exists" && len(args) > 0:
+		scripts.RLock()
+		defer scripts.RUnlock()
+
+		reply := make(resp.Array, len(args))
+		for i, sha := range args {
+			reply[i] = resp.ZeroInteger
+			if scripts.protos[strings.ToLower(sha.String())] != nil {
+				reply[i] = resp.OneInteger
+			}
+		}
+		return ex.write(reply)
+	case sub == "flush" && len(args) <= 1:
+		if len(args) == 1 {
+			mode := strings.ToLower(args[0].String())
+			if mode != "async" && mode != "sync" {
+				return ex.write(resp.NewError(ErrFmtSyntax))
+			}
+		}
+		scripts.Lock()
+		scripts.protos = make(map[string]*lua.FunctionProto)
+		scripts.Unlock()
+		return ex.write(resp.OkSimpleString)
+	case sub == "kill" && len(args) == 0:
+		return kill(scriptKind, ex)
+	case sub == "load" || sub == "exists" || sub == "flush" || sub == "kill":
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "script|"+sub))
+	}
+	return ex.write(resp.NewError(ErrFmtUnknownSubcommand, v[0].String(), "SCRIPT"))
+}
+
+// loadScript compiles the script and caches it, returns its SHA1 in hex.
+func loadScript(source []byte) (string, *lua.FunctionProto, error) {
+	sum := sha1.Sum(source)
+	sha := hex.EncodeToString(sum[:])
+
+	scripts.RLock()
+	proto := scripts.protos[sha]
+	scripts.RUnlock()
+	if proto != nil {
+		return sha, proto, nil
+	}
+
+	chunk, err := parse.Parse(bytes.NewReader(source), scriptName)
+	if err != nil {
+		return "", nil, resp.NewError(ErrFmtScriptCompile, oneLine(err.Error()))
+	}
+	proto, err = lua.Compile(chunk, scriptName)
+	if err != nil {
+		return "", nil, resp.NewError(ErrFmtScriptCompile, oneLine(err.Error()))
+	}
+
+	scripts.Lock()
+	scripts.protos[sha] = proto
+	scripts.Unlock()
+	return sha, proto, nil
+}
+
+// runScript runs the script with numkeys [key ...] [arg ...].
+func runScript(sha string, proto *lua.FunctionProto, v resp.CommandArgs, ex *CommandExtras) error {
+	keys, args, err := splitKeys(v)
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	sx := new(CommandExtras)
+	L := newScriptState(sx)
+	defer L.Close()
+
+	return runLua(ex, scriptKind, L, sx, false, "f_"+sha, func() error {
+		L.SetGlobal("KEYS", argsTable(L, keys))
+		L.SetGlobal("ARGV", argsTable(L, args))
+		L.Push(L.NewFunctionFromProto(proto))
+		return L.PCall(0, 1, nil)
+	})
+}
+
+// splitKeys splits numkeys [key ...] [arg ...] into the keys and the args.
+func splitKeys(v resp.CommandArgs) (resp.CommandArgs, resp.CommandArgs, error) {
+	numkeys, err := strconv.Atoi(v[0].String())
+	if err != nil {
+		return nil, nil, resp.NewError(ErrNotValidInt)
+	}
+	if numkeys < 0 {
+		return nil, nil, resp.NewError(ErrNumkeysNegative)
+	}
+	if numkeys > len(v)-1 {
+		return nil, nil, resp.NewError(ErrNumkeysExceedArgs)
+	}
+	return v[1 : numkeys+1], v[numkeys+1:], nil
+}
+
+// runLua runs a script or a function of kind atomically in L, see Scripting. L runs the
+// commands with sx, which is set up from ex for this run. run leaves the result on the top of
+// the stack, the commands writing are not allowed if noWrites is true, and name is the one of
+// the script in the errors. The stack of L is left empty, so L may run again.
+func runLua(ex *CommandExtras, kind int, L *lua.LState, sx *CommandExtras, noWrites bool, name string, run func() error) error {
+	// In a transaction the db is a view locked by EXEC already.
+	db := ex.DB
+	view := db
+	if !ex.inExec {
+		db.Lock()
+		defer db.Unlock()
+		view = db.Begin()
+	}
+
+	// the commands of the script run as the ones of a transaction, replying in RESP2
+	*sx = *ex
+	sx.DB, sx.Protocol, sx.inExec, sx.noWrites, sx.inScript = view, 2, true, noWrites, true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+	running.Lock()
+	running.cancels[kind][L] = cancel
+	running.Unlock()
+	defer func() {
+		running.Lock()
+		delete(running.cancels[kind], L)
+		running.Unlock()
+	}()
+	defer L.SetTop(0)
+
+	err := run()
+	if ctx.Err() != nil {
+		return ex.write(resp.NewError(ErrScriptKilled))
+	}
+
+	if !ex.inExec {
+		view.Commit()
+		serveAllBlocked(db) // the pushes of the script
+	}
+
+	if err != nil {
+		return ex.write(scriptError(name, err))
+	}
+	return ex.write(luaToResp(L.Get(-1)))
+}
+
+// kill cancels the scripts or the functions running by kind, for SCRIPT KILL and FUNCTION
+// KILL.
+func kill(kind int, ex *CommandExtras) error {
+	running.Lock()
+	defer running.Unlock()
+
+	if len(running.cancels[kind]) == 0 {
+		if len(running.cancels[1-kind]) > 0 {
+			return ex.write(resp.NewError(busyErrors[kind]))
+		}
+		return ex.write(resp.NewError(ErrNotBusy))
+	}
+	for _, cancel := range running.cancels[kind] {
+		cancel()
+	}
+	return ex.write(resp.OkSimpleString)
+}
+
+// scriptError is the reply of the error of running the script of name, an error table raised
+// is replied as it is.
+func scriptError(name string, err error) resp.Error {
+	if e, ok := err.(*lua.ApiError); ok {
+		if reply, ok := luaToResp(e.Object).(resp.Error); ok {
+			return reply
+		}
+		return resp.NewError(ErrFmtScriptRun, name, oneLine(e.Object.String()))
+	}
+	return resp.NewError(ErrFmtScriptRun, name, oneLine(err.Error()))
+}
+
+// newScriptState returns a Lua state with the libraries safe for a script, and the redis
+// library running the commands with ex. Without ex, i.e. for loading a function library, the
+// redis library can not run any command.
+func newScriptState(ex *CommandExtras) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	L.SetGlobal("dofile", lua.LNil) // no file access
+	L.SetGlobal("loadfile", lua.LNil)
+
+	redis := L.NewTable()
+	if ex != nil {
+		L.SetFuncs(redis, map[string]lua.LGFunction{
+			"call": func(L *lua.LState) int {
+				return scriptCall(L, ex, false)
+			},
+			"pcall": func(L *lua.LState) int {
+				return scriptCall(L, ex, true)
+			},
+		})
+	}
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"error_reply": func(L *lua.LState) int {
+			t := L.NewTable()
+			t.RawSetString("err", lua.LString(L.CheckString(1)))
+			L.Push(t)
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			t := L.NewTable()
+			t.RawSetString("ok", lua.LString(L.CheckString(1)))
+			L.Push(t)
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			sum := sha1.Sum([]byte(L.CheckString(1)))
+			L.Push(lua.LString(hex.EncodeToString(sum[:])))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			level, message := L.CheckInt(1), L.CheckString(2)
+			switch level {
+			case logDebug, logVerbose:
+				log6.Debug("Script: %s", message)
+			case logNotice:
+				log6.Info("Script: %s", message)
+			case logWarning:
+				log6.Warn("Script: %s", message)
+			default:
+				L.ArgError(1, "Invalid log level.")
+			}
+			return 0
+		},
+	})
+	redis.RawSetString("LOG_DEBUG", lua.LNumber(logDebug))
+	redis.RawSetString("LOG_VERBOSE", lua.LNumber(logVerbose))
+	redis.RawSetString("LOG_NOTICE", lua.LNumber(logNotice))
+	redis.RawSetString("LOG_WARNING", lua.LNumber(logWarning))
+	L.SetGlobal("redis", redis)
+	return L
+}
+
+// The levels of redis.log.
+const (
+	logDebug = iota
+	logVerbose
+	logNotice
+	logWarning
+)
+
+// scriptCall is redis.call, or redis.pcall if protected, which returns the error table
+// rather than raising it.
+func scriptCall(L *lua.LState, ex *CommandExtras, protected bool) int {
+	var reply resp.Value
+	args := make(resp.CommandArgs, L.GetTop())
+	for i := range args {
+		switch arg := L.Get(i + 1).(type) {
+		case lua.LString:
+			args[i] = resp.BulkString(arg)
+		case lua.LNumber:
+			args[i] = resp.BulkString(arg.String())
+		default:
+			reply = resp.NewError(ErrScriptArgs)
+		}
+	}
+	if len(args) == 0 {
+		reply = resp.NewError(ErrScriptNoArgs)
+	}
+	if reply == nil {
+		reply = scriptCommand(args, ex)
+	}
+
+	value := respToLua(L, reply)
+	if _, ok := reply.(resp.Error); ok && !protected {
+		L.Error(value, 1)
+	}
+	L.Push(value)
+	return 1
+}
+
+// scriptCommand runs a command from a script, returns its reply.
+func scriptCommand(args resp.CommandArgs, ex *CommandExtras) resp.Value {
+	cmd := strings.ToLower(args[0].String())
+	a, ok := commands[cmd]
+	if !ok {
+		return resp.NewError(ErrScriptUnknownCommand)
+	}
+	if notInScript[cmd] || notInMulti[cmd] {
+		return resp.NewError(ErrScriptNotAllowed)
+	}
+	if !a.arity(len(args)) {
+		return resp.NewError(ErrFmtWrongNumberArgument, cmd)
+	}
+	if ex.noWrites && !readOnly[cmd] {
+		return resp.NewError(ErrScriptWrite)
+	}
+
+	ex.scriptReply = nil
+	if err := call(cmd, a.f, args[1:], ex); err != nil {
+		return resp.NewError(oneLine(err.Error()))
+	}
+	return ex.scriptReply
+}
+
+func argsTable(L *lua.LState, args resp.CommandArgs) *lua.LTable {
+	t := L.CreateTable(len(args), 0)
+	for _, arg := range args {
+		t.Append(lua.LString(arg))
+	}
+	return t
+}
+
+// respToLua converts a reply in RESP2 to the Lua value.
+func respToLua(L *lua.LState, v resp.Value) lua.LValue {
+	switch v := v.(type) {
+	case resp.SimpleString:
+		t := L.NewTable()
+		t.RawSetString("ok", lua.LString(v))
+		return t
+	case resp.Error:
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(v))
+		return t
+	case resp.Integer:
+		return lua.LNumber(v)
+	case resp.BulkString:
+		if v == nil {
+			return lua.LFalse
+		}
+		return lua.LString(v)
+	case resp.Array:
+		if v == nil {
+			return lua.LFalse
+		}
+		t := L.CreateTable(len(v), 0)
+		for _, e := range v {
+			t.Append(respToLua(L, e))
+		}
+		return t
+	}
+	return lua.LFalse
+}
+
+// luaToResp converts the Lua value to the reply. A number is truncated to an integer, and
+// an array ends at the first nil, as redis does.
+func luaToResp(lv lua.LValue) resp.Value {
+	switch lv := lv.(type) {
+	case lua.LBool:
+		if lv {
+			return resp.OneInteger
+		}
+	case lua.LNumber:
+		return resp.Integer(int64(lv))
+	case lua.LString:
+		return resp.BulkString(lv)
+	case *lua.LTable:
+		if err, ok := lv.RawGetString("err").(lua.LString); ok {
+			return resp.Error(oneLine(string(err)))
+		}
+		if status, ok := lv.RawGetString("ok").(lua.LString); ok {
+			return resp.SimpleString(oneLine(string(status)))
+		}
+		arr := resp.Array{}
+		for i := 1; ; i++ {
+			e := lv.RawGetInt(i)
+			if e == lua.LNil {
+				break
+			}
+			arr = append(arr, luaToResp(e))
+		}
+		return arr
+	}
+	return resp.NilBulkString
+}
+
+// oneLine makes s fit in an error or a simple string reply.
+func oneLine(s string) string {
+	return strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(s))
+}
@@ -17,5 +17,12 @@ func flushdb(v resp.CommandArgs, ex *CommandExtras) error {
 	if err := ex.DB.Flush(); err != nil {
 		return err
 	}
-	return resp.OkSimpleString.WriteTo(ex.Buffer)
+	return ex.write(resp.OkSimpleString)
+}
+
+func dbsize(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	return ex.write(resp.Integer(ex.DB.KeyCount()))
 }
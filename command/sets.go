@@ -0,0 +1,437 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+// Package command is to handle the command from client.
+package command
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/rod6/rodis/resp"
+	"github.com/rod6/rodis/storage"
+)
+
+// Implement for command list in http://redis.io/commands#set
+
+func sadd(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "sadd"))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, expireAt := ex.DB.Has(v[0])
+	if exists && tipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	added := ex.DB.SetAdd(v[0], v[1:].ToBytes(), expireAt)
+	if added > 0 {
+		ex.notify(notifySet, "sadd", v[0])
+	}
+	return ex.write(resp.Integer(added))
+}
+
+func srem(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "srem"))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, _ := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+	if tipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	removed := ex.DB.SetRemove(v[0], v[1:].ToBytes())
+	if removed > 0 {
+		ex.notify(notifySet, "srem", v[0])
+		notifyEmptied(ex.DB, v[0])
+	}
+	return ex.write(resp.Integer(removed))
+}
+
+func scard(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+	if tipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	return ex.write(resp.Integer(ex.DB.SetCard(v[0])))
+}
+
+func sismember(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+	if tipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	if ex.DB.SetIsMember(v[0], v[1]) {
+		return ex.write(resp.OneInteger)
+	}
+	return ex.write(resp.ZeroInteger)
+}
+
+func smismember(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "smismember"))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if exists && tipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	arr := make(resp.Array, len(v)-1)
+	for i, member := range v[1:] {
+		arr[i] = resp.ZeroInteger
+		if exists && ex.DB.SetIsMember(v[0], member) {
+			arr[i] = resp.OneInteger
+		}
+	}
+	return ex.write(arr)
+}
+
+func smembers(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if !exists {
+		return ex.reply(resp.Set{})
+	}
+	if tipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	return ex.reply(resp.Set(membersArray(ex.DB.GetSet(v[0]))))
+}
+
+// SPOP key [count]
+func spop(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) != 1 && len(v) != 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "spop"))
+	}
+
+	count := 1
+	if len(v) == 2 {
+		n, err := strconv.Atoi(v[1].String())
+		if err != nil || n < 0 {
+			return ex.write(resp.NewError(ErrMustBePositive))
+		}
+		count = n
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, _ := ex.DB.Has(v[0])
+	if exists && tipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+	if !exists {
+		if len(v) == 1 {
+			return ex.write(resp.NilBulkString)
+		}
+		return ex.write(resp.EmptyArray)
+	}
+
+	members := ex.DB.GetSet(v[0])
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	if count < len(members) {
+		members = members[:count]
+	}
+	ex.DB.SetRemove(v[0], members)
+	if len(members) > 0 {
+		ex.notify(notifySet, "spop", v[0])
+		notifyEmptied(ex.DB, v[0])
+	}
+
+	if len(v) == 1 {
+		return ex.write(resp.BulkString(members[0]))
+	}
+	return ex.reply(resp.Set(membersArray(members)))
+}
+
+// SRANDMEMBER key [count]
+// A positive count replies distinct members, a negative one allows the same member multiple
+// times, and replies exactly -count members.
+func srandmember(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) != 1 && len(v) != 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "srandmember"))
+	}
+
+	count := 1
+	if len(v) == 2 {
+		n, err := strconv.Atoi(v[1].String())
+		if err != nil {
+			return ex.write(resp.NewError(ErrNotValidInt))
+		}
+		count = n
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if exists && tipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+	if !exists {
+		if len(v) == 1 {
+			return ex.write(resp.NilBulkString)
+		}
+		return ex.write(resp.EmptyArray)
+	}
+
+	members := ex.DB.GetSet(v[0])
+	if len(v) == 1 {
+		return ex.write(resp.BulkString(members[rand.Intn(len(members))]))
+	}
+
+	if count < 0 {
+		arr := make(resp.Array, -count)
+		for i := range arr {
+			arr[i] = resp.BulkString(members[rand.Intn(len(members))])
+		}
+		return ex.write(arr)
+	}
+
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	if count < len(members) {
+		members = members[:count]
+	}
+	return ex.write(membersArray(members))
+}
+
+func smove(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	srcExists, srcTipe, _ := ex.DB.Has(v[0])
+	if srcExists && srcTipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+	dstExists, dstTipe, dstExpireAt := ex.DB.Has(v[1])
+	if dstExists && dstTipe != storage.Set {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	if !srcExists || !ex.DB.SetIsMember(v[0], v[2]) {
+		return ex.write(resp.ZeroInteger)
+	}
+	if string(v[0]) != string(v[1]) {
+		ex.DB.SetRemove(v[0], [][]byte{v[2]})
+		ex.notify(notifySet, "srem", v[0])
+		notifyEmptied(ex.DB, v[0])
+		ex.DB.SetAdd(v[1], [][]byte{v[2]}, dstExpireAt)
+		ex.notify(notifySet, "sadd", v[1])
+	}
+	return ex.write(resp.OneInteger)
+}
+
+// sets.algebra group, including sinter, sunion, sdiff, their *store variants, and sintercard
+func sinter(v resp.CommandArgs, ex *CommandExtras) error {
+	return setOpHelper(v, ex, "sinter", interSets)
+}
+
+func sunion(v resp.CommandArgs, ex *CommandExtras) error {
+	return setOpHelper(v, ex, "sunion", unionSets)
+}
+
+func sdiff(v resp.CommandArgs, ex *CommandExtras) error {
+	return setOpHelper(v, ex, "sdiff", diffSets)
+}
+
+func sinterstore(v resp.CommandArgs, ex *CommandExtras) error {
+	return setOpStoreHelper(v, ex, "sinterstore", interSets)
+}
+
+func sunionstore(v resp.CommandArgs, ex *CommandExtras) error {
+	return setOpStoreHelper(v, ex, "sunionstore", unionSets)
+}
+
+func sdiffstore(v resp.CommandArgs, ex *CommandExtras) error {
+	return setOpStoreHelper(v, ex, "sdiffstore", diffSets)
+}
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func sintercard(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "sintercard"))
+	}
+
+	numkeys, err := strconv.Atoi(v[0].String())
+	if err != nil || numkeys <= 0 {
+		return ex.write(resp.NewError(ErrNumkeysNotPositive))
+	}
+	if numkeys > len(v)-1 {
+		return ex.write(resp.NewError(ErrNumkeysExceedArgs))
+	}
+
+	limit := 0 // 0 means unlimited
+	switch rest := v[numkeys+1:]; {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToLower(rest[0].String()) == "limit":
+		limit, err = strconv.Atoi(rest[1].String())
+		if err != nil {
+			return ex.write(resp.NewError(ErrNotValidInt))
+		}
+		if limit < 0 {
+			return ex.write(resp.NewError(ErrLimitNegative))
+		}
+	default:
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	sets, ok := getSets(v[1:numkeys+1].ToBytes(), ex)
+	if !ok {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	card := len(interSets(sets))
+	if limit != 0 && card > limit {
+		card = limit
+	}
+	return ex.write(resp.Integer(card))
+}
+
+func setOpHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, op func([][][]byte) [][]byte) error {
+	if len(v) < 1 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	sets, ok := getSets(v.ToBytes(), ex)
+	if !ok {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+	return ex.reply(resp.Set(membersArray(op(sets))))
+}
+
+func setOpStoreHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, op func([][][]byte) [][]byte) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	sets, ok := getSets(v[1:].ToBytes(), ex)
+	if !ok {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	members := op(sets)
+	existed, _, _ := ex.DB.Has(v[0])
+	ex.DB.PutSet(v[0], members, nil)
+	ex.notifyStore(notifySet, cmd, v[0], len(members), existed)
+	return ex.write(resp.Integer(len(members)))
+}
+
+// getSets gets the members of the sets, a key which does not exist is an empty set.
+// Returns false if any key is not a set.
+func getSets(keys [][]byte, ex *CommandExtras) ([][][]byte, bool) {
+	sets := make([][][]byte, len(keys))
+	for i, key := range keys {
+		exists, tipe, _ := ex.lookupRead(key)
+		if !exists {
+			sets[i] = [][]byte{}
+			continue
+		}
+		if tipe != storage.Set {
+			return nil, false
+		}
+		sets[i] = ex.DB.GetSet(key)
+	}
+	return sets, true
+}
+
+// interSets, unionSets and diffSets keep the order of the members as they first appear.
+func interSets(sets [][][]byte) [][]byte {
+	result := sets[0]
+	for _, set := range sets[1:] {
+		in := make(map[string]bool, len(set))
+		for _, member := range set {
+			in[string(member)] = true
+		}
+
+		next := [][]byte{}
+		for _, member := range result {
+			if in[string(member)] {
+				next = append(next, member)
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+func unionSets(sets [][][]byte) [][]byte {
+	result := [][]byte{}
+	seen := make(map[string]bool)
+	for _, set := range sets {
+		for _, member := range set {
+			if !seen[string(member)] {
+				seen[string(member)] = true
+				result = append(result, member)
+			}
+		}
+	}
+	return result
+}
+
+func diffSets(sets [][][]byte) [][]byte {
+	others := make(map[string]bool)
+	for _, set := range sets[1:] {
+		for _, member := range set {
+			others[string(member)] = true
+		}
+	}
+
+	result := [][]byte{}
+	for _, member := range sets[0] {
+		if !others[string(member)] {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+func membersArray(members [][]byte) resp.Array {
+	arr := make(resp.Array, len(members))
+	for i, member := range members {
+		arr[i] = resp.BulkString(member)
+	}
+	return arr
+}
@@ -27,6 +27,8 @@ import (
 // DECRBY		done		rod
 // GET			done		rod
 // GETBIT		done		rod
+// GETDEL		done		rod
+// GETEX		done		rod
 // GETRANGE		done		rod
 // GETSET		done		rod
 // INCR			done		rod
@@ -35,10 +37,10 @@ import (
 // MGET			done		rod
 // MSET			done		rod
 // MSETNX		done		rod
-// PSETEX		pending				waiting for expire solution
-// SET			partial		rod     EX/PX option, waiting for expire solution
+// PSETEX		done		rod
+// SET			done		rod
 // SETBIT		done		rod
-// SETEX		pending				waiting for expire solution
+// SETEX		done		rod
 // SETNX		done		rod
 // SETRANGE		done		rod
 // STRLEN		done		rod
@@ -46,10 +48,19 @@ import (
 
 const STRLIMIT = 536870912 // 512M
 
-// strings.basic group, including set, get, getrange, setrange, append, strlen, setnx, setxx, getset
+// setOptionGroups are the groups of the options of SET, an option of a group may be given
+// only if no option of the group is given before.
+var setOptionGroups = map[string]string{
+	"nx": "nx", "xx": "nx",
+	"get":     "get",
+	"keepttl": "expire", "ex": "expire", "px": "expire", "exat": "expire", "pxat": "expire",
+}
+
+// strings.basic group, including set, get, getrange, setrange, append, strlen, setnx, setxx, getset,
+// setex, psetex, getex, getdel
 func set(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) <= 1 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "set").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "set"))
 	}
 
 	ex.DB.Lock()
@@ -57,17 +68,27 @@ func set(v resp.CommandArgs, ex *CommandExtras) error {
 
 	if len(v) == 2 {
 		ex.DB.PutString(v[0], v[1], nil)
-		return resp.OkSimpleString.WriteTo(ex.Buffer)
+		ex.notify(notifyString, "set", v[0])
+		return ex.write(resp.OkSimpleString)
 	}
 
 	option_nx := false
 	option_xx := false
+	option_get := false
 	expire_op := ""
 	expire_val := int64(0)
 
+	seen := make(map[string]bool) // the groups of the options given
+
 	offset := 2
 	for offset < len(v) {
 		option := strings.ToLower(string(v[offset]))
+		if group, ok := setOptionGroups[option]; ok {
+			if seen[group] {
+				return ex.write(resp.NewError(ErrFmtSyntax))
+			}
+			seen[group] = true
+		}
 		switch option {
 		case "xx":
 			option_xx = true
@@ -75,62 +96,166 @@ func set(v resp.CommandArgs, ex *CommandExtras) error {
 		case "nx":
 			option_nx = true
 			offset++
-		case "ex", "px":
+		case "get":
+			option_get = true
+			offset++
+		case "keepttl":
+			expire_op = option
+			offset++
+		case "ex", "px", "exat", "pxat":
 			if offset == len(v)-1 { // no value more
-				return resp.NewError(ErrFmtSyntax).WriteTo(ex.Buffer)
+				return ex.write(resp.NewError(ErrFmtSyntax))
 			}
 			if i, err := strconv.ParseInt(string(v[offset+1]), 10, 64); err != nil {
-				return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+				return ex.write(resp.NewError(ErrNotValidInt))
 			} else {
 				expire_op = option
 				expire_val = i
 			}
 			offset += 2
 		default:
-			return resp.NewError(ErrFmtSyntax).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrFmtSyntax))
 		}
 	}
 
-	if option_nx && option_xx {
-		return resp.NilBulkString.WriteTo(ex.Buffer)
+	var expireAt *time.Time
+	if expire_op != "" && expire_op != "keepttl" {
+		t, ok := expireOptionTime(expire_op, expire_val)
+		if !ok {
+			return ex.write(resp.NewError(ErrFmtInvalidExpireTime, "set"))
+		}
+		expireAt = &t
 	}
 
-	exists, _, _ := ex.DB.Has(v[0])
-	if option_nx && exists {
-		return resp.NilBulkString.WriteTo(ex.Buffer)
+	exists, tipe, oldExpireAt := ex.DB.Has(v[0])
+	if option_get && exists && tipe != storage.String {
+		return ex.write(resp.NewError(ErrWrongType))
 	}
-	if option_xx && !exists {
-		return resp.NilBulkString.WriteTo(ex.Buffer)
+
+	var oldValue []byte // nil for a missing key
+	if option_get && exists {
+		oldValue = ex.DB.GetString(v[0])
+	}
+
+	if option_nx && exists || option_xx && !exists {
+		if option_get {
+			return ex.write(resp.BulkString(oldValue))
+		}
+		return ex.write(resp.NilBulkString)
 	}
 	if len(v[1]) > STRLIMIT {
-		return resp.NewError(ErrStringExccedLimit).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrStringExccedLimit))
 	}
 
-	var expireAt time.Time
-	switch expire_op {
-	case "ex":
-		expireAt = time.Now().Add(time.Duration(expire_val) * time.Second)
-	case "px":
-		expireAt = time.Now().Add(time.Duration(expire_val) * time.Millisecond)
+	if expire_op == "keepttl" {
+		expireAt = oldExpireAt
+	}
+
+	ex.DB.PutString(v[0], v[1], expireAt)
+	ex.notify(notifyString, "set", v[0])
+	if expireAt != nil && expire_op != "keepttl" {
+		ex.notify(notifyGeneric, "expire", v[0])
+	}
+	if option_get {
+		return ex.write(resp.BulkString(oldValue))
+	}
+	return ex.write(resp.OkSimpleString)
+}
+
+func setex(v resp.CommandArgs, ex *CommandExtras) error {
+	return setexHelper(v, ex, "setex", "ex")
+}
+
+func psetex(v resp.CommandArgs, ex *CommandExtras) error {
+	return setexHelper(v, ex, "psetex", "px")
+}
+
+func getdel(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, _ := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.NilBulkString)
+	}
+	if tipe != storage.String {
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
-	ex.DB.PutString(v[0], v[1], &expireAt)
-	return resp.OkSimpleString.WriteTo(ex.Buffer)
+	val := ex.DB.GetString(v[0])
+	ex.DB.DeleteString(v[0])
+	ex.notify(notifyGeneric, "del", v[0])
+	return ex.write(resp.BulkString(val))
+}
+
+func getex(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) == 0 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "getex"))
+	}
+
+	expire_op := ""
+	var expireAt *time.Time
+
+	switch {
+	case len(v) == 2 && strings.ToLower(string(v[1])) == "persist":
+		expire_op = "persist"
+	case len(v) == 3:
+		expire_op = strings.ToLower(string(v[1]))
+		if expire_op != "ex" && expire_op != "px" && expire_op != "exat" && expire_op != "pxat" {
+			return ex.write(resp.NewError(ErrFmtSyntax))
+		}
+		i, err := strconv.ParseInt(string(v[2]), 10, 64)
+		if err != nil {
+			return ex.write(resp.NewError(ErrNotValidInt))
+		}
+		t, ok := expireOptionTime(expire_op, i)
+		if !ok {
+			return ex.write(resp.NewError(ErrFmtInvalidExpireTime, "getex"))
+		}
+		expireAt = &t
+	case len(v) != 1:
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, oldExpireAt := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.NilBulkString)
+	}
+	if tipe != storage.String {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	val := ex.DB.GetString(v[0])
+	switch {
+	case expire_op == "persist" && oldExpireAt != nil:
+		ex.DB.SetExpire(v[0], nil)
+		ex.notify(notifyGeneric, "persist", v[0])
+	case expireAt != nil && !expireAt.After(time.Now()):
+		ex.DB.DeleteString(v[0])
+		ex.notify(notifyGeneric, "del", v[0])
+	case expireAt != nil:
+		ex.DB.SetExpire(v[0], expireAt)
+		ex.notify(notifyGeneric, "expire", v[0])
+	}
+	return ex.write(resp.BulkString(val))
 }
 
 func get(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	exists, tipe, _ := ex.DB.Has(v[0])
+	exists, tipe, _ := ex.lookupRead(v[0])
 	if !exists {
-		return resp.NilBulkString.WriteTo(ex.Buffer)
+		return ex.write(resp.NilBulkString)
 	}
 	if tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 	val := ex.DB.GetString(v[0])
-	return resp.BulkString(val).WriteTo(ex.Buffer)
+	return ex.write(resp.BulkString(val))
 }
 
 // use appendx for append command, because append is a key word of golang
@@ -140,7 +265,7 @@ func appendx(v resp.CommandArgs, ex *CommandExtras) error {
 
 	exists, tipe, expireAt := ex.DB.Has(v[0])
 	if exists && tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	val := []byte("")
@@ -148,56 +273,57 @@ func appendx(v resp.CommandArgs, ex *CommandExtras) error {
 		val = ex.DB.GetString(v[0])
 	}
 	if len(val)+len(v[1]) > STRLIMIT {
-		return resp.NewError(ErrStringExccedLimit).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrStringExccedLimit))
 	}
 
 	val = append(val, v[1]...)
 	ex.DB.PutString(v[0], val, expireAt)
-	return resp.Integer(len(val)).WriteTo(ex.Buffer)
+	ex.notify(notifyString, "append", v[0])
+	return ex.write(resp.Integer(len(val)))
 }
 
 func getrange(v resp.CommandArgs, ex *CommandExtras) error {
 	start, err := strconv.Atoi(string(v[1]))
 	if err != nil {
-		return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidInt))
 	}
 
 	end, err := strconv.Atoi(string(v[2]))
 	if err != nil {
-		return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidInt))
 	}
 
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	exists, tipe, _ := ex.DB.Has(v[0])
+	exists, tipe, _ := ex.lookupRead(v[0])
 	if !exists {
-		return resp.EmptyBulkString.WriteTo(ex.Buffer)
+		return ex.write(resp.EmptyBulkString)
 	}
 	if tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	val := ex.DB.GetString(v[0])
 	start, end = calcRange(start, end, len(val))
 	if end <= start {
-		return resp.EmptyBulkString.WriteTo(ex.Buffer)
+		return ex.write(resp.EmptyBulkString)
 	}
 
-	return resp.BulkString(val[start:end]).WriteTo(ex.Buffer)
+	return ex.write(resp.BulkString(val[start:end]))
 }
 
 func setrange(v resp.CommandArgs, ex *CommandExtras) error {
 	i64, err := strconv.ParseInt(string(v[1]), 10, 32)
 	if err != nil {
-		return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidInt))
 	}
 	offset := int(i64)
 	if offset < 0 {
-		return resp.NewError(ErrOffsetOutRange).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrOffsetOutRange))
 	}
 	if offset+len(v[2]) > 536870912 { // 512M is the limit length
-		return resp.NewError(ErrStringExccedLimit).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrStringExccedLimit))
 	}
 
 	ex.DB.Lock()
@@ -205,7 +331,7 @@ func setrange(v resp.CommandArgs, ex *CommandExtras) error {
 
 	exists, tipe, expireAt := ex.DB.Has(v[0])
 	if exists && tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	val := []byte("")
@@ -219,23 +345,24 @@ func setrange(v resp.CommandArgs, ex *CommandExtras) error {
 	copy(val[offset:], v[2])
 
 	ex.DB.PutString(v[0], val, expireAt)
-	return resp.Integer(len(val)).WriteTo(ex.Buffer)
+	ex.notify(notifyString, "setrange", v[0])
+	return ex.write(resp.Integer(len(val)))
 }
 
 func strlen(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	exists, tipe, _ := ex.DB.Has(v[0])
+	exists, tipe, _ := ex.lookupRead(v[0])
 	if !exists {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 	if tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	val := ex.DB.GetString(v[0])
-	return resp.Integer(len(val)).WriteTo(ex.Buffer)
+	return ex.write(resp.Integer(len(val)))
 }
 
 func setnx(v resp.CommandArgs, ex *CommandExtras) error {
@@ -244,17 +371,18 @@ func setnx(v resp.CommandArgs, ex *CommandExtras) error {
 
 	exists, _, _ := ex.DB.Has(v[0])
 	if exists {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 
 	ex.DB.PutString(v[0], v[1], nil)
-	return resp.OneInteger.WriteTo(ex.Buffer)
+	ex.notify(notifyString, "set", v[0])
+	return ex.write(resp.OneInteger)
 
 }
 
 func getset(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v[1]) > STRLIMIT {
-		return resp.NewError(ErrStringExccedLimit).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrStringExccedLimit))
 	}
 
 	ex.DB.Lock()
@@ -262,7 +390,7 @@ func getset(v resp.CommandArgs, ex *CommandExtras) error {
 
 	exists, tipe, expireAt := ex.DB.Has(v[0])
 	if exists && tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 	var oldValue []byte
 	if exists {
@@ -270,18 +398,19 @@ func getset(v resp.CommandArgs, ex *CommandExtras) error {
 	}
 
 	ex.DB.PutString(v[0], v[1], expireAt)
+	ex.notify(notifyString, "set", v[0])
 
 	if !exists {
-		return resp.NilBulkString.WriteTo(ex.Buffer)
+		return ex.write(resp.NilBulkString)
 	}
-	return resp.BulkString(oldValue).WriteTo(ex.Buffer)
+	return ex.write(resp.BulkString(oldValue))
 }
 
 // strings.multi, includng mget, mset, msetnx
 
 func mget(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) < 1 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "mget").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "mget"))
 	}
 
 	ex.DB.RLock()
@@ -289,7 +418,7 @@ func mget(v resp.CommandArgs, ex *CommandExtras) error {
 
 	arr := make(resp.Array, len(v))
 	for i, g := range v {
-		exists, tipe, _ := ex.DB.Has(g)
+		exists, tipe, _ := ex.lookupRead(g)
 		if !exists || tipe != storage.String {
 			arr[i] = resp.NilBulkString
 		} else {
@@ -298,12 +427,12 @@ func mget(v resp.CommandArgs, ex *CommandExtras) error {
 		}
 	}
 
-	return arr.WriteTo(ex.Buffer)
+	return ex.write(arr)
 }
 
 func mset(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) == 0 || len(v)%2 != 0 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "mset").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "mset"))
 	}
 
 	ex.DB.Lock()
@@ -311,15 +440,16 @@ func mset(v resp.CommandArgs, ex *CommandExtras) error {
 
 	for i := 0; i < len(v); {
 		ex.DB.PutString(v[i], v[i+1], nil)
+		ex.notify(notifyString, "set", v[i])
 		i += 2
 	}
 
-	return resp.OkSimpleString.WriteTo(ex.Buffer)
+	return ex.write(resp.OkSimpleString)
 }
 
 func msetnx(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) == 0 || len(v)%2 != 0 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "msetnx").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "msetnx"))
 	}
 
 	ex.DB.Lock()
@@ -328,17 +458,18 @@ func msetnx(v resp.CommandArgs, ex *CommandExtras) error {
 	for i := 0; i < len(v); {
 		exists, _, _ := ex.DB.Has(v[i])
 		if exists {
-			return resp.ZeroInteger.WriteTo(ex.Buffer) // If any key exists, return 0
+			return ex.write(resp.ZeroInteger) // If any key exists, return 0
 		}
 		i += 2
 	}
 
 	for i := 0; i < len(v); { // every key does not exist, put all into level db.
 		ex.DB.PutString(v[i], v[i+1], nil)
+		ex.notify(notifyString, "set", v[i])
 		i += 2
 	}
 
-	return resp.OneInteger.WriteTo(ex.Buffer)
+	return ex.write(resp.OneInteger)
 }
 
 // strings.bits, including getbit, bitcount, bitop, bitpos
@@ -347,48 +478,48 @@ func getbit(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	exists, tipe, _ := ex.DB.Has(v[0])
+	exists, tipe, _ := ex.lookupRead(v[0])
 	if !exists {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 	if tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	val := ex.DB.GetString(v[0])
 
 	offset, err := strconv.Atoi(string(v[1]))
 	if err != nil {
-		return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidInt))
 	}
 
 	if offset >= 8*len(val) {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 
 	byten := offset / 8
 	pos := offset % 8
 
 	k := val[byten] >> uint32(7-pos) & 0x01
-	return resp.Integer(k).WriteTo(ex.Buffer)
+	return ex.write(resp.Integer(k))
 }
 
 func setbit(v resp.CommandArgs, ex *CommandExtras) error {
 	i64, err := strconv.ParseInt(string(v[1]), 10, 32)
 	if err != nil {
-		return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidInt))
 	}
 	offset := uint32(i64)
 	pos := offset % 8
 	byten := offset / 8
 
 	if int(byten)+1 > STRLIMIT {
-		return resp.NewError(ErrStringExccedLimit).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrStringExccedLimit))
 	}
 
 	bit, err := strconv.Atoi(string(v[2]))
 	if err != nil || bit != 0 && bit != 1 {
-		return resp.NewError(ErrBitValueInvalid).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrBitValueInvalid))
 	}
 
 	ex.DB.Lock()
@@ -396,7 +527,7 @@ func setbit(v resp.CommandArgs, ex *CommandExtras) error {
 
 	exists, tipe, expireAt := ex.DB.Has(v[0])
 	if exists && tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	val := []byte("")
@@ -420,27 +551,28 @@ func setbit(v resp.CommandArgs, ex *CommandExtras) error {
 	}
 
 	ex.DB.PutString(v[0], val, expireAt)
-	return resp.Integer(k).WriteTo(ex.Buffer)
+	ex.notify(notifyString, "setbit", v[0])
+	return ex.write(resp.Integer(k))
 }
 
 func bitcount(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) == 0 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "bitcount").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "bitcount"))
 	}
 
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	exists, tipe, _ := ex.DB.Has(v[0])
+	exists, tipe, _ := ex.lookupRead(v[0])
 	if !exists {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 	if tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	if len(v) != 1 && len(v) != 3 {
-		return resp.NewError(ErrFmtSyntax).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtSyntax))
 	}
 
 	val := ex.DB.GetString(v[0])
@@ -452,31 +584,31 @@ func bitcount(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) == 3 {
 		start, err = strconv.Atoi(string(v[1]))
 		if err != nil {
-			return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrNotValidInt))
 		}
 
 		end, err = strconv.Atoi(string(v[2]))
 		if err != nil {
-			return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrNotValidInt))
 		}
 
 		start, end = calcRange(start, end, len(val))
 	}
 
 	if end <= start {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 
 	sum := 0
 	for _, b := range val[start:end] {
 		sum += countSetBits[b]
 	}
-	return resp.Integer(sum).WriteTo(ex.Buffer)
+	return ex.write(resp.Integer(sum))
 }
 
 func bitop(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) < 3 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "bitop").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "bitop"))
 	}
 
 	ex.DB.Lock()
@@ -487,14 +619,14 @@ func bitop(v resp.CommandArgs, ex *CommandExtras) error {
 	switch op {
 	case "not":
 		if len(v) > 3 {
-			return resp.NewError(ErrBitOPNotError).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrBitOPNotError))
 		}
 		exists, tipe, _ := ex.DB.Has(v[2])
 		if !exists {
-			return resp.ZeroInteger.WriteTo(ex.Buffer)
+			return ex.write(resp.ZeroInteger)
 		}
 		if exists && tipe != storage.String {
-			return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrWrongType))
 		}
 
 		val := ex.DB.GetString(v[2])
@@ -504,14 +636,15 @@ func bitop(v resp.CommandArgs, ex *CommandExtras) error {
 		}
 
 		ex.DB.PutString(v[1], destValue, nil)
-		return resp.Integer(len(destValue)).WriteTo(ex.Buffer)
+		ex.notify(notifyString, "set", v[1])
+		return ex.write(resp.Integer(len(destValue)))
 
 	case "or", "and", "xor":
 		var destValue []byte = nil
 		for _, b := range v[2:] {
 			exists, tipe, _ := ex.DB.Has(b)
 			if exists && tipe != storage.String {
-				return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+				return ex.write(resp.NewError(ErrWrongType))
 			}
 			val := ex.DB.GetString(b)
 			if exists && len(destValue) < len(val) {
@@ -538,21 +671,22 @@ func bitop(v resp.CommandArgs, ex *CommandExtras) error {
 			}
 		}
 		ex.DB.PutString(v[1], destValue, nil)
-		return resp.Integer(len(destValue)).WriteTo(ex.Buffer)
+		ex.notify(notifyString, "set", v[1])
+		return ex.write(resp.Integer(len(destValue)))
 
 	default:
-		return resp.NewError(ErrSyntax).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrSyntax))
 	}
 }
 
 func bitpos(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) < 2 {
-		return resp.NewError(ErrFmtWrongNumberArgument, "bitpos").WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "bitpos"))
 	}
 
 	arg, err := strconv.Atoi(string(v[1]))
 	if err != nil || arg != 0 && arg != 1 {
-		return resp.NewError(ErrShouldBe0or1).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrShouldBe0or1))
 	}
 
 	set := arg == 1   // set bit pos
@@ -561,23 +695,23 @@ func bitpos(v resp.CommandArgs, ex *CommandExtras) error {
 	ex.DB.RLock()
 	defer ex.DB.RUnlock()
 
-	exists, tipe, _ := ex.DB.Has(v[0])
+	exists, tipe, _ := ex.lookupRead(v[0])
 	if exists && tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	// This is the same behavior as offical redis. Not sure why
 	// not check the len(v) when key is missing
 	if !exists && set {
-		return resp.NegativeOneInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.NegativeOneInteger)
 	}
 	if !exists && clear {
-		return resp.ZeroInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.ZeroInteger)
 	}
 
 	// Seam that: check the len(v) only when the key exists
 	if len(v) > 4 {
-		return resp.NewError(ErrFmtSyntax).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrFmtSyntax))
 	}
 
 	val := ex.DB.GetString(v[0])
@@ -587,18 +721,18 @@ func bitpos(v resp.CommandArgs, ex *CommandExtras) error {
 	if len(v) >= 3 {
 		start, err = strconv.Atoi(string(v[2]))
 		if err != nil {
-			return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrNotValidInt))
 		}
 	}
 	if len(v) == 4 {
 		end, err = strconv.Atoi(string(v[3]))
 		if err != nil {
-			return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrNotValidInt))
 		}
 	}
 	start, end = calcRange(start, end, len(val))
 	if end <= start {
-		return resp.NegativeOneInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.NegativeOneInteger)
 	}
 
 	// Get the postion in the range
@@ -619,14 +753,14 @@ func bitpos(v resp.CommandArgs, ex *CommandExtras) error {
 	}
 
 	if found {
-		return resp.Integer(8*start + pos).WriteTo(ex.Buffer)
+		return ex.write(resp.Integer(8*start + pos))
 	}
 
 	// From http://redis.io/commands/bitpos
 	// If we look for set bits (the bit argument is 1) and the string is
 	// empty or composed of just zero bytes, -1 is returned.
 	if !found && set {
-		return resp.NegativeOneInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.NegativeOneInteger)
 	}
 
 	// If we look for clear bits (the bit argument is 0) and the string only
@@ -638,16 +772,16 @@ func bitpos(v resp.CommandArgs, ex *CommandExtras) error {
 	// zeros if you look for clear bits and specify no range or the start argument
 	// only.
 	if !found && clear && len(v) < 4 { //len(v) < 4: no range 'end' specified
-		return resp.Integer(8 * end).WriteTo(ex.Buffer)
+		return ex.write(resp.Integer(8 * end))
 	}
 	// However, this behavior changes if you are looking for clear bits and
 	// specify a range with both start and end. If no clear bit is found in
 	// the specified range, the function returns -1 as the user specified a
 	// clear range and there are no 0 bits in that range.
 	if !found && clear && len(v) == 4 {
-		return resp.NegativeOneInteger.WriteTo(ex.Buffer)
+		return ex.write(resp.NegativeOneInteger)
 	}
-	return resp.NegativeOneInteger.WriteTo(ex.Buffer) // Should NEVER called
+	return ex.write(resp.NegativeOneInteger) // Should NEVER called
 }
 
 // strings.math, including incr, incrby, decr, decrby, decrfloat
@@ -658,7 +792,7 @@ func decr(v resp.CommandArgs, ex *CommandExtras) error {
 func decrby(v resp.CommandArgs, ex *CommandExtras) error {
 	by, err := strconv.ParseInt(v[1].String(), 10, 64)
 	if err != nil {
-		return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidInt))
 	}
 	return incrdecrHelper(v, ex, by*-1)
 }
@@ -670,7 +804,7 @@ func incr(v resp.CommandArgs, ex *CommandExtras) error {
 func incrby(v resp.CommandArgs, ex *CommandExtras) error {
 	by, err := strconv.ParseInt(v[1].String(), 10, 64)
 	if err != nil {
-		return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidInt))
 	}
 	return incrdecrHelper(v, ex, by)
 }
@@ -678,7 +812,7 @@ func incrby(v resp.CommandArgs, ex *CommandExtras) error {
 func incrbyfloat(v resp.CommandArgs, ex *CommandExtras) error {
 	by, err := strconv.ParseFloat(v[1].String(), 64)
 	if err != nil {
-		return resp.NewError(ErrNotValidFloat).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrNotValidFloat))
 	}
 
 	ex.DB.Lock()
@@ -686,7 +820,7 @@ func incrbyfloat(v resp.CommandArgs, ex *CommandExtras) error {
 
 	exists, tipe, expireAt := ex.DB.Has(v[0])
 	if exists && tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	newVal := 0.0
@@ -696,14 +830,15 @@ func incrbyfloat(v resp.CommandArgs, ex *CommandExtras) error {
 		val := ex.DB.GetString(v[0])
 		f, err := strconv.ParseFloat(string(val), 64)
 		if err != nil {
-			return resp.NewError(ErrNotValidFloat).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrNotValidFloat))
 		}
 		newVal = f + by
 	}
 
 	s := []byte(strconv.FormatFloat(newVal, 'f', -1, 64))
 	ex.DB.PutString(v[0], s, expireAt)
-	return resp.BulkString(s).WriteTo(ex.Buffer)
+	ex.notify(notifyString, "incrbyfloat", v[0])
+	return ex.write(resp.BulkString(s))
 }
 
 // strings.helper
@@ -789,13 +924,55 @@ func calcRange(start, end, len int) (int, int) {
 	return start, end
 }
 
+// expireOptionTime converts the value of EX, PX, EXAT or PXAT option to time, returns
+// false if the value is not a valid expire time.
+func expireOptionTime(op string, val int64) (time.Time, bool) {
+	if val <= 0 {
+		return time.Time{}, false
+	}
+
+	switch op {
+	case "ex":
+		return expireTime(val, time.Second, false)
+	case "px":
+		return expireTime(val, time.Millisecond, false)
+	case "exat":
+		return expireTime(val, time.Second, true)
+	case "pxat":
+		return expireTime(val, time.Millisecond, true)
+	}
+	return time.Time{}, false
+}
+
+func setexHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, op string) error {
+	i, err := strconv.ParseInt(string(v[1]), 10, 64)
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+	expireAt, ok := expireOptionTime(op, i)
+	if !ok {
+		return ex.write(resp.NewError(ErrFmtInvalidExpireTime, cmd))
+	}
+	if len(v[2]) > STRLIMIT {
+		return ex.write(resp.NewError(ErrStringExccedLimit))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	ex.DB.PutString(v[0], v[2], &expireAt)
+	ex.notify(notifyString, "set", v[0])
+	ex.notify(notifyGeneric, "expire", v[0])
+	return ex.write(resp.OkSimpleString)
+}
+
 func incrdecrHelper(v resp.CommandArgs, ex *CommandExtras, by int64) error {
 	ex.DB.Lock()
 	defer ex.DB.Unlock()
 
 	exists, tipe, expireAt := ex.DB.Has(v[0])
 	if exists && tipe != storage.String {
-		return resp.NewError(ErrWrongType).WriteTo(ex.Buffer)
+		return ex.write(resp.NewError(ErrWrongType))
 	}
 
 	newVal := int64(0)
@@ -805,11 +982,12 @@ func incrdecrHelper(v resp.CommandArgs, ex *CommandExtras, by int64) error {
 		val := ex.DB.GetString(v[0])
 		i, err := strconv.ParseInt(string(val), 10, 64)
 		if err != nil {
-			return resp.NewError(ErrNotValidInt).WriteTo(ex.Buffer)
+			return ex.write(resp.NewError(ErrNotValidInt))
 		}
 		newVal = i + by
 	}
 
 	ex.DB.PutString(v[0], []byte(strconv.FormatInt(newVal, 10)), expireAt)
-	return resp.Integer(newVal).WriteTo(ex.Buffer)
+	ex.notify(notifyString, "incrby", v[0])
+	return ex.write(resp.Integer(newVal))
 }
@@ -0,0 +1,188 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+// Package command is to handle the command from client.
+package command
+
+import (
+	"fmt"
+
+	"github.com/rod6/rodis/resp"
+	"github.com/rod6/rodis/storage"
+)
+
+// Transactions.
+// After MULTI the commands of the connection are checked (name, number of arguments, auth)
+// and queued, a rejected one makes EXEC abort. EXEC runs the queued commands on a
+// transaction view of the db under its write lock, and commits all their writes in one
+// batch. A command failing at runtime (e.g. WRONGTYPE) replies its error, the others run on.
+// EXEC replies a nil array without running any command if a key watched by WATCH before is
+// modified, expired or deleted since.
+
+// notInMulti are the commands rejected while queuing. The db of a transaction is fixed, so
+// SELECT is not allowed, neither are the subscriptions.
+var notInMulti = map[string]bool{
+	"select":       true,
+	"watch":        true,
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+}
+
+type watchedKey struct {
+	db  *storage.LevelDB
+	key string
+}
+
+// flagMulti marks the transaction to abort, for a command rejected while queuing.
+func (ex *CommandExtras) flagMulti() {
+	if ex.multi {
+		ex.dirty = true
+	}
+}
+
+func (ex *CommandExtras) resetMulti() {
+	ex.multi, ex.queued, ex.dirty = false, nil, false
+}
+
+// queuedCommand is a command queued after MULTI, args has no command name.
+type queuedCommand struct {
+	cmd  string
+	f    commandFunc
+	args resp.CommandArgs
+}
+
+// queue queues the command after MULTI, the transaction commands run at once, so do QUIT
+// and RESET.
+func queue(cmd string, a *attr, args resp.CommandArgs, ex *CommandExtras) error {
+	switch {
+	case cmd == "multi" || cmd == "exec" || cmd == "discard" || cmd == "quit" || cmd == "reset":
+		return call(cmd, a.f, args, ex)
+	case notInMulti[cmd]:
+		ex.dirty = true
+		return ex.write(resp.NewError(ErrNotInMulti))
+	}
+
+	ex.queued = append(ex.queued, queuedCommand{cmd, a.f, args})
+	return ex.write(resp.QueuedSimpleString)
+}
+
+func multi(v resp.CommandArgs, ex *CommandExtras) error {
+	if ex.multi {
+		return ex.write(resp.NewError(ErrMultiNested))
+	}
+	ex.multi = true
+	return ex.write(resp.OkSimpleString)
+}
+
+func discard(v resp.CommandArgs, ex *CommandExtras) error {
+	if !ex.multi {
+		return ex.write(resp.NewError(ErrDiscardWithoutMulti))
+	}
+	ex.resetMulti()
+	ex.unwatch()
+	return ex.write(resp.OkSimpleString)
+}
+
+func exec(v resp.CommandArgs, ex *CommandExtras) error {
+	if !ex.multi {
+		return ex.write(resp.NewError(ErrExecWithoutMulti))
+	}
+
+	queued, dirty := ex.queued, ex.dirty
+	ex.resetMulti()
+	defer ex.unwatch()
+	if dirty {
+		return ex.write(resp.NewError(ErrExecAbort))
+	}
+
+	// The watched keys are looked up, so the ones expired since being watched are deleted
+	// and make the watch dirty. Only the db of the transaction is locked, the keys in the
+	// other dbs are looked up before, under their own locks.
+	db := ex.DB
+	for _, wk := range ex.watched {
+		if wk.db != db {
+			wk.db.RLock()
+			wk.db.Has([]byte(wk.key))
+			wk.db.RUnlock()
+		}
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	for _, wk := range ex.watched {
+		if wk.db == db {
+			db.Has([]byte(wk.key))
+		}
+	}
+	if ex.watch != nil && ex.watch.Dirty() {
+		return ex.reply(resp.Array(nil))
+	}
+
+	// the commands lock the view, which does nothing, as the db is locked here
+	view := db.Begin()
+	ex.DB, ex.inExec = view, true
+	defer func() {
+		ex.DB, ex.inExec = db, false
+	}()
+
+	if _, err := fmt.Fprintf(ex.Buffer, "*%d\r\n", len(queued)); err != nil {
+		return err
+	}
+	for _, c := range queued {
+		if err := call(c.cmd, c.f, c.args, ex); err != nil {
+			return err // nothing is committed
+		}
+	}
+
+	view.Commit()
+	serveAllBlocked(db) // the pushes of the transaction
+	return nil
+}
+
+// WATCH key [key ...]
+func watch(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	if ex.watch == nil {
+		ex.watch = new(storage.Watch)
+	}
+	for _, key := range v {
+		wk := watchedKey{ex.DB, string(key)}
+		if ex.isWatched(wk) {
+			continue
+		}
+		ex.DB.Has(key) // an expired key is deleted before, not after being watched
+		ex.DB.Watch(key, ex.watch)
+		ex.watched = append(ex.watched, wk)
+	}
+	return ex.write(resp.OkSimpleString)
+}
+
+func unwatch(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.unwatch()
+	return ex.write(resp.OkSimpleString)
+}
+
+func (ex *CommandExtras) isWatched(wk watchedKey) bool {
+	for _, k := range ex.watched {
+		if k == wk {
+			return true
+		}
+	}
+	return false
+}
+
+func (ex *CommandExtras) unwatch() {
+	for _, wk := range ex.watched {
+		wk.db.Unwatch([]byte(wk.key), ex.watch)
+	}
+	ex.watch, ex.watched = nil, nil
+}
@@ -0,0 +1,667 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+// Package command is to handle the command from client.
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/rod6/rodis/resp"
+	"github.com/rod6/rodis/storage"
+)
+
+// Implement for command list in http://redis.io/commands#sorted_set
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func zadd(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 3 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "zadd"))
+	}
+
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+options:
+	for ; i < len(v); i++ {
+		switch strings.ToLower(v[i].String()) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		case "ch":
+			ch = true
+		case "incr":
+			incr = true
+		default:
+			break options
+		}
+	}
+
+	pairs := v[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+	if nx && xx {
+		return ex.write(resp.NewError(ErrZAddNXAndXX))
+	}
+	if nx && (gt || lt) || gt && lt {
+		return ex.write(resp.NewError(ErrZAddGTLTNX))
+	}
+	if incr && len(pairs) > 2 {
+		return ex.write(resp.NewError(ErrZAddIncrPair))
+	}
+
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, ok := parseScore(pairs[2*j])
+		if !ok {
+			return ex.write(resp.NewError(ErrNotValidFloat))
+		}
+		scores[j] = score
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, expireAt := ex.DB.Has(v[0])
+	if exists && tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	added, changed := 0, 0
+	updates := []storage.ZMember{}
+	pending := make(map[string]float64) // the scores updated by this command
+	var reply resp.Value = resp.NilBulkString
+	for j, score := range scores {
+		member := pairs[2*j+1]
+		old, ok := pending[string(member)]
+		if !ok && exists {
+			old, ok = ex.DB.ZSetScore(v[0], member)
+		}
+
+		if nx && ok || xx && !ok {
+			continue
+		}
+		if incr && ok {
+			score += old
+			if math.IsNaN(score) {
+				return ex.write(resp.NewError(ErrScoreNaN))
+			}
+		}
+		if ok && (gt && score <= old || lt && score >= old) {
+			continue
+		}
+
+		if !ok {
+			added++
+		} else if score != old {
+			changed++
+		}
+		updates = append(updates, storage.ZMember{Member: member, Score: score})
+		pending[string(member)] = score
+		reply = resp.Double(score)
+	}
+
+	if len(updates) > 0 {
+		ex.DB.ZSetPut(v[0], updates, expireAt)
+		if incr {
+			ex.notify(notifyZSet, "zincr", v[0])
+		} else {
+			ex.notify(notifyZSet, "zadd", v[0])
+		}
+	}
+
+	if incr {
+		return ex.reply(reply)
+	}
+	if ch {
+		return ex.write(resp.Integer(added + changed))
+	}
+	return ex.write(resp.Integer(added))
+}
+
+func zincrby(v resp.CommandArgs, ex *CommandExtras) error {
+	by, ok := parseScore(v[1])
+	if !ok {
+		return ex.write(resp.NewError(ErrNotValidFloat))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, expireAt := ex.DB.Has(v[0])
+	if exists && tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	score := by
+	if exists {
+		if old, ok := ex.DB.ZSetScore(v[0], v[2]); ok {
+			score += old
+		}
+	}
+	if math.IsNaN(score) {
+		return ex.write(resp.NewError(ErrScoreNaN))
+	}
+
+	ex.DB.ZSetPut(v[0], []storage.ZMember{{Member: v[2], Score: score}}, expireAt)
+	ex.notify(notifyZSet, "zincr", v[0])
+	return ex.reply(resp.Double(score))
+}
+
+func zrem(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "zrem"))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, _ := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+	if tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	removed := ex.DB.ZSetRemove(v[0], v[1:].ToBytes())
+	if removed > 0 {
+		ex.notify(notifyZSet, "zrem", v[0])
+		notifyEmptied(ex.DB, v[0])
+	}
+	return ex.write(resp.Integer(removed))
+}
+
+func zcard(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+	if tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	return ex.write(resp.Integer(ex.DB.ZSetCard(v[0])))
+}
+
+func zscore(v resp.CommandArgs, ex *CommandExtras) error {
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if !exists {
+		return ex.write(resp.NilBulkString)
+	}
+	if tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	score, ok := ex.DB.ZSetScore(v[0], v[1])
+	if !ok {
+		return ex.reply(resp.NilBulkString)
+	}
+	return ex.reply(resp.Double(score))
+}
+
+func zcount(v resp.CommandArgs, ex *CommandExtras) error {
+	min, ok1 := parseScoreBound(v[1])
+	max, ok2 := parseScoreBound(v[2])
+	if !ok1 || !ok2 {
+		return ex.write(resp.NewError(ErrMinMaxNotFloat))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if !exists {
+		return ex.write(resp.ZeroInteger)
+	}
+	if tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	return ex.write(resp.Integer(ex.DB.ZSetCount(v[0], min, max)))
+}
+
+// zsets.rank group, including zrank, zrevrank
+func zrank(v resp.CommandArgs, ex *CommandExtras) error {
+	return rankHelper(v, ex, "zrank", false)
+}
+
+func zrevrank(v resp.CommandArgs, ex *CommandExtras) error {
+	return rankHelper(v, ex, "zrevrank", true)
+}
+
+// zsets.pop group, including zpopmin, zpopmax
+func zpopmin(v resp.CommandArgs, ex *CommandExtras) error {
+	return zpopHelper(v, ex, "zpopmin", false)
+}
+
+func zpopmax(v resp.CommandArgs, ex *CommandExtras) error {
+	return zpopHelper(v, ex, "zpopmax", true)
+}
+
+// ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func zrange(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 3 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "zrange"))
+	}
+
+	spec, err := parseZRange(v[1:], true)
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if exists && tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	members, err := spec.get(v[0], exists, ex)
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+	return ex.reply(zmembersArray(members, spec.withScores, ex.Protocol >= 3))
+}
+
+// ZRANGESTORE dst src min max [BYSCORE | BYLEX] [REV] [LIMIT offset count]
+func zrangestore(v resp.CommandArgs, ex *CommandExtras) error {
+	if len(v) < 4 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, "zrangestore"))
+	}
+
+	spec, err := parseZRange(v[2:], false)
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, _ := ex.DB.Has(v[1])
+	if exists && tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	members, err := spec.get(v[1], exists, ex)
+	if err != nil {
+		return ex.write(err.(resp.Error))
+	}
+	existed, _, _ := ex.DB.Has(v[0])
+	ex.DB.PutZSet(v[0], members, nil)
+	ex.notifyStore(notifyZSet, "zrangestore", v[0], len(members), existed)
+	return ex.write(resp.Integer(len(members)))
+}
+
+// zsets.algebra group, including zunionstore, zinterstore
+func zunionstore(v resp.CommandArgs, ex *CommandExtras) error {
+	return zsetOpStoreHelper(v, ex, "zunionstore", false)
+}
+
+func zinterstore(v resp.CommandArgs, ex *CommandExtras) error {
+	return zsetOpStoreHelper(v, ex, "zinterstore", true)
+}
+
+func rankHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, reverse bool) error {
+	if len(v) != 2 && len(v) != 3 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
+	}
+	withScore := len(v) == 3
+	if withScore && strings.ToLower(v[2].String()) != "withscore" {
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+
+	ex.DB.RLock()
+	defer ex.DB.RUnlock()
+
+	exists, tipe, _ := ex.lookupRead(v[0])
+	if exists && tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	var rank int
+	ok := false
+	if exists {
+		rank, ok = ex.DB.ZSetRank(v[0], v[1], reverse)
+	}
+	if !ok {
+		if withScore {
+			return ex.reply(resp.Array(nil))
+		}
+		return ex.reply(resp.NilBulkString)
+	}
+
+	if withScore {
+		score, _ := ex.DB.ZSetScore(v[0], v[1])
+		return ex.reply(resp.Array{resp.Integer(rank), resp.Double(score)})
+	}
+	return ex.write(resp.Integer(rank))
+}
+
+func zpopHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, max bool) error {
+	if len(v) != 1 && len(v) != 2 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
+	}
+
+	count := 1
+	if len(v) == 2 {
+		n, err := strconv.Atoi(v[1].String())
+		if err != nil {
+			return ex.write(resp.NewError(ErrNotValidInt))
+		}
+		if n < 0 {
+			return ex.write(resp.NewError(ErrMustBePositive))
+		}
+		count = n
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	exists, tipe, _ := ex.DB.Has(v[0])
+	if !exists {
+		return ex.write(resp.EmptyArray)
+	}
+	if tipe != storage.SortedSet {
+		return ex.write(resp.NewError(ErrWrongType))
+	}
+
+	members := ex.DB.ZSetPop(v[0], count, max)
+	if len(members) > 0 {
+		ex.notify(notifyZSet, cmd, v[0])
+		notifyEmptied(ex.DB, v[0])
+	}
+	// a member with its score is a pair in RESP3, unless the count is not given
+	return ex.reply(zmembersArray(members, true, ex.Protocol >= 3 && len(v) == 2))
+}
+
+// zrangeSpec is the parsed arguments of zrange and zrangestore.
+type zrangeSpec struct {
+	start, stop resp.BulkString
+	by          string // "rank", "score" or "lex"
+	reverse     bool
+	offset      int
+	count       int // negative for all
+	withScores  bool
+}
+
+// parseZRange parses start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES],
+// WITHSCORES is accepted only if withScores is true.
+func parseZRange(v resp.CommandArgs, withScores bool) (*zrangeSpec, error) {
+	spec := &zrangeSpec{start: v[0], stop: v[1], by: "rank", count: -1}
+	limit := false
+
+	for i := 2; i < len(v); i++ {
+		switch strings.ToLower(v[i].String()) {
+		case "byscore":
+			spec.by = "score"
+		case "bylex":
+			spec.by = "lex"
+		case "rev":
+			spec.reverse = true
+		case "withscores":
+			if !withScores {
+				return nil, resp.NewError(ErrFmtSyntax)
+			}
+			spec.withScores = true
+		case "limit":
+			if i+2 >= len(v) {
+				return nil, resp.NewError(ErrFmtSyntax)
+			}
+			offset, err1 := strconv.Atoi(v[i+1].String())
+			count, err2 := strconv.Atoi(v[i+2].String())
+			if err1 != nil || err2 != nil {
+				return nil, resp.NewError(ErrNotValidInt)
+			}
+			spec.offset, spec.count = offset, count
+			limit = true
+			i += 2
+		default:
+			return nil, resp.NewError(ErrFmtSyntax)
+		}
+	}
+
+	if limit && spec.by == "rank" {
+		return nil, resp.NewError(ErrZRangeLimit)
+	}
+	if spec.withScores && spec.by == "lex" {
+		return nil, resp.NewError(ErrZRangeWithScoresLex)
+	}
+	return spec, nil
+}
+
+// get returns the members of the sorted set key in the range, the range is checked even if
+// the key does not exist.
+func (spec *zrangeSpec) get(key []byte, exists bool, ex *CommandExtras) ([]storage.ZMember, error) {
+	// For REV, the range is given as from max to min
+	min, max := spec.start, spec.stop
+	if spec.reverse && spec.by != "rank" {
+		min, max = max, min
+	}
+
+	switch spec.by {
+	case "score":
+		minBound, ok1 := parseScoreBound(min)
+		maxBound, ok2 := parseScoreBound(max)
+		if !ok1 || !ok2 {
+			return nil, resp.NewError(ErrMinMaxNotFloat)
+		}
+		if !exists {
+			return []storage.ZMember{}, nil
+		}
+		return ex.DB.ZSetRangeByScore(key, minBound, maxBound, spec.reverse, spec.offset, spec.count), nil
+	case "lex":
+		minBound, ok1 := parseLexBound(min)
+		maxBound, ok2 := parseLexBound(max)
+		if !ok1 || !ok2 {
+			return nil, resp.NewError(ErrMinMaxNotString)
+		}
+		if !exists {
+			return []storage.ZMember{}, nil
+		}
+		return ex.DB.ZSetRangeByLex(key, minBound, maxBound, spec.reverse, spec.offset, spec.count), nil
+	default:
+		start, err1 := strconv.Atoi(min.String())
+		stop, err2 := strconv.Atoi(max.String())
+		if err1 != nil || err2 != nil {
+			return nil, resp.NewError(ErrNotValidInt)
+		}
+		if !exists {
+			return []storage.ZMember{}, nil
+		}
+		return ex.DB.ZSetRangeByRank(key, start, stop, spec.reverse), nil
+	}
+}
+
+// ZUNIONSTORE/ZINTERSTORE dst numkeys key [key ...] [WEIGHTS weight [weight ...]]
+// [AGGREGATE SUM|MIN|MAX]
+func zsetOpStoreHelper(v resp.CommandArgs, ex *CommandExtras, cmd string, inter bool) error {
+	if len(v) < 3 {
+		return ex.write(resp.NewError(ErrFmtWrongNumberArgument, cmd))
+	}
+
+	numkeys, err := strconv.Atoi(v[1].String())
+	if err != nil {
+		return ex.write(resp.NewError(ErrNotValidInt))
+	}
+	if numkeys < 1 {
+		return ex.write(resp.NewError(ErrFmtAtLeastOneKey, cmd))
+	}
+	if numkeys > len(v)-2 {
+		return ex.write(resp.NewError(ErrFmtSyntax))
+	}
+	keys := v[2 : 2+numkeys]
+
+	weights := make([]float64, numkeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "sum"
+
+	for i := 2 + numkeys; i < len(v); i++ {
+		switch strings.ToLower(v[i].String()) {
+		case "weights":
+			if i+numkeys >= len(v) {
+				return ex.write(resp.NewError(ErrFmtSyntax))
+			}
+			for j := range weights {
+				w, ok := parseScore(v[i+1+j])
+				if !ok {
+					return ex.write(resp.NewError(ErrWeightNotFloat))
+				}
+				weights[j] = w
+			}
+			i += numkeys
+		case "aggregate":
+			if i+1 >= len(v) {
+				return ex.write(resp.NewError(ErrFmtSyntax))
+			}
+			aggregate = strings.ToLower(v[i+1].String())
+			if aggregate != "sum" && aggregate != "min" && aggregate != "max" {
+				return ex.write(resp.NewError(ErrFmtSyntax))
+			}
+			i++
+		default:
+			return ex.write(resp.NewError(ErrFmtSyntax))
+		}
+	}
+
+	ex.DB.Lock()
+	defer ex.DB.Unlock()
+
+	// A set is a sorted set with all scores 1
+	zsets := make([][]storage.ZMember, numkeys)
+	for i, key := range keys {
+		exists, tipe, _ := ex.DB.Has(key)
+		switch {
+		case !exists:
+			zsets[i] = []storage.ZMember{}
+		case tipe == storage.SortedSet:
+			zsets[i] = ex.DB.GetZSet(key)
+		case tipe == storage.Set:
+			for _, member := range ex.DB.GetSet(key) {
+				zsets[i] = append(zsets[i], storage.ZMember{Member: member, Score: 1})
+			}
+		default:
+			return ex.write(resp.NewError(ErrWrongType))
+		}
+	}
+
+	scores := make(map[string]float64)
+	seen := make(map[string]int) // the number of the sets having the member
+	order := [][]byte{}
+	for i, zset := range zsets {
+		for _, m := range zset {
+			score := m.Score * weights[i]
+			if math.IsNaN(score) { // 0 * inf
+				score = 0
+			}
+
+			old, ok := scores[string(m.Member)]
+			if !ok {
+				scores[string(m.Member)] = score
+				order = append(order, m.Member)
+			} else {
+				scores[string(m.Member)] = aggregateScore(aggregate, old, score)
+			}
+			seen[string(m.Member)]++
+		}
+	}
+
+	result := []storage.ZMember{}
+	for _, member := range order {
+		if inter && seen[string(member)] != numkeys {
+			continue
+		}
+		result = append(result, storage.ZMember{Member: member, Score: scores[string(member)]})
+	}
+
+	existed, _, _ := ex.DB.Has(v[0])
+	ex.DB.PutZSet(v[0], result, nil)
+	ex.notifyStore(notifyZSet, cmd, v[0], len(result), existed)
+	return ex.write(resp.Integer(len(result)))
+}
+
+func aggregateScore(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case "min":
+		return math.Min(a, b)
+	case "max":
+		return math.Max(a, b)
+	default:
+		sum := a + b
+		if math.IsNaN(sum) { // inf + -inf
+			return 0
+		}
+		return sum
+	}
+}
+
+func parseScore(arg resp.BulkString) (float64, bool) {
+	score, err := strconv.ParseFloat(arg.String(), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// parseScoreBound parses a score bound, e.g. 1.5 (inclusive), (1.5 (exclusive), -inf, +inf
+func parseScoreBound(arg resp.BulkString) (storage.ScoreBound, bool) {
+	bound := storage.ScoreBound{}
+	if len(arg) > 0 && arg[0] == '(' {
+		bound.Exclusive = true
+		arg = arg[1:]
+	}
+
+	score, ok := parseScore(arg)
+	bound.Score = score
+	return bound, ok
+}
+
+// parseLexBound parses a lex bound, e.g. [a (inclusive), (a (exclusive), - and +
+func parseLexBound(arg resp.BulkString) (storage.LexBound, bool) {
+	switch {
+	case string(arg) == "-":
+		return storage.LexBound{Inf: -1}, true
+	case string(arg) == "+":
+		return storage.LexBound{Inf: 1}, true
+	case len(arg) > 0 && arg[0] == '[':
+		return storage.LexBound{Member: arg[1:]}, true
+	case len(arg) > 0 && arg[0] == '(':
+		return storage.LexBound{Member: arg[1:], Exclusive: true}, true
+	}
+	return storage.LexBound{}, false
+}
+
+// zmembersArray replies the members, followed by its score if withScores is true. The
+// member and the score are in an array of their own if pairs is true.
+func zmembersArray(members []storage.ZMember, withScores bool, pairs bool) resp.Array {
+	arr := resp.Array{}
+	for _, m := range members {
+		switch {
+		case !withScores:
+			arr = append(arr, resp.BulkString(m.Member))
+		case pairs:
+			arr = append(arr, resp.Array{resp.BulkString(m.Member), resp.Double(m.Score)})
+		default:
+			arr = append(arr, resp.BulkString(m.Member), resp.Double(m.Score))
+		}
+	}
+	return arr
+}
@@ -44,6 +44,23 @@ type rodisTest struct {
 	reply   replyType
 }
 
+// bulks and integers build the expected reply of an array
+func bulks(values ...string) []replyType {
+	arr := []replyType{}
+	for _, v := range values {
+		arr = append(arr, replyType{"BulkString", []byte(v)})
+	}
+	return arr
+}
+
+func integers(values ...int64) []replyType {
+	arr := []replyType{}
+	for _, v := range values {
+		arr = append(arr, replyType{"Integer", v})
+	}
+	return arr
+}
+
 func check(r interface{}, v replyType) bool {
 	switch v.vtype {
 	case "SimpleString":
@@ -96,6 +113,12 @@ func check(r interface{}, v replyType) bool {
 
 func runTest(name string, tests []rodisTest, t *testing.T) {
 	re.Do("FLUSHDB")
+	runSteps(name, tests, t)
+}
+
+// runSteps runs the tests on the current data without flushing the db first,
+// it is used to continue a test after waiting, e.g. for keys to expire.
+func runSteps(name string, tests []rodisTest, t *testing.T) {
 	for i, test := range tests {
 		r, err := re.Do(test.command[0].(string), test.command[1:]...)
 		if !check(r, test.reply) {
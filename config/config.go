@@ -6,6 +6,14 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
 	"github.com/BurntSushi/toml"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 )
@@ -17,18 +25,157 @@ type RodisConfig struct {
 
 	Listen      string
 	RequirePass string
+	Timeout     int // seconds a client may be idle before its connection is closed, 0 for never
 
 	LogLevel string
 
 	LevelDBPath string
 	LevelDB     *opt.Options
+
+	ExpireCycleInterval int // milliseconds between two active expire cycles
+	ExpireMaxKeys       int // max keys deleted in one active expire cycle
+
+	ProtoMaxBulkLen      int64 // max length of a bulk string from the client, 0 for the default 512M
+	ProtoMaxMultibulkLen int64 // max number of the elements of an array from the client, 0 for the default 1M
+	ProtoMaxNesting      int   // max depth of the arrays in arrays from the client, 0 for the default 8
+
+	NotifyKeyspaceEvents string // the classes of the keyspace events to publish, by the redis flag letters
 }
 
+// Config is the config loaded. It may be changed at runtime by Update, so once the server
+// runs it should be read by Get.
 var Config RodisConfig
 
-func LoadConfig(path string) error {
-	if _, err := toml.DecodeFile(path, &Config); err != nil {
+var (
+	mu   sync.RWMutex // guards Config, see Get and Update
+	path string       // the config file loaded, see Rewrite
+)
+
+var ErrNoFile = errors.New("no config file is loaded")
+
+func LoadConfig(file string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := toml.DecodeFile(file, &Config); err != nil {
 		return err
 	}
+	path = file
 	return nil
 }
+
+// Read reads the config file loaded again, for reloading it, Config is not changed.
+func Read() (RodisConfig, error) {
+	mu.RLock()
+	file := path
+	mu.RUnlock()
+
+	var c RodisConfig
+	if file == "" {
+		return c, ErrNoFile
+	}
+	_, err := toml.DecodeFile(file, &c)
+	return c, err
+}
+
+// Get returns a copy of the config.
+func Get() RodisConfig {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Config
+}
+
+// Update changes the config by f.
+func Update(f func(c *RodisConfig)) {
+	mu.Lock()
+	defer mu.Unlock()
+	f(&Config)
+}
+
+// Rewrite writes the values of the config which may be changed at runtime back to the config
+// file. The other lines are kept as they are, and the keys not in the file are added to the
+// end of its top level table.
+func Rewrite() error {
+	mu.RLock()
+	c, file := Config, path
+	mu.RUnlock()
+
+	if file == "" {
+		return ErrNoFile
+	}
+	values := map[string]interface{}{
+		"requirepass":          c.RequirePass,
+		"timeout":              c.Timeout,
+		"loglevel":             c.LogLevel,
+		"expirecycleinterval":  c.ExpireCycleInterval,
+		"expiremaxkeys":        c.ExpireMaxKeys,
+		"protomaxbulklen":      c.ProtoMaxBulkLen,
+		"protomaxmultibulklen": c.ProtoMaxMultibulkLen,
+		"protomaxnesting":      c.ProtoMaxNesting,
+		"notifykeyspaceevents": c.NotifyKeyspaceEvents,
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	end := len(lines) // the end of the top level table
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "[") {
+			end = i
+			break
+		}
+		m := keyLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		key := strings.ToLower(m[1])
+		if value, ok := values[key]; ok {
+			if lines[i], err = encodeLine(m[1], value); err != nil {
+				return err
+			}
+			delete(values, key)
+		}
+	}
+	for end > 0 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	added := make([]string, len(keys))
+	for i, key := range keys {
+		if added[i], err = encodeLine(key, values[key]); err != nil {
+			return err
+		}
+	}
+	lines = append(lines[:end], append(added, lines[end:]...)...)
+
+	// the file is replaced at once, so it is never left half written
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// keyLine matches a line of a key and its value, the key is the first submatch.
+var keyLine = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+)\s*=`)
+
+// encodeLine returns the line of the key and its value in TOML.
+func encodeLine(key string, value interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]interface{}{key: value}); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
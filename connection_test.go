@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
 	"testing"
+	"time"
 )
 
 // connection group
@@ -32,3 +38,236 @@ func TestSelect(t *testing.T) {
 	}
 	runTest("SELECT", tests, t)
 }
+
+// rawDo sends the input on conn, and checks the replies read.
+func rawDo(name string, conn net.Conn, reader *bufio.Reader, input string, reply string, t *testing.T) {
+	conn.Write([]byte(input))
+	got := make([]byte, len(reply))
+	if _, err := io.ReadFull(reader, got); err != nil || string(got) != reply {
+		t.Errorf("Error %v %q, Get: %q, %v, expected: %q", name, input, got, err, reply)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	if reply, closed := protocolDo("QUIT\r\nPING\r\n", t); reply != "+OK\r\n" || !closed {
+		t.Errorf("Error QUIT, Get: %q, closed: %v", reply, closed)
+	}
+
+	conn, err := net.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+
+	rawDo("QUIT", conn, reader, "AUTH password\r\nSUBSCRIBE quit.a\r\n", "+OK\r\n*3\r\n$9\r\nsubscribe\r\n$6\r\nquit.a\r\n:1\r\n", t)
+	rawDo("QUIT", conn, reader, "MULTI\r\n", "-ERR Can't execute 'multi': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n", t)
+	rawDo("QUIT", conn, reader, "QUIT\r\nPING\r\n", "+OK\r\n", t)
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Error QUIT, connection is not closed")
+	}
+}
+
+func TestReset(t *testing.T) {
+	conn, err := net.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+
+	rawDo("RESET", conn, reader, "AUTH password\r\nSELECT 1\r\nSET reset.k v\r\n", "+OK\r\n+OK\r\n+OK\r\n", t)
+	rawDo("RESET", conn, reader, "SUBSCRIBE reset.a\r\n", "*3\r\n$9\r\nsubscribe\r\n$7\r\nreset.a\r\n:1\r\n", t)
+	rawDo("RESET", conn, reader, "RESET\r\n", "+RESET\r\n", t)
+	rawDo("RESET", conn, reader, "EXISTS reset.k\r\n", "-NOAUTH Authentication required.\r\n", t)
+	rawDo("RESET", conn, reader, "AUTH password\r\nEXISTS reset.k\r\n", "+OK\r\n:0\r\n", t)
+	rawDo("RESET", conn, reader, "PUBSUB NUMSUB reset.a\r\n", "*2\r\n$7\r\nreset.a\r\n:0\r\n", t)
+	rawDo("RESET", conn, reader, "WATCH reset.k\r\nMULTI\r\nPING\r\nRESET\r\n", "+OK\r\n+OK\r\n+QUEUED\r\n+RESET\r\n", t)
+	rawDo("RESET", conn, reader, "AUTH password\r\nEXEC\r\n", "+OK\r\n-ERR EXEC without MULTI\r\n", t)
+	rawDo("RESET", conn, reader, "SELECT 1\r\nDEL reset.k\r\n", "+OK\r\n:1\r\n", t)
+}
+
+// protocolDo sends the raw input, returns the first line of the reply and if the server
+// closes the connection after it.
+func protocolDo(input string, t *testing.T) (string, bool) {
+	conn, err := net.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte(input))
+	reader := bufio.NewReader(conn)
+	line, _ := reader.ReadString('\n')
+	_, err = reader.ReadByte()
+	return line, err == io.EOF
+}
+
+func TestProtocolError(t *testing.T) {
+	tests := []struct {
+		input string
+		reply string
+	}{
+		{"*2\r\n$4\r\necho\r\n$-2\r\n", "-ERR Protocol error: invalid bulk length\r\n"},
+		{"*2\r\n$4\r\necho\r\n$1073741824\r\n", "-ERR Protocol error: invalid bulk length\r\n"},
+		{"*2\r\n$4\r\necho\r\n$3\r\nfoobar\r\n", "-ERR Protocol error: expected '\\r\\n' after bulk string\r\n"},
+		{"*2\r\n$4\r\necho\r\n$1a\r\na\r\n", "-ERR Protocol error: invalid bulk length\r\n"},
+		{"*999999999\r\n", "-ERR Protocol error: invalid multibulk length\r\n"},
+		{"*x\r\n", "-ERR Protocol error: invalid multibulk length\r\n"},
+		{"*2\r\n$4\r\necho\r\n:5\r\n", "-ERR Protocol error: expected '$', got ':'\r\n"},
+		{"*2\r\n$4\r\necho\r\n*1\r\n$1\r\na\r\n", "-ERR Protocol error: expected '$', got '*'\r\n"},
+		{"*2\r\n$4\r\necho\r\n$-1\r\n", "-ERR Protocol error: invalid bulk length\r\n"},
+	}
+
+	for _, tt := range tests {
+		reply, closed := protocolDo(tt.input, t)
+		if reply != tt.reply || !closed {
+			t.Errorf("Error Protocol %q, Get: %q, closed: %v", tt.input, reply, closed)
+		}
+	}
+}
+
+func TestInline(t *testing.T) {
+	conn, err := net.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("auth password\r\n\r\nPING\r\necho \"a\\x41 \\\"b\\\"\"\r\necho 'c d\r\n"))
+
+	reader := bufio.NewReader(conn)
+	expected := []string{"+OK\r\n", "+PONG\r\n", "$6\r\n", "aA \"b\"\r\n", "-ERR Protocol error: unbalanced quotes in request\r\n"}
+	for _, e := range expected {
+		line, _ := reader.ReadString('\n')
+		if line != e {
+			t.Errorf("Error INLINE, Get: %q, expected: %q", line, e)
+		}
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Error INLINE, connection is not closed after the protocol error")
+	}
+}
+
+func TestHello(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"hello", "x"}, replyType{"Error", "ERR Protocol version is not an integer or out of range"}},
+		{[]interface{}{"hello", "4"}, replyType{"Error", "NOPROTO unsupported protocol version"}},
+		{[]interface{}{"hello", "2", "auth", "default"}, replyType{"Error", "ERR Syntax error in HELLO option 'auth'"}},
+		{[]interface{}{"hello", "2", "foo"}, replyType{"Error", "ERR Syntax error in HELLO option 'foo'"}},
+		{[]interface{}{"hello", "2", "auth", "default", "wrong"}, replyType{"Error", "WRONGPASS invalid username-password pair or user is disabled."}},
+		{[]interface{}{"hello", "2", "auth", "rod", "password"}, replyType{"Error", "WRONGPASS invalid username-password pair or user is disabled."}},
+		{[]interface{}{"hello", "2", "setname", "a b"}, replyType{"Error", "ERR Client names cannot contain spaces, newlines or special characters."}},
+	}
+	runTest("HELLO", tests, t)
+
+	conn, err := net.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+
+	// hello replies a map in RESP3, an array in RESP2
+	hello := func(command string, header string, proto string) {
+		conn.Write([]byte(command))
+		lines := []string{}
+		for len(lines) == 0 || lines[len(lines)-1] != "*0\r\n" {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Error HELLO %q, Get: %q, %v", command, lines, err)
+			}
+			lines = append(lines, line)
+		}
+		if lines[0] != header || lines[11] != proto {
+			t.Errorf("Error HELLO %q, Get: %q", command, lines)
+		}
+	}
+
+	do := func(command string, expected string) {
+		conn.Write([]byte(command))
+		reply := make([]byte, len(expected))
+		io.ReadFull(reader, reply)
+		if string(reply) != expected {
+			t.Errorf("Error RESP3 %q, Get: %q, expected: %q", command, reply, expected)
+		}
+	}
+
+	do("HELLO 3\r\n", "-"+"NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n")
+	hello("HELLO 3 AUTH default password SETNAME rodis\r\n", "%7\r\n", ":3\r\n")
+	do("DEL h z s\r\n", ":0\r\n")
+	do("HGETALL h\r\n", "%0\r\n")
+	do("HSET h f v\r\n", ":1\r\n")
+	do("HGETALL h\r\n", "%1\r\n$1\r\nf\r\n$1\r\nv\r\n")
+	do("ZADD z 1.5 m 2 n\r\n", ":2\r\n")
+	do("ZSCORE z m\r\n", ",1.5\r\n")
+	do("ZSCORE z x\r\n", "_\r\n")
+	do("ZINCRBY z 1 n\r\n", ",3\r\n")
+	do("ZRANGE z 0 -1 WITHSCORES\r\n", "*2\r\n*2\r\n$1\r\nm\r\n,1.5\r\n*2\r\n$1\r\nn\r\n,3\r\n")
+	do("ZRANK z n WITHSCORE\r\n", "*2\r\n:1\r\n,3\r\n")
+	do("SADD s a\r\n", ":1\r\n")
+	do("SMEMBERS s\r\n", "~1\r\n$1\r\na\r\n")
+	do("DEL x\r\n", ":0\r\n")
+	do("GET x\r\n", "_\r\n")
+	do("LPOP x\r\n", "_\r\n")
+	do("LPOP x 2\r\n", "_\r\n")
+	do("BLPOP x 0.01\r\n", "_\r\n")
+
+	hello("HELLO 2\r\n", "*14\r\n", ":2\r\n")
+	do("GET x\r\n", "$-1\r\n")
+	do("BLPOP x 0.01\r\n", "*-1\r\n")
+	do("HGETALL h\r\n", "*2\r\n$1\r\nf\r\n$1\r\nv\r\n")
+	do("ZSCORE z m\r\n", "$3\r\n1.5\r\n")
+	do("ZRANGE z 0 -1 WITHSCORES\r\n", "*4\r\n$1\r\nm\r\n$3\r\n1.5\r\n$1\r\nn\r\n$1\r\n3\r\n")
+	do("SMEMBERS s\r\n", "*1\r\n$1\r\na\r\n")
+	do("DEL h z s\r\n", ":3\r\n")
+}
+
+func TestPipeline(t *testing.T) {
+	conn, err := net.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	var commands bytes.Buffer
+	commands.WriteString("AUTH password\r\nDEL p\r\n")
+	for i := 0; i < 1000; i++ {
+		commands.WriteString("*2\r\n$4\r\nINCR\r\n$1\r\np\r\n")
+	}
+	go conn.Write(commands.Bytes())
+
+	expected := []string{"+OK\r\n", ":0\r\n"}
+	for i := 1; i <= 1000; i++ {
+		expected = append(expected, ":"+strconv.Itoa(i)+"\r\n")
+	}
+	for _, e := range expected {
+		line, err := reader.ReadString('\n')
+		if line != e {
+			t.Fatalf("Error PIPELINE, Get: %q, %v, expected: %q", line, err, e)
+		}
+	}
+
+	// the replies before a blocked command are not held by it
+	conn.Write([]byte("DEL p\r\nBLPOP p 0\r\nPING\r\n"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if line, err := reader.ReadString('\n'); line != ":1\r\n" {
+		t.Fatalf("Error PIPELINE with BLPOP, Get: %q, %v", line, err)
+	}
+
+	if _, err := re.Do("RPUSH", "p", "a"); err != nil {
+		t.Fatalf("Error PIPELINE RPUSH: %v", err)
+	}
+	reply := make([]byte, len("*2\r\n$1\r\np\r\n$1\r\na\r\n+PONG\r\n"))
+	io.ReadFull(reader, reply)
+	if string(reply) != "*2\r\n$1\r\np\r\n$1\r\na\r\n+PONG\r\n" {
+		t.Errorf("Error PIPELINE with BLPOP, Get: %q", reply)
+	}
+}
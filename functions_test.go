@@ -0,0 +1,226 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// functions group
+const lib1 = `#!lua name=lib1
+redis.register_function('f1', function(keys, args) return redis.call('set', keys[1], args[1]) end)
+redis.register_function{
+	function_name = 'f2',
+	callback = function(keys) return redis.call('get', keys[1]) end,
+	flags = {'no-writes'},
+	description = 'get a key',
+}
+redis.register_function{
+	function_name = 'f3',
+	callback = function(keys) return redis.call('del', keys[1]) end,
+	flags = {'no-writes'},
+}
+redis.register_function('fail', function() error('boom') end)`
+
+const lib2 = `#!lua name=lib2
+redis.register_function('f1', function() return 1 end)`
+
+const lib3 = `#!lua name=lib3
+local calls = 0
+redis.register_function('count', function() calls = calls + 1; return calls end)
+redis.register_function('busy', function() while true do end end)`
+
+func TestFunction(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"function"}, replyType{"Error", "ERR wrong number of arguments for 'function' command"}},
+		{[]interface{}{"function", "foo"}, replyType{"Error", "ERR unknown subcommand 'foo'. Try FUNCTION HELP."}},
+		{[]interface{}{"function", "load"}, replyType{"Error", "ERR wrong number of arguments for 'function|load' command"}},
+		{[]interface{}{"function", "flush", "foo"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"function", "flush"}, replyType{"SimpleString", "OK"}},
+
+		// the libraries not loaded
+		{[]interface{}{"function", "load", "foo", lib1}, replyType{"Error", "ERR Unknown argument foo"}},
+		{[]interface{}{"function", "load", "return 1"}, replyType{"Error", "ERR Missing library metadata"}},
+		{[]interface{}{"function", "load", "#!js name=lib\nreturn 1"}, replyType{"Error", "ERR Engine 'js' not found"}},
+		{[]interface{}{"function", "load", "#!lua\nreturn 1"}, replyType{"Error", "ERR Library name was not given"}},
+		{[]interface{}{"function", "load", "#!lua name=lib foo=bar\nreturn 1"}, replyType{"Error", "ERR Invalid metadata value given: foo=bar"}},
+		{[]interface{}{"function", "load", "#!lua name=a-b\nreturn 1"}, replyType{"Error", "ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nreturn +"}, replyType{"Error", "ERR Error compiling function: user_function line:2(column:8) near '+':   syntax error"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nreturn 1"}, replyType{"Error", "ERR No functions registered"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nredis.register_function('a-b', function() end)"}, replyType{"Error", "ERR Function names can only contain letters, numbers, or underscores(_) and must be at least one character long"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nredis.register_function('f', 1)"}, replyType{"Error", "ERR callback argument given to redis.register_function must be a function"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nredis.register_function('f')"}, replyType{"Error", "ERR wrong number of arguments to redis.register_function"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nredis.register_function{function_name='f', callback=function() end, foo=1}"}, replyType{"Error", "ERR unknown argument given to redis.register_function"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nredis.register_function{function_name='f', callback=function() end, flags={'foo'}}"}, replyType{"Error", "ERR unknown flag given"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nredis.register_function('f', function() end)\nredis.register_function('f', function() end)"}, replyType{"Error", "ERR Function already exists in the library"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nredis.call('set', 'a', 1)"}, replyType{"Error", "ERR Error registering functions: user_function:2: attempt to call a non-function object"}},
+		{[]interface{}{"function", "load", "#!lua name=lib\nwhile true do end"}, replyType{"Error", "ERR FUNCTION LOAD timeout"}},
+
+		{[]interface{}{"function", "load", lib1}, replyType{"BulkString", []byte("lib1")}},
+		{[]interface{}{"function", "load", lib1}, replyType{"Error", "ERR Library 'lib1' already exists"}},
+		{[]interface{}{"function", "load", "replace", lib1}, replyType{"BulkString", []byte("lib1")}},
+		{[]interface{}{"function", "load", lib2}, replyType{"Error", "ERR Function f1 already exists"}},
+		{[]interface{}{"function", "list", "libraryname", "lib2"}, replyType{"Array", []replyType{}}},
+		{[]interface{}{"function", "list", "foo"}, replyType{"Error", "ERR Unknown argument foo"}},
+		{[]interface{}{"function", "list", "libraryname", "lib*", "withcode"}, replyType{"Array", []replyType{
+			{"Array", []replyType{
+				{"BulkString", []byte("library_name")}, {"BulkString", []byte("lib1")},
+				{"BulkString", []byte("engine")}, {"BulkString", []byte("LUA")},
+				{"BulkString", []byte("functions")}, {"Array", []replyType{
+					{"Array", []replyType{
+						{"BulkString", []byte("name")}, {"BulkString", []byte("f1")},
+						{"BulkString", []byte("description")}, {"BulkString", nil},
+						{"BulkString", []byte("flags")}, {"Array", []replyType{}},
+					}},
+					{"Array", []replyType{
+						{"BulkString", []byte("name")}, {"BulkString", []byte("f2")},
+						{"BulkString", []byte("description")}, {"BulkString", []byte("get a key")},
+						{"BulkString", []byte("flags")}, {"Array", bulks("no-writes")},
+					}},
+					{"Array", []replyType{
+						{"BulkString", []byte("name")}, {"BulkString", []byte("f3")},
+						{"BulkString", []byte("description")}, {"BulkString", nil},
+						{"BulkString", []byte("flags")}, {"Array", bulks("no-writes")},
+					}},
+					{"Array", []replyType{
+						{"BulkString", []byte("name")}, {"BulkString", []byte("fail")},
+						{"BulkString", []byte("description")}, {"BulkString", nil},
+						{"BulkString", []byte("flags")}, {"Array", []replyType{}},
+					}},
+				}},
+				{"BulkString", []byte("library_code")}, {"BulkString", []byte(lib1)},
+			}},
+		}}},
+
+		// the libraries are not keys of a db
+		{[]interface{}{"flushdb"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"fcall", "f1", "1", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+
+		{[]interface{}{"function", "delete", "lib1"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"function", "delete", "lib1"}, replyType{"Error", "ERR Library not found"}},
+		{[]interface{}{"fcall", "f1", "1", "a", "foobar"}, replyType{"Error", "ERR Function not found"}},
+		{[]interface{}{"function", "load", lib2}, replyType{"BulkString", []byte("lib2")}},
+		{[]interface{}{"function", "restore", "foo"}, replyType{"Error", "ERR payload version or checksum are wrong"}},
+		{[]interface{}{"function", "restore", "foo", "bar"}, replyType{"Error", "ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE."}},
+	}
+	runTest("FUNCTION", tests, t)
+
+	// DUMP and RESTORE by the policies
+	payload, err := redis.Bytes(re.Do("function", "dump"))
+	if err != nil {
+		t.Fatalf("Error FUNCTION dump, Get: %v", err)
+	}
+	runSteps("FUNCTION", []rodisTest{
+		{[]interface{}{"function", "load", lib1}, replyType{"Error", "ERR Function f1 already exists"}},
+		{[]interface{}{"function", "restore", payload}, replyType{"Error", "ERR Library 'lib2' already exists"}},
+		{[]interface{}{"function", "restore", payload, "replace"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"function", "flush", "async"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"function", "load", lib1}, replyType{"BulkString", []byte("lib1")}},
+		{[]interface{}{"function", "restore", payload, "append"}, replyType{"Error", "ERR Function f1 already exists"}},
+		{[]interface{}{"function", "restore", payload, "flush"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"fcall", "f1", "0"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"fcall", "f2", "1", "a"}, replyType{"Error", "ERR Function not found"}},
+		{[]interface{}{"function", "flush"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"function", "list"}, replyType{"Array", []replyType{}}},
+	}, t)
+}
+
+func TestFcall(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"function", "flush"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"function", "load", lib1}, replyType{"BulkString", []byte("lib1")}},
+		{[]interface{}{"fcall", "f1"}, replyType{"Error", "ERR wrong number of arguments for 'fcall' command"}},
+		{[]interface{}{"fcall", "foo", "0"}, replyType{"Error", "ERR Function not found"}},
+		{[]interface{}{"fcall", "f1", "-1"}, replyType{"Error", "ERR Number of keys can't be negative"}},
+		{[]interface{}{"fcall", "f1", "2", "a"}, replyType{"Error", "ERR Number of keys can't be greater than number of args"}},
+		{[]interface{}{"fcall", "f1", "1", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"fcall", "f2", "1", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"fcall", "fail", "0"}, replyType{"Error", "ERR Error running script (call to fail): user_function:14: boom"}},
+		{[]interface{}{"fcall", "f3", "1", "a"}, replyType{"Error", "ERR Write commands are not allowed from read-only scripts."}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"eval", "return redis.call('fcall', 'f2', 1, 'a')", "0"}, replyType{"Error", "ERR This Redis command is not allowed from script"}},
+
+		// in a transaction
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"fcall", "f1", "1", "b", "1"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"incr", "b"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{{"SimpleString", "OK"}, {"Integer", int64(2)}}}},
+
+		// the library is run once, its state is kept for the next calls
+		{[]interface{}{"function", "load", lib3}, replyType{"BulkString", []byte("lib3")}},
+		{[]interface{}{"fcall", "count", "0"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"fcall", "count", "0"}, replyType{"Integer", int64(2)}},
+	}
+	runTest("FCALL", tests, t)
+}
+
+// busyDo runs the busy command on another connection, the error it returns is sent to the
+// channel returned.
+func busyDo(command ...interface{}) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		busy := redisPool.Get()
+		defer busy.Close()
+		_, err := busy.Do(command[0].(string), command[1:]...)
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	return done
+}
+
+// killed checks the busy command is killed.
+func killed(name string, done <-chan error, t *testing.T) {
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != "ERR Script killed by user with SCRIPT KILL..." {
+			t.Errorf("Error %v, Get: %#v", name, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Error %v, the script is still running", name)
+	}
+}
+
+func TestFunctionKill(t *testing.T) {
+	runTest("FUNCTION KILL", []rodisTest{
+		{[]interface{}{"function", "flush"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"function", "load", lib3}, replyType{"BulkString", []byte("lib3")}},
+		{[]interface{}{"function", "kill", "foo"}, replyType{"Error", "ERR wrong number of arguments for 'function|kill' command"}},
+		{[]interface{}{"function", "kill"}, replyType{"Error", "NOTBUSY No scripts in execution right now."}},
+		{[]interface{}{"fcall", "count", "0"}, replyType{"Integer", int64(1)}},
+	}, t)
+
+	// a function is killed by FUNCTION KILL only, and its state is kept
+	done := busyDo("fcall", "busy", "0")
+	runSteps("FUNCTION KILL", []rodisTest{
+		{[]interface{}{"script", "kill"}, replyType{"Error", "BUSY Redis is busy running a script. You can only call FUNCTION KILL or SHUTDOWN NOSAVE."}},
+		{[]interface{}{"function", "kill"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	killed("FUNCTION KILL", done, t)
+	runSteps("FUNCTION KILL", []rodisTest{
+		{[]interface{}{"function", "kill"}, replyType{"Error", "NOTBUSY No scripts in execution right now."}},
+		{[]interface{}{"fcall", "count", "0"}, replyType{"Integer", int64(2)}},
+	}, t)
+
+	// a script is killed by SCRIPT KILL only
+	done = busyDo("eval", "while true do end", "0")
+	runSteps("FUNCTION KILL", []rodisTest{
+		{[]interface{}{"function", "kill"}, replyType{"Error", "BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE."}},
+		{[]interface{}{"script", "kill"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	killed("FUNCTION KILL", done, t)
+}
+
+func TestFcallRo(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"function", "flush"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"function", "load", lib1}, replyType{"BulkString", []byte("lib1")}},
+		{[]interface{}{"fcall_ro", "f2"}, replyType{"Error", "ERR wrong number of arguments for 'fcall_ro' command"}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"fcall_ro", "f1", "1", "a", "foo"}, replyType{"Error", "ERR Can not execute a script with write flag using *_ro command."}},
+		{[]interface{}{"fcall_ro", "f2", "1", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"fcall_ro", "f3", "1", "a"}, replyType{"Error", "ERR Write commands are not allowed from read-only scripts."}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(1)}},
+	}
+	runTest("FCALL_RO", tests, t)
+}
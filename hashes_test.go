@@ -60,6 +60,14 @@ func TestHgetall(t *testing.T) {
         {[]interface{}{"hset", "a", "a2", "dongr"}, replyType{"Integer", int64(1)}},
         {[]interface{}{"hgetall", "a"}, replyType{"Array", []replyType{replyType{"BulkString", []byte("a1")}, replyType{"BulkString", []byte("foobar")}, replyType{"BulkString", []byte("a2")}, replyType{"BulkString", []byte("dongr")}}}},
         {[]interface{}{"hgetall", "b"}, replyType{"Array", []replyType{}}},
+        {[]interface{}{"hset", "a|b", "c", "x"}, replyType{"Integer", int64(1)}},
+        {[]interface{}{"hset", "a", "b|c", "y"}, replyType{"Integer", int64(1)}},
+        {[]interface{}{"set", "a|a1", "z"}, replyType{"SimpleString", "OK"}},
+        {[]interface{}{"hgetall", "a|b"}, replyType{"Array", bulks("c", "x")}},
+        {[]interface{}{"hgetall", "a"}, replyType{"Array", bulks("a1", "foobar", "a2", "dongr", "b|c", "y")}},
+        {[]interface{}{"del", "a"}, replyType{"Integer", int64(1)}},
+        {[]interface{}{"hgetall", "a|b"}, replyType{"Array", bulks("c", "x")}},
+        {[]interface{}{"get", "a|a1"}, replyType{"BulkString", []byte("z")}},
     }
     runTest("HGETALL", tests, t)
 }
@@ -122,6 +130,10 @@ func TestHlen(t *testing.T) {
         {[]interface{}{"hset", "a", "a2", "dongr"}, replyType{"Integer", int64(1)}},
         {[]interface{}{"hlen", "a"}, replyType{"Integer", int64(2)}},
         {[]interface{}{"hlen", "b"}, replyType{"Integer", int64(0)}},
+        {[]interface{}{"hmset", "a", "a2", "rod", "a3", "dong"}, replyType{"SimpleString", "OK"}},
+        {[]interface{}{"hlen", "a"}, replyType{"Integer", int64(3)}},
+        {[]interface{}{"hdel", "a", "a1", "a1", "a4"}, replyType{"Integer", int64(1)}},
+        {[]interface{}{"hlen", "a"}, replyType{"Integer", int64(2)}},
     }
     runTest("HLEN", tests, t)
 }
@@ -154,6 +166,28 @@ func TestHmset(t *testing.T) {
     runTest("HMSET", tests, t)
 }
 
+func TestHscan(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"hscan", "a"}, replyType{"Error", "ERR wrong number of arguments for 'hscan' command"}},
+		{[]interface{}{"hscan", "a", "x"}, replyType{"Error", "ERR invalid cursor"}},
+		{[]interface{}{"hscan", "a", "0", "type", "string"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"hscan", "a", "0"}, replyType{"Array", []replyType{{"BulkString", []byte("0")}, {"Array", bulks()}}}},
+		{[]interface{}{"hmset", "a", "f1", "v1", "f2", "v2", "f3", "v3", "g1", "w1"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"hscan", "a", "0"}, replyType{"Array", []replyType{{"BulkString", []byte("0")}, {"Array", bulks("f1", "v1", "f2", "v2", "f3", "v3", "g1", "w1")}}}},
+		{[]interface{}{"hscan", "a", "0", "match", "f*", "novalues"}, replyType{"Array", []replyType{{"BulkString", []byte("0")}, {"Array", bulks("f1", "f2", "f3")}}}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"hscan", "b", "0"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("HSCAN", tests, t)
+
+	items, calls := scanAll(func(cursor string) []interface{} {
+		return []interface{}{"hscan", "a", cursor, "count", "3"}
+	}, t)
+	if len(items) != 8 || calls != 2 {
+		t.Errorf("Error HSCAN count 3, Get: %v in %v calls", items, calls)
+	}
+}
+
 func TestHset(t *testing.T) {
     tests := []rodisTest{
         {[]interface{}{"hset"}, replyType{"Error", "ERR wrong number of arguments for 'hset' command"}},
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
 )
 
 func TestDel(t *testing.T) {
@@ -47,3 +51,217 @@ func TestType(t *testing.T) {
 	}
 	runTest("TYPE", tests, t)
 }
+
+func TestLazyExpire(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"set", "a", "foobar", "px", "100"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "b", "foobar", "px", "100"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "c", "10", "px", "100"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"hset", "d", "d1", "foobar"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"exists", "a", "b", "c", "d"}, replyType{"Integer", int64(4)}},
+	}
+	runTest("LAZYEXPIRE", tests, t)
+
+	time.Sleep(150 * time.Millisecond)
+
+	tests = []rodisTest{
+		{[]interface{}{"get", "a"}, replyType{"BulkString", nil}},
+		{[]interface{}{"exists", "a", "b", "c", "d"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"type", "b"}, replyType{"SimpleString", "none"}},
+		{[]interface{}{"incr", "c"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"del", "a", "b"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"hget", "d", "d1"}, replyType{"BulkString", []byte("foobar")}},
+	}
+	runSteps("LAZYEXPIRE", tests, t)
+}
+
+func TestExpire(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"expire", "a"}, replyType{"Error", "ERR wrong number of arguments for 'expire' command"}},
+		{[]interface{}{"expire", "a", "b"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"expire", "a", "100"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"expire", "a", "100", "xx"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"expire", "a", "100", "gt"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"expire", "a", "100", "nx", "xx"}, replyType{"Error", "ERR NX and XX, GT or LT options at the same time are not compatible"}},
+		{[]interface{}{"expire", "a", "100", "gt", "lt"}, replyType{"Error", "ERR GT and LT options at the same time are not compatible"}},
+		{[]interface{}{"expire", "a", "100", "foo"}, replyType{"Error", "ERR Unsupported option foo"}},
+		{[]interface{}{"expire", "a", "100", "nx"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"expire", "a", "200", "nx"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(100)}},
+		{[]interface{}{"expire", "a", "50", "gt"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"expire", "a", "200", "gt"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"expire", "a", "300", "lt"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"expire", "a", "50", "lt", "xx"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(50)}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"expire", "a", "-1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"hset", "b", "b1", "foobar"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"pexpire", "b", "100"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"hget", "b", "b1"}, replyType{"BulkString", []byte("foobar")}},
+	}
+	runTest("EXPIRE", tests, t)
+
+	time.Sleep(150 * time.Millisecond)
+
+	tests = []rodisTest{
+		{[]interface{}{"hget", "b", "b1"}, replyType{"BulkString", nil}},
+		{[]interface{}{"type", "b"}, replyType{"SimpleString", "none"}},
+	}
+	runSteps("EXPIRE", tests, t)
+}
+
+func TestExpireAt(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"expireat", "a"}, replyType{"Error", "ERR wrong number of arguments for 'expireat' command"}},
+		{[]interface{}{"expireat", "a", "4102444800"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"expiretime", "a"}, replyType{"Integer", int64(-1)}},
+		{[]interface{}{"expireat", "a", "4102444800"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"expiretime", "a"}, replyType{"Integer", int64(4102444800)}},
+		{[]interface{}{"pexpiretime", "a"}, replyType{"Integer", int64(4102444800000)}},
+		{[]interface{}{"pexpireat", "a", "4102444800123"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"pexpiretime", "a"}, replyType{"Integer", int64(4102444800123)}},
+		{[]interface{}{"expireat", "a", "1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"expiretime", "a"}, replyType{"Integer", int64(-2)}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"expireat", "a", "9223372036854775807"}, replyType{"Error", "ERR invalid expire time in 'expireat' command"}},
+	}
+	runTest("EXPIREAT", tests, t)
+}
+
+func TestTTL(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"ttl"}, replyType{"Error", "ERR wrong number of arguments for 'ttl' command"}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(-2)}},
+		{[]interface{}{"pttl", "a"}, replyType{"Integer", int64(-2)}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(-1)}},
+		{[]interface{}{"pttl", "a"}, replyType{"Integer", int64(-1)}},
+		{[]interface{}{"set", "a", "foobar", "ex", "10"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(10)}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(-1)}},
+	}
+	runTest("TTL", tests, t)
+}
+
+func TestPersist(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"persist"}, replyType{"Error", "ERR wrong number of arguments for 'persist' command"}},
+		{[]interface{}{"persist", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"persist", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"expire", "a", "100"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"persist", "a"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(-1)}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
+	}
+	runTest("PERSIST", tests, t)
+}
+
+func TestKeys(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"keys"}, replyType{"Error", "ERR wrong number of arguments for 'keys' command"}},
+		{[]interface{}{"keys", "*"}, replyType{"Array", bulks()}},
+		{[]interface{}{"mset", "hello", "1", "hallo", "2", "hxllo", "3", "hllo", "4", "heeeello", "5", "h*llo", "6"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"hset", "hash", "f", "v"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"keys", "*"}, replyType{"Array", bulks("h*llo", "hallo", "hash", "heeeello", "hello", "hllo", "hxllo")}},
+		{[]interface{}{"keys", "h?llo"}, replyType{"Array", bulks("h*llo", "hallo", "hello", "hxllo")}},
+		{[]interface{}{"keys", "h*llo"}, replyType{"Array", bulks("h*llo", "hallo", "heeeello", "hello", "hllo", "hxllo")}},
+		{[]interface{}{"keys", "h[ae]llo"}, replyType{"Array", bulks("hallo", "hello")}},
+		{[]interface{}{"keys", "h[^e]llo"}, replyType{"Array", bulks("h*llo", "hallo", "hxllo")}},
+		{[]interface{}{"keys", "h[a-f]llo"}, replyType{"Array", bulks("hallo", "hello")}},
+		{[]interface{}{"keys", `h\*llo`}, replyType{"Array", bulks("h*llo")}},
+		{[]interface{}{"keys", "hash"}, replyType{"Array", bulks("hash")}},
+		{[]interface{}{"pexpire", "hello", "1"}, replyType{"Integer", int64(1)}},
+	}
+	runTest("KEYS", tests, t)
+
+	time.Sleep(10 * time.Millisecond)
+	runSteps("KEYS", []rodisTest{
+		{[]interface{}{"keys", "he*"}, replyType{"Array", bulks("heeeello")}},
+	}, t)
+}
+
+// scanAll calls the scan command built by args with the cursor until the cursor is "0",
+// returns all the items replied and the number of the calls.
+func scanAll(args func(cursor string) []interface{}, t *testing.T) ([]string, int) {
+	items := []string{}
+	cursor := "0"
+	for calls := 1; ; calls++ {
+		command := args(cursor)
+		r, err := redis.Values(re.Do(command[0].(string), command[1:]...))
+		if err != nil || len(r) != 2 {
+			t.Fatalf("Error %v, Get: %#v, %#v", command, r, err)
+		}
+		values, _ := redis.Strings(r[1], nil)
+		items = append(items, values...)
+
+		cursor, _ = redis.String(r[0], nil)
+		if cursor == "0" {
+			return items, calls
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"scan"}, replyType{"Error", "ERR wrong number of arguments for 'scan' command"}},
+		{[]interface{}{"scan", "x"}, replyType{"Error", "ERR invalid cursor"}},
+		{[]interface{}{"scan", "-1"}, replyType{"Error", "ERR invalid cursor"}},
+		{[]interface{}{"scan", "18446744073709551615"}, replyType{"Error", "ERR invalid cursor"}},
+		{[]interface{}{"scan", "0", "count"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"scan", "0", "count", "0"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"scan", "0", "count", "x"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"scan", "0", "novalues"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"scan", "0"}, replyType{"Array", []replyType{{"BulkString", []byte("0")}, {"Array", bulks()}}}},
+		{[]interface{}{"mset", "k1", "1", "k2", "2", "k3", "3", "k4", "4", "k5", "5"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"rpush", "l1", "a"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"scan", "0", "count", "100"}, replyType{"Array", []replyType{{"BulkString", []byte("0")}, {"Array", bulks("k1", "k2", "k3", "k4", "k5", "l1")}}}},
+		{[]interface{}{"scan", "0", "match", "k[24]", "type", "string"}, replyType{"Array", []replyType{{"BulkString", []byte("0")}, {"Array", bulks("k2", "k4")}}}},
+		{[]interface{}{"scan", "0", "type", "list"}, replyType{"Array", []replyType{{"BulkString", []byte("0")}, {"Array", bulks("l1")}}}},
+	}
+	runTest("SCAN", tests, t)
+
+	items, calls := scanAll(func(cursor string) []interface{} {
+		return []interface{}{"scan", cursor, "count", "2"}
+	}, t)
+	if len(items) != 6 || calls != 3 {
+		t.Errorf("Error SCAN count 2, Get: %v in %v calls", items, calls)
+	}
+
+	// the cursor is an unsigned integer for the clients
+	r, _ := redis.Values(re.Do("scan", "0", "count", "2"))
+	if cursor, err := redis.Uint64(r[0], nil); err != nil || cursor == 0 {
+		t.Errorf("Error SCAN cursor, Get: %v, %v", r[0], err)
+	}
+
+	// the cursor is the last key returned, 0x01 "k3" read as a number, kept by no server state
+	tests = []rodisTest{
+		{[]interface{}{"scan", "92979", "count", "100"}, replyType{"Array", []replyType{{"BulkString", []byte("0")}, {"Array", bulks("k4", "k5", "l1")}}}},
+		{[]interface{}{"scan", "092979"}, replyType{"Error", "ERR invalid cursor"}},
+	}
+	runSteps("SCAN cursor", tests, t)
+
+	// The keys existing all the time are returned exactly once
+	items = []string{}
+	r, _ = redis.Values(re.Do("scan", "0", "count", "2"))
+	cursor, _ := redis.String(r[0], nil)
+	re.Do("del", "k1", "k4")
+	re.Do("set", "k0", "0")
+	re.Do("set", "k6", "6")
+	more, _ := scanAll(func(c string) []interface{} {
+		if c == "0" {
+			c = cursor
+		}
+		return []interface{}{"scan", c, "count", "2"}
+	}, t)
+	values, _ := redis.Strings(r[1], nil)
+	items = append(append(items, values...), more...)
+	if strings.Join(items, ",") != "k1,k2,k3,k5,k6,l1" {
+		t.Errorf("Error SCAN with writes, Get: %v", items)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// blockedDo runs the command on a new connection, the reply is sent to the returned
+// channel. It waits a while to let the command get blocked.
+func blockedDo(command ...interface{}) <-chan interface{} {
+	reply := make(chan interface{}, 1)
+	go func() {
+		c := redisPool.Get()
+		defer c.Close()
+		r, err := c.Do(command[0].(string), command[1:]...)
+		if err != nil {
+			reply <- err
+			return
+		}
+		reply <- r
+	}()
+	time.Sleep(100 * time.Millisecond)
+	return reply
+}
+
+func checkBlocked(name string, reply <-chan interface{}, v replyType, t *testing.T) {
+	select {
+	case r := <-reply:
+		if !check(r, v) {
+			t.Errorf("Error %v blocked, Get: %#v", name, r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Error %v blocked, no reply", name)
+	}
+}
+
+func TestBlmove(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"blmove", "a", "b", "left", "right"}, replyType{"Error", "ERR wrong number of arguments for 'blmove' command"}},
+		{[]interface{}{"blmove", "a", "b", "left", "up", "0"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"blmove", "a", "b", "left", "right", "x"}, replyType{"Error", "ERR timeout is not a float or out of range"}},
+		{[]interface{}{"blmove", "a", "b", "left", "right", "0.1"}, replyType{"Array", nil}},
+		{[]interface{}{"rpush", "a", "a1", "a2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"blmove", "a", "b", "left", "right", "0"}, replyType{"BulkString", []byte("a1")}},
+		{[]interface{}{"set", "c", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"blmove", "a", "c", "left", "right", "0"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a2")}},
+	}
+	runTest("BLMOVE", tests, t)
+
+	// A blocked blmove pushes to a list another client is blocked on
+	reply1 := blockedDo("blmove", "x", "y", "left", "left", "0")
+	reply2 := blockedDo("blpop", "y", "0")
+	runSteps("BLMOVE", []rodisTest{
+		{[]interface{}{"rpush", "x", "x1"}, replyType{"Integer", int64(1)}},
+	}, t)
+	checkBlocked("BLMOVE", reply1, replyType{"BulkString", []byte("x1")}, t)
+	checkBlocked("BLMOVE", reply2, replyType{"Array", bulks("y", "x1")}, t)
+	runSteps("BLMOVE", []rodisTest{
+		{[]interface{}{"exists", "x", "y"}, replyType{"Integer", int64(0)}},
+	}, t)
+}
+
+func TestBlmpop(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"blmpop", "0", "1", "a"}, replyType{"Error", "ERR wrong number of arguments for 'blmpop' command"}},
+		{[]interface{}{"blmpop", "0", "0", "a", "left"}, replyType{"Error", "ERR numkeys should be greater than 0"}},
+		{[]interface{}{"blmpop", "0", "2", "a", "left"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"blmpop", "0", "1", "a", "left", "count", "0"}, replyType{"Error", "ERR count should be greater than 0"}},
+		{[]interface{}{"blmpop", "-1", "1", "a", "left"}, replyType{"Error", "ERR timeout is negative"}},
+		{[]interface{}{"blmpop", "0.1", "1", "a", "left"}, replyType{"Array", nil}},
+		{[]interface{}{"rpush", "b", "b1", "b2", "b3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"blmpop", "0", "2", "a", "b", "right", "count", "2"}, replyType{"Array", []replyType{{"BulkString", []byte("b")}, {"Array", bulks("b3", "b2")}}}},
+		{[]interface{}{"blmpop", "0", "2", "a", "b", "right", "count", "2"}, replyType{"Array", []replyType{{"BulkString", []byte("b")}, {"Array", bulks("b1")}}}},
+	}
+	runTest("BLMPOP", tests, t)
+
+	reply := blockedDo("blmpop", "0", "2", "a", "b", "left", "count", "5")
+	runSteps("BLMPOP", []rodisTest{
+		{[]interface{}{"rpush", "a", "a1", "a2"}, replyType{"Integer", int64(2)}},
+	}, t)
+	checkBlocked("BLMPOP", reply, replyType{"Array", []replyType{{"BulkString", []byte("a")}, {"Array", bulks("a1", "a2")}}}, t)
+}
+
+func TestBlpop(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"blpop", "a"}, replyType{"Error", "ERR wrong number of arguments for 'blpop' command"}},
+		{[]interface{}{"blpop", "a", "x"}, replyType{"Error", "ERR timeout is not a float or out of range"}},
+		{[]interface{}{"blpop", "a", "-1"}, replyType{"Error", "ERR timeout is negative"}},
+		{[]interface{}{"blpop", "a", "0.1"}, replyType{"Array", nil}},
+		{[]interface{}{"rpush", "b", "b1", "b2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"blpop", "a", "b", "0"}, replyType{"Array", bulks("b", "b1")}},
+		{[]interface{}{"set", "c", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"blpop", "a", "c", "b", "0"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("BLPOP", tests, t)
+
+	// The blocked clients are served in order, one element each
+	reply1 := blockedDo("blpop", "x", "y", "0")
+	reply2 := blockedDo("blpop", "y", "0")
+	runSteps("BLPOP", []rodisTest{
+		{[]interface{}{"rpush", "y", "y1", "y2", "y3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"lrange", "y", "0", "-1"}, replyType{"Array", bulks("y3")}},
+	}, t)
+	checkBlocked("BLPOP", reply1, replyType{"Array", bulks("y", "y1")}, t)
+	checkBlocked("BLPOP", reply2, replyType{"Array", bulks("y", "y2")}, t)
+
+	// A closed client does not take the element, a pooled connection would wait for the
+	// reply on close, so dial directly
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Error BLPOP dial: %v", err)
+	}
+	c.Do("AUTH", "password")
+	c.Send("blpop", "z", "0")
+	c.Flush()
+	time.Sleep(100 * time.Millisecond)
+	c.Close()
+	time.Sleep(100 * time.Millisecond)
+	runSteps("BLPOP", []rodisTest{
+		{[]interface{}{"rpush", "z", "z1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"lrange", "z", "0", "-1"}, replyType{"Array", bulks("z1")}},
+	}, t)
+}
+
+func TestBrpop(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"brpop", "a"}, replyType{"Error", "ERR wrong number of arguments for 'brpop' command"}},
+		{[]interface{}{"brpop", "a", "0.1"}, replyType{"Array", nil}},
+		{[]interface{}{"rpush", "b", "b1", "b2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"brpop", "a", "b", "0"}, replyType{"Array", bulks("b", "b2")}},
+	}
+	runTest("BRPOP", tests, t)
+
+	reply := blockedDo("brpop", "a", "0")
+	runSteps("BRPOP", []rodisTest{
+		{[]interface{}{"lpush", "a", "a1", "a2"}, replyType{"Integer", int64(2)}},
+	}, t)
+	checkBlocked("BRPOP", reply, replyType{"Array", bulks("a", "a1")}, t)
+}
+
+func TestBrpoplpush(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"brpoplpush", "a", "b"}, replyType{"Error", "ERR wrong number of arguments for 'brpoplpush' command"}},
+		{[]interface{}{"brpoplpush", "a", "b", "0.1"}, replyType{"Array", nil}},
+		{[]interface{}{"rpush", "a", "a1", "a2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"brpoplpush", "a", "b", "0"}, replyType{"BulkString", []byte("a2")}},
+		{[]interface{}{"lrange", "b", "0", "-1"}, replyType{"Array", bulks("a2")}},
+	}
+	runTest("BRPOPLPUSH", tests, t)
+
+	reply := blockedDo("brpoplpush", "c", "b", "0")
+	runSteps("BRPOPLPUSH", []rodisTest{
+		{[]interface{}{"rpush", "c", "c1"}, replyType{"Integer", int64(1)}},
+	}, t)
+	checkBlocked("BRPOPLPUSH", reply, replyType{"BulkString", []byte("c1")}, t)
+	runSteps("BRPOPLPUSH", []rodisTest{
+		{[]interface{}{"lrange", "b", "0", "-1"}, replyType{"Array", bulks("c1", "a2")}},
+	}, t)
+}
+
+func TestLindex(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lindex", "a"}, replyType{"Error", "ERR wrong number of arguments for 'lindex' command"}},
+		{[]interface{}{"lindex", "a", "0"}, replyType{"BulkString", nil}},
+		{[]interface{}{"rpush", "a", "a1", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"lindex", "a", "b"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"lindex", "a", "0"}, replyType{"BulkString", []byte("a1")}},
+		{[]interface{}{"lindex", "a", "2"}, replyType{"BulkString", []byte("a3")}},
+		{[]interface{}{"lindex", "a", "3"}, replyType{"BulkString", nil}},
+		{[]interface{}{"lindex", "a", "-1"}, replyType{"BulkString", []byte("a3")}},
+		{[]interface{}{"lindex", "a", "-3"}, replyType{"BulkString", []byte("a1")}},
+		{[]interface{}{"lindex", "a", "-4"}, replyType{"BulkString", nil}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"lindex", "b", "0"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("LINDEX", tests, t)
+}
+
+func TestLinsert(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"linsert", "a", "before", "a1"}, replyType{"Error", "ERR wrong number of arguments for 'linsert' command"}},
+		{[]interface{}{"linsert", "a", "before", "a1", "a0"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"rpush", "a", "a1", "a3"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"linsert", "a", "middle", "a1", "a0"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"linsert", "a", "before", "a1", "a0"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"linsert", "a", "after", "a1", "a2"}, replyType{"Integer", int64(4)}},
+		{[]interface{}{"linsert", "a", "AFTER", "a3", "a4"}, replyType{"Integer", int64(5)}},
+		{[]interface{}{"linsert", "a", "before", "a5", "a4"}, replyType{"Integer", int64(-1)}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a0", "a1", "a2", "a3", "a4")}},
+		{[]interface{}{"lpush", "a", "b"}, replyType{"Integer", int64(6)}},
+		{[]interface{}{"lpop", "a"}, replyType{"BulkString", []byte("b")}},
+		{[]interface{}{"rpop", "a"}, replyType{"BulkString", []byte("a4")}},
+	}
+	runTest("LINSERT", tests, t)
+}
+
+func TestLlen(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"llen"}, replyType{"Error", "ERR wrong number of arguments for 'llen' command"}},
+		{[]interface{}{"llen", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"lpush", "a", "a1", "a2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"rpush", "a", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"llen", "a"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"llen", "b"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("LLEN", tests, t)
+}
+
+func TestLmove(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lmove", "a", "b", "left"}, replyType{"Error", "ERR wrong number of arguments for 'lmove' command"}},
+		{[]interface{}{"lmove", "a", "b", "left", "up"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"lmove", "a", "b", "left", "right"}, replyType{"BulkString", nil}},
+		{[]interface{}{"rpush", "a", "a1", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"lmove", "a", "b", "left", "right"}, replyType{"BulkString", []byte("a1")}},
+		{[]interface{}{"lmove", "a", "b", "RIGHT", "LEFT"}, replyType{"BulkString", []byte("a3")}},
+		{[]interface{}{"lrange", "b", "0", "-1"}, replyType{"Array", bulks("a3", "a1")}},
+		{[]interface{}{"lmove", "b", "b", "left", "right"}, replyType{"BulkString", []byte("a3")}},
+		{[]interface{}{"lrange", "b", "0", "-1"}, replyType{"Array", bulks("a1", "a3")}},
+		{[]interface{}{"lmove", "a", "b", "left", "left"}, replyType{"BulkString", []byte("a2")}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "c", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"lmove", "b", "c", "left", "left"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+		{[]interface{}{"lmove", "c", "b", "left", "left"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+		{[]interface{}{"llen", "b"}, replyType{"Integer", int64(3)}},
+	}
+	runTest("LMOVE", tests, t)
+}
+
+func TestLmpop(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lmpop", "1", "a"}, replyType{"Error", "ERR wrong number of arguments for 'lmpop' command"}},
+		{[]interface{}{"lmpop", "x", "a", "left"}, replyType{"Error", "ERR numkeys should be greater than 0"}},
+		{[]interface{}{"lmpop", "1", "a", "up"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"lmpop", "1", "a", "left", "count"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"lmpop", "2", "a", "b", "left"}, replyType{"Array", nil}},
+		{[]interface{}{"rpush", "b", "b1", "b2", "b3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"lmpop", "2", "a", "b", "left"}, replyType{"Array", []replyType{{"BulkString", []byte("b")}, {"Array", bulks("b1")}}}},
+		{[]interface{}{"lmpop", "2", "a", "b", "right", "count", "5"}, replyType{"Array", []replyType{{"BulkString", []byte("b")}, {"Array", bulks("b3", "b2")}}}},
+		{[]interface{}{"exists", "b"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"lmpop", "1", "a", "left"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("LMPOP", tests, t)
+}
+
+func TestLpop(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lpop"}, replyType{"Error", "ERR wrong number of arguments for 'lpop' command"}},
+		{[]interface{}{"lpop", "a"}, replyType{"BulkString", nil}},
+		{[]interface{}{"lpop", "a", "2"}, replyType{"Array", nil}},
+		{[]interface{}{"rpush", "a", "a1", "a2", "a3", "a4"}, replyType{"Integer", int64(4)}},
+		{[]interface{}{"lpop", "a", "-1"}, replyType{"Error", "ERR value is out of range, must be positive"}},
+		{[]interface{}{"lpop", "a"}, replyType{"BulkString", []byte("a1")}},
+		{[]interface{}{"lpop", "a", "2"}, replyType{"Array", bulks("a2", "a3")}},
+		{[]interface{}{"lpop", "a", "5"}, replyType{"Array", bulks("a4")}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("LPOP", tests, t)
+}
+
+func TestLpos(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lpos", "a"}, replyType{"Error", "ERR wrong number of arguments for 'lpos' command"}},
+		{[]interface{}{"lpos", "a", "b"}, replyType{"BulkString", nil}},
+		{[]interface{}{"rpush", "a", "a", "b", "c", "1", "2", "3", "c", "c"}, replyType{"Integer", int64(8)}},
+		{[]interface{}{"lpos", "a", "c"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"lpos", "a", "x"}, replyType{"BulkString", nil}},
+		{[]interface{}{"lpos", "a", "c", "rank", "2"}, replyType{"Integer", int64(6)}},
+		{[]interface{}{"lpos", "a", "c", "rank", "-1"}, replyType{"Integer", int64(7)}},
+		{[]interface{}{"lpos", "a", "c", "count", "2"}, replyType{"Array", integers(2, 6)}},
+		{[]interface{}{"lpos", "a", "c", "count", "0"}, replyType{"Array", integers(2, 6, 7)}},
+		{[]interface{}{"lpos", "a", "c", "rank", "-1", "count", "2"}, replyType{"Array", integers(7, 6)}},
+		{[]interface{}{"lpos", "a", "c", "count", "0", "maxlen", "3"}, replyType{"Array", integers(2)}},
+		{[]interface{}{"lpos", "a", "x", "count", "0"}, replyType{"Array", integers()}},
+		{[]interface{}{"lpos", "a", "c", "rank", "0"}, replyType{"Error", "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"}},
+		{[]interface{}{"lpos", "a", "c", "count", "-1"}, replyType{"Error", "ERR COUNT can't be negative"}},
+		{[]interface{}{"lpos", "a", "c", "maxlen", "-1"}, replyType{"Error", "ERR MAXLEN can't be negative"}},
+		{[]interface{}{"lpos", "a", "c", "rank"}, replyType{"Error", "ERR syntax error"}},
+	}
+	runTest("LPOS", tests, t)
+}
+
+func TestLpush(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lpush"}, replyType{"Error", "ERR wrong number of arguments for 'lpush' command"}},
+		{[]interface{}{"lpush", "a"}, replyType{"Error", "ERR wrong number of arguments for 'lpush' command"}},
+		{[]interface{}{"lpush", "a", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"lpush", "a", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a3", "a2", "a1")}},
+		{[]interface{}{"type", "a"}, replyType{"SimpleString", "list"}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"lpush", "b", "b1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+		{[]interface{}{"del", "a"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks()}},
+		{[]interface{}{"lpush", "a", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a1")}},
+	}
+	runTest("LPUSH", tests, t)
+}
+
+func TestLpushx(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lpushx", "a"}, replyType{"Error", "ERR wrong number of arguments for 'lpushx' command"}},
+		{[]interface{}{"lpushx", "a", "a1"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"lpush", "a", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"lpushx", "a", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a3", "a2", "a1")}},
+	}
+	runTest("LPUSHX", tests, t)
+}
+
+func TestLrange(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lrange", "a", "0"}, replyType{"Error", "ERR wrong number of arguments for 'lrange' command"}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks()}},
+		{[]interface{}{"rpush", "a", "a1", "a2", "a3", "a4"}, replyType{"Integer", int64(4)}},
+		{[]interface{}{"lrange", "a", "a", "-1"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"lrange", "a", "0", "0"}, replyType{"Array", bulks("a1")}},
+		{[]interface{}{"lrange", "a", "1", "2"}, replyType{"Array", bulks("a2", "a3")}},
+		{[]interface{}{"lrange", "a", "-3", "-2"}, replyType{"Array", bulks("a2", "a3")}},
+		{[]interface{}{"lrange", "a", "-100", "100"}, replyType{"Array", bulks("a1", "a2", "a3", "a4")}},
+		{[]interface{}{"lrange", "a", "3", "1"}, replyType{"Array", bulks()}},
+		{[]interface{}{"lrange", "a", "5", "10"}, replyType{"Array", bulks()}},
+	}
+	runTest("LRANGE", tests, t)
+}
+
+func TestLrem(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lrem", "a", "0"}, replyType{"Error", "ERR wrong number of arguments for 'lrem' command"}},
+		{[]interface{}{"lrem", "a", "0", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"rpush", "a", "x", "a", "x", "b", "x", "c", "x"}, replyType{"Integer", int64(7)}},
+		{[]interface{}{"lrem", "a", "b", "x"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"lrem", "a", "1", "x"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"lrem", "a", "-2", "x"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a", "x", "b", "c")}},
+		{[]interface{}{"lrem", "a", "0", "y"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"rpush", "a", "x"}, replyType{"Integer", int64(5)}},
+		{[]interface{}{"lrem", "a", "0", "x"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a", "b", "c")}},
+		{[]interface{}{"lpush", "b", "x", "x"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"lrem", "b", "0", "x"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"exists", "b"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("LREM", tests, t)
+}
+
+func TestLset(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"lset", "a", "0"}, replyType{"Error", "ERR wrong number of arguments for 'lset' command"}},
+		{[]interface{}{"lset", "a", "0", "a1"}, replyType{"Error", "ERR no such key"}},
+		{[]interface{}{"rpush", "a", "a1", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"lset", "a", "b", "a1"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"lset", "a", "3", "a4"}, replyType{"Error", "ERR index out of range"}},
+		{[]interface{}{"lset", "a", "0", "b1"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"lset", "a", "-1", "b3"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("b1", "a2", "b3")}},
+	}
+	runTest("LSET", tests, t)
+}
+
+func TestLtrim(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"ltrim", "a", "0"}, replyType{"Error", "ERR wrong number of arguments for 'ltrim' command"}},
+		{[]interface{}{"ltrim", "a", "0", "1"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"rpush", "a", "a1", "a2", "a3", "a4", "a5"}, replyType{"Integer", int64(5)}},
+		{[]interface{}{"ltrim", "a", "1", "-2"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a2", "a3", "a4")}},
+		{[]interface{}{"lpush", "a", "a1"}, replyType{"Integer", int64(4)}},
+		{[]interface{}{"rpush", "a", "a5"}, replyType{"Integer", int64(5)}},
+		{[]interface{}{"ltrim", "a", "0", "2"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a1", "a2", "a3")}},
+		{[]interface{}{"ltrim", "a", "5", "10"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("LTRIM", tests, t)
+}
+
+func TestRpop(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"rpop"}, replyType{"Error", "ERR wrong number of arguments for 'rpop' command"}},
+		{[]interface{}{"rpop", "a"}, replyType{"BulkString", nil}},
+		{[]interface{}{"rpush", "a", "a1", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"rpop", "a"}, replyType{"BulkString", []byte("a3")}},
+		{[]interface{}{"rpop", "a", "5"}, replyType{"Array", bulks("a2", "a1")}},
+		{[]interface{}{"rpop", "a"}, replyType{"BulkString", nil}},
+	}
+	runTest("RPOP", tests, t)
+}
+
+func TestRpoplpush(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"rpoplpush", "a"}, replyType{"Error", "ERR wrong number of arguments for 'rpoplpush' command"}},
+		{[]interface{}{"rpoplpush", "a", "b"}, replyType{"BulkString", nil}},
+		{[]interface{}{"rpush", "a", "a1", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"rpoplpush", "a", "b"}, replyType{"BulkString", []byte("a3")}},
+		{[]interface{}{"rpoplpush", "a", "b"}, replyType{"BulkString", []byte("a2")}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a1")}},
+		{[]interface{}{"lrange", "b", "0", "-1"}, replyType{"Array", bulks("a2", "a3")}},
+	}
+	runTest("RPOPLPUSH", tests, t)
+}
+
+func TestRpush(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"rpush"}, replyType{"Error", "ERR wrong number of arguments for 'rpush' command"}},
+		{[]interface{}{"rpush", "a"}, replyType{"Error", "ERR wrong number of arguments for 'rpush' command"}},
+		{[]interface{}{"rpush", "a", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"rpush", "a", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a1", "a2", "a3")}},
+	}
+	runTest("RPUSH", tests, t)
+}
+
+func TestRpushx(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"rpushx", "a"}, replyType{"Error", "ERR wrong number of arguments for 'rpushx' command"}},
+		{[]interface{}{"rpushx", "a", "a1"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"rpush", "a", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"rpushx", "a", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"lrange", "a", "0", "-1"}, replyType{"Array", bulks("a1", "a2", "a3")}},
+	}
+	runTest("RPUSHX", tests, t)
+}
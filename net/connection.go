@@ -11,11 +11,15 @@ import (
 	"io"
 	"net"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pborman/uuid"
 	"github.com/rod6/log6"
 
 	"github.com/rod6/rodis/command"
+	"github.com/rod6/rodis/config"
 	"github.com/rod6/rodis/resp"
 	"github.com/rod6/rodis/storage"
 )
@@ -25,21 +29,82 @@ type rodisConn struct {
 	db     *storage.LevelDB
 	conn   net.Conn
 	reader *bufio.Reader
+	writer *bufio.Writer // the replies, flushed when the next read would block
 	server *rodisServer
 	buffer bytes.Buffer
 	authed bool
 	extras *command.CommandExtras
+	idle   bool // the next command is read, so the timeout of the config applies, see idleDeadline
+
+	// The messages of the subscriptions are written by the push loop, while the replies by
+	// the handle loop. wmu guards the writer, it is held while a command is handled (except
+	// when it is blocked), so a reply is never preceded by a message published after it.
+	// The messages queued before are written ahead of the reply, see writePushes.
+	wmu        sync.Mutex
+	subs       [3]map[string]bool // the subscriptions by kind, guarded by the broker
+	pushes     chan resp.Value    // the messages to push, see push
+	pushed     chan struct{}      // wakes the push loop
+	pushBuffer bytes.Buffer       // guarded by wmu
+	startPush  sync.Once
+	done       chan struct{} // closed when the connection is closed
 }
 
+// writeBufferSize is the size of the reply buffer of a connection, a larger reply is written
+// through.
+const writeBufferSize = 16 * 1024
+
+// flushReader reads from the connection, the pending replies are flushed before, as the
+// read may block. So the replies of a pipeline are written together, when all the buffered
+// commands are handled.
+type flushReader struct {
+	rc *rodisConn
+}
+
+func (r flushReader) Read(p []byte) (int, error) {
+	r.rc.wmu.Lock()
+	err := r.rc.writer.Flush()
+	r.rc.wmu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if r.rc.idle {
+		r.rc.conn.SetReadDeadline(r.rc.idleDeadline())
+	}
+	return r.rc.conn.Read(p)
+}
+
+// idleDeadline returns the time the connection is closed at if no command comes, by the
+// timeout of the config. As redis, a subscriber is never closed for being idle.
+func (rc *rodisConn) idleDeadline() time.Time {
+	timeout := config.Get().Timeout
+	if timeout == 0 || rc.Count(command.ChannelKind)+rc.Count(command.ShardKind) > 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(timeout) * time.Second)
+}
+
+// lastClientID is the id of the last connection, the ids are increasing from 1.
+var lastClientID int64
+
 func newConnection(conn net.Conn, rs *rodisServer) {
 	uuid := uuid.New()
-	rc := &rodisConn{uuid: uuid, db: storage.SelectStorage(0), conn: conn, reader: bufio.NewReader(conn), server: rs}
+	rc := &rodisConn{uuid: uuid, db: storage.SelectStorage(0), conn: conn, server: rs, done: make(chan struct{})}
+	rc.writer = bufio.NewWriterSize(conn, writeBufferSize)
+	rc.reader = bufio.NewReader(flushReader{rc})
 
-	if rs.cfg.RequirePass == "" {
+	if config.Get().RequirePass == "" {
 		rc.authed = true
 	}
 
-	rc.extras = &command.CommandExtras{rc.db, &rc.buffer, rc.authed, rs.cfg.RequirePass}
+	rc.extras = &command.CommandExtras{
+		DB:           rc.db,
+		Buffer:       &rc.buffer,
+		IsConnAuthed: rc.authed,
+		ClientID:     atomic.AddInt64(&lastClientID, 1),
+		Protocol:     2,
+		PubSub:       rc,
+		WatchClose:   rc.watchClose,
+	}
 
 	rc.server.mu.Lock()
 	rs.conns[uuid] = rc
@@ -51,8 +116,14 @@ func newConnection(conn net.Conn, rs *rodisServer) {
 }
 
 func (rc *rodisConn) handle() {
+	defer close(rc.done)
+	defer rc.extras.Release()
+	defer rc.unsubscribeAll()
+
 	for {
-		respType, respValue, err := resp.Parse(rc.reader)
+		rc.idle = true
+		args, err := resp.ParseCommand(rc.reader)
+		rc.idle = false
 		if err != nil {
 			select {
 			case <-rc.server.quit: // Server is quit, rc.close() is called.
@@ -61,44 +132,93 @@ func (rc *rodisConn) handle() {
 				break
 			}
 
+			// The stream can not be parsed any more after any error, close the connection
 			if err == io.EOF { // Client close the connection
 				log6.Debug("Client close connection %v.", rc.uuid)
-				rc.close()
-				return
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log6.Debug("Connection %v is idle for the timeout.", rc.uuid)
+			} else if perr, ok := err.(resp.Error); ok { // Malformed input, tell the client why
+				log6.Warn("Connection %v protocol error: %v", rc.uuid, perr)
+				rc.wmu.Lock()
+				rc.writer.WriteString("-" + perr.Error() + "\r\n")
+				rc.writer.Flush()
+				rc.wmu.Unlock()
 			} else {
 				log6.Warn("Connection %v error: %v", rc.uuid, err)
-				continue // Other error, should continue the connection
 			}
+			rc.close()
+			return
 		}
 
-		rc.response(respType, respValue)
+		rc.response(args)
+		if rc.extras.Quit {
+			rc.wmu.Lock()
+			rc.writer.Flush()
+			rc.wmu.Unlock()
+			rc.close()
+			return
+		}
 	}
 }
 
-func (rc *rodisConn) response(respType resp.RESPType, respValue resp.Value) {
+func (rc *rodisConn) response(args resp.CommandArgs) {
+	rc.wmu.Lock()
+	defer rc.wmu.Unlock()
+
 	defer func() {
 		if err := recover(); err != nil {
 			stack := make([]byte, 2048)
 			stack = stack[:runtime.Stack(stack, false)]
-			log6.Error("Panci in handling connection %v, command is %v, err is %s\n%s", rc.uuid, respValue, err, stack)
-			rc.conn.Write([]byte("-ERR server unknown error\r\n"))
+			log6.Error("Panci in handling connection %v, command is %v, err is %s\n%s", rc.uuid, args, err, stack)
+			rc.writer.WriteString("-ERR server unknown error\r\n")
 		}
 	}()
 
-	if respType != resp.ArrayType { // All command from client should be RESPArrayType
-		log6.Error("Connection %v get a WRONG format command from client.", rc.uuid)
-		rc.conn.Write([]byte("-ERR wrong input format\r\n"))
-		return
-	}
-
-	err := command.Handle(respValue.(resp.Array), rc.extras)
+	err := command.Handle(args, rc.extras)
 	if err != nil {
 		log6.Error("Connection %v get a server error: %v", rc.uuid, err)
-		rc.conn.Write([]byte("-ERR server unknown error\r\n"))
+		rc.writer.WriteString("-ERR server unknown error\r\n")
 		return
 	}
 
-	rc.conn.Write(rc.buffer.Bytes())
+	rc.writePushes()
+	rc.writer.Write(rc.buffer.Bytes())
+}
+
+// watchClose watches the connection while a command is blocked, the returned channel is
+// closed when the client closes the connection. stop interrupts the watching read and
+// waits for it, so the reader is owned by the handle loop again. The writer is released
+// to the push loop until stop.
+func (rc *rodisConn) watchClose() (<-chan struct{}, func()) {
+	// the replies before the blocked command are not held by it, even if the next commands
+	// are buffered already, so the reader does not flush. A blocked client is not idle, the
+	// deadline of the last read is cleared.
+	rc.writer.Flush()
+	rc.wmu.Unlock()
+	rc.conn.SetReadDeadline(time.Time{})
+
+	closed := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_, err := rc.reader.Peek(1)
+		if err == nil { // the client sends the next command, it is read after the blocked one
+			return
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return
+		}
+		close(closed)
+	}()
+
+	stop := func() {
+		rc.conn.SetReadDeadline(time.Now())
+		<-done
+		rc.conn.SetReadDeadline(time.Time{})
+		rc.wmu.Lock()
+	}
+	return closed, stop
 }
 
 func (rc *rodisConn) close() {
@@ -0,0 +1,235 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package net
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/rod6/log6"
+
+	"github.com/rod6/rodis/command"
+	"github.com/rod6/rodis/resp"
+)
+
+// pushQueueSize is the number of the messages queued for a subscriber. A subscriber too slow
+// to take them is disconnected, so a publisher never blocks.
+const pushQueueSize = 1024
+
+// broker keeps the subscribers of every channel and pattern, by the kind of the subscription.
+// The subscriptions of a connection are kept in it as well, both guarded by mu.
+type broker struct {
+	mu   sync.RWMutex
+	subs [3]map[string]map[*rodisConn]bool
+}
+
+func newBroker() *broker {
+	b := &broker{}
+	for kind := range b.subs {
+		b.subs[kind] = make(map[string]map[*rodisConn]bool)
+	}
+	return b
+}
+
+// Subscribe and the methods below make rodisConn the command.PubSub of its connection.
+func (rc *rodisConn) Subscribe(kind int, name []byte) {
+	b := rc.server.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rc.startPush.Do(func() {
+		rc.pushes = make(chan resp.Value, pushQueueSize)
+		rc.pushed = make(chan struct{}, 1)
+		go rc.pushLoop()
+	})
+
+	if rc.subs[kind] == nil {
+		rc.subs[kind] = make(map[string]bool)
+	}
+	rc.subs[kind][string(name)] = true
+
+	conns, ok := b.subs[kind][string(name)]
+	if !ok {
+		conns = make(map[*rodisConn]bool)
+		b.subs[kind][string(name)] = conns
+	}
+	conns[rc] = true
+}
+
+func (rc *rodisConn) Unsubscribe(kind int, name []byte) {
+	b := rc.server.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rc.unsubscribe(kind, string(name))
+}
+
+// unsubscribe should be called with the broker locked.
+func (rc *rodisConn) unsubscribe(kind int, name string) {
+	delete(rc.subs[kind], name)
+
+	conns := rc.server.broker.subs[kind][name]
+	delete(conns, rc)
+	if len(conns) == 0 {
+		delete(rc.server.broker.subs[kind], name)
+	}
+}
+
+// unsubscribeAll removes all the subscriptions, when the connection is closed.
+func (rc *rodisConn) unsubscribeAll() {
+	b := rc.server.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for kind := range rc.subs {
+		for name := range rc.subs[kind] {
+			rc.unsubscribe(kind, name)
+		}
+	}
+}
+
+func (rc *rodisConn) Subscriptions(kind int) [][]byte {
+	b := rc.server.broker
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	names := make([]string, 0, len(rc.subs[kind]))
+	for name := range rc.subs[kind] {
+		names = append(names, name)
+	}
+	return sortedNames(names)
+}
+
+func (rc *rodisConn) Count(kind int) int {
+	b := rc.server.broker
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if kind == command.ShardKind {
+		return len(rc.subs[command.ShardKind])
+	}
+	return len(rc.subs[command.ChannelKind]) + len(rc.subs[command.PatternKind])
+}
+
+func (rc *rodisConn) Publish(kind int, channel []byte, message []byte) int {
+	return rc.server.broker.Publish(kind, channel, message)
+}
+
+// Publish makes the broker the command.Publisher of the keyspace notifications.
+func (b *broker) Publish(kind int, channel []byte, message []byte) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	tipe := resp.BulkString("message")
+	if kind == command.ShardKind {
+		tipe = resp.BulkString("smessage")
+	}
+
+	receivers := 0
+	for conn := range b.subs[kind][string(channel)] {
+		conn.push(resp.Push{tipe, resp.BulkString(channel), resp.BulkString(message)})
+		receivers++
+	}
+	if kind != command.ChannelKind {
+		return receivers
+	}
+
+	for pattern, conns := range b.subs[command.PatternKind] {
+		if !command.MatchPattern([]byte(pattern), channel) {
+			continue
+		}
+		for conn := range conns {
+			conn.push(resp.Push{resp.BulkString("pmessage"), resp.BulkString(pattern), resp.BulkString(channel), resp.BulkString(message)})
+			receivers++
+		}
+	}
+	return receivers
+}
+
+func (rc *rodisConn) Channels(kind int, pattern []byte) [][]byte {
+	b := rc.server.broker
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	names := []string{}
+	for name := range b.subs[kind] {
+		if pattern == nil || command.MatchPattern(pattern, []byte(name)) {
+			names = append(names, name)
+		}
+	}
+	return sortedNames(names)
+}
+
+func (rc *rodisConn) NumSub(kind int, channel []byte) int {
+	b := rc.server.broker
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subs[kind][string(channel)])
+}
+
+func (rc *rodisConn) NumPat() int {
+	b := rc.server.broker
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subs[command.PatternKind])
+}
+
+func sortedNames(names []string) [][]byte {
+	sort.Strings(names)
+	result := make([][]byte, len(names))
+	for i, name := range names {
+		result[i] = []byte(name)
+	}
+	return result
+}
+
+// push queues the message for the connection, without blocking.
+func (rc *rodisConn) push(v resp.Value) {
+	select {
+	case rc.pushes <- v:
+	default:
+		log6.Warn("Connection %v is too slow to take the messages, close it.", rc.uuid)
+		rc.conn.Close() // the handle loop gets the error and cleans up
+		return
+	}
+	select {
+	case rc.pushed <- struct{}{}:
+	default: // the push loop is woken already
+	}
+}
+
+// pushLoop writes the queued messages to the connection when woken by push, and flushes them.
+func (rc *rodisConn) pushLoop() {
+	for {
+		select {
+		case <-rc.pushed:
+			rc.wmu.Lock()
+			rc.writePushes()
+			rc.writer.Flush()
+			rc.wmu.Unlock()
+		case <-rc.done:
+			return
+		}
+	}
+}
+
+// writePushes writes the queued messages in the protocol of the connection when written. It
+// should be called with wmu locked, by the push loop, or by the handle loop before a reply, so
+// the reply is never ahead of a message queued before it.
+func (rc *rodisConn) writePushes() {
+	for {
+		select {
+		case v := <-rc.pushes:
+			rc.pushBuffer.Reset()
+			resp.Protocol(v, rc.extras.Protocol).WriteTo(&rc.pushBuffer)
+			rc.writer.Write(rc.pushBuffer.Bytes())
+		default:
+			return
+		}
+	}
+}
@@ -8,9 +8,11 @@ package net
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 
 	"github.com/rod6/log6"
 
+	"github.com/rod6/rodis/command"
 	"github.com/rod6/rodis/config"
 )
 
@@ -18,13 +20,28 @@ type rodisServer struct {
 	cfg      *config.RodisConfig
 	listener net.Listener
 	conns    map[string]*rodisConn
+	broker   *broker
 	mu       sync.Mutex
 	started  bool
 	quit     chan bool
 }
 
 func NewServer(config config.RodisConfig) (*rodisServer, error) {
-	return &rodisServer{cfg: &config, conns: make(map[string]*rodisConn), quit: make(chan bool)}, nil
+	rs := &rodisServer{cfg: &config, conns: make(map[string]*rodisConn), broker: newBroker(), quit: make(chan bool)}
+	command.SetPublisher(rs.broker)
+	command.SetServer(rs)
+	return rs, nil
+}
+
+// ConnectedClients and TotalConnections make the server the command.Server of INFO.
+func (rs *rodisServer) ConnectedClients() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return len(rs.conns)
+}
+
+func (rs *rodisServer) TotalConnections() int64 {
+	return atomic.LoadInt64(&lastClientID)
 }
 
 func (rs *rodisServer) Run() {
@@ -36,18 +53,33 @@ func (rs *rodisServer) Run() {
 		return
 	}
 
+	rs.mu.Lock()
 	rs.listener = listener
 	rs.started = true
+	rs.mu.Unlock()
 
+	rs.serve(listener)
+}
+
+// serve accepts the connections on listener, until the server is closed or listens on another
+// address.
+func (rs *rodisServer) serve(listener net.Listener) {
 	for {
-		conn, err := rs.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			select {
 			case <-rs.quit:
 				return
 			default:
-				log6.Warn("Server accepts connection error: %v", err)
 			}
+
+			rs.mu.Lock()
+			replaced := rs.listener != listener
+			rs.mu.Unlock()
+			if replaced {
+				return
+			}
+			log6.Warn("Server accepts connection error: %v", err)
 			continue
 		}
 
@@ -55,11 +87,35 @@ func (rs *rodisServer) Run() {
 	}
 }
 
+// Listen makes the server listen on addr instead, for the config reloaded. The old listener is
+// closed once the new one is ready, the connections accepted are kept.
+func (rs *rodisServer) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	old := rs.listener
+	rs.listener = listener
+	rs.cfg.Listen = addr
+	rs.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	log6.Info("Server listens on %v", addr)
+	go rs.serve(listener)
+	return nil
+}
+
 func (rs *rodisServer) Close() {
 	log6.Info("Server is closing...")
 	if rs.started {
 		close(rs.quit)
+		rs.mu.Lock()
 		rs.listener.Close()
+		rs.mu.Unlock()
 
 		for _, rc := range rs.conns {
 			rc.close()
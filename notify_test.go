@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// keyspaceEvents are the messages of the events on the key to __keyspace@0__:notify.*, and
+// the reply of the ping after them.
+func keyspaceEvents(key string, events ...string) []replyType {
+	replies := []replyType{}
+	for _, event := range events {
+		replies = append(replies, pushed("pmessage", "__keyspace@0__:notify.*", "__keyspace@0__:"+key, event))
+	}
+	return append(replies, pushed("pong", ""))
+}
+
+func TestKeyspaceNotification(t *testing.T) {
+	runTest("NOTIFY", []rodisTest{}, t)
+
+	// the events are off in rodis.toml
+	if _, err := re.Do("config", "set", "notify-keyspace-events", "KEA"); err != nil {
+		t.Fatalf("Error NOTIFY, CONFIG SET: %v", err)
+	}
+	defer re.Do("config", "set", "notify-keyspace-events", "")
+
+	c := subscriber(t)
+	defer c.Close()
+	subscribeSteps("NOTIFY", c, []interface{}{"psubscribe", "__keyspace@0__:notify.*"}, []replyType{
+		pushed("psubscribe", "__keyspace@0__:notify.*", 1),
+	}, t)
+	subscribeSteps("NOTIFY", c, []interface{}{"subscribe", "__keyevent@0__:hdel"}, []replyType{
+		pushed("subscribe", "__keyevent@0__:hdel", 2),
+	}, t)
+
+	steps := []struct {
+		command []interface{}
+		reply   replyType
+		key     string
+		events  []string
+	}{
+		{[]interface{}{"set", "notify.s", "foo"}, replyType{"SimpleString", "OK"}, "notify.s", []string{"set"}},
+		{[]interface{}{"append", "notify.s", "bar"}, replyType{"Integer", int64(6)}, "notify.s", []string{"append"}},
+		{[]interface{}{"set", "notify.s", "foo", "ex", "100"}, replyType{"SimpleString", "OK"}, "notify.s", []string{"set", "expire"}},
+		{[]interface{}{"persist", "notify.s"}, replyType{"Integer", int64(1)}, "notify.s", []string{"persist"}},
+		{[]interface{}{"del", "notify.s", "notify.x"}, replyType{"Integer", int64(1)}, "notify.s", []string{"del"}},
+		{[]interface{}{"incrby", "notify.n", "5"}, replyType{"Integer", int64(5)}, "notify.n", []string{"incrby"}},
+		{[]interface{}{"expire", "notify.n", "-1"}, replyType{"Integer", int64(1)}, "notify.n", []string{"del"}},
+		{[]interface{}{"rpush", "notify.l", "a", "b"}, replyType{"Integer", int64(2)}, "notify.l", []string{"rpush"}},
+		{[]interface{}{"lpop", "notify.l", "0"}, replyType{"Array", []replyType{}}, "notify.l", []string{}},
+		{[]interface{}{"lpop", "notify.l", "2"}, replyType{"Array", []replyType{{"BulkString", []byte("a")}, {"BulkString", []byte("b")}}}, "notify.l", []string{"lpop", "del"}},
+		{[]interface{}{"sadd", "notify.set", "a"}, replyType{"Integer", int64(1)}, "notify.set", []string{"sadd"}},
+		{[]interface{}{"sadd", "notify.set", "a"}, replyType{"Integer", int64(0)}, "notify.set", []string{}},
+		{[]interface{}{"srem", "notify.set", "a"}, replyType{"Integer", int64(1)}, "notify.set", []string{"srem", "del"}},
+		{[]interface{}{"zadd", "notify.z", "1", "a"}, replyType{"Integer", int64(1)}, "notify.z", []string{"zadd"}},
+		{[]interface{}{"zincrby", "notify.z", "1", "a"}, replyType{"BulkString", []byte("2")}, "notify.z", []string{"zincr"}},
+		{[]interface{}{"zunionstore", "notify.z", "1", "notify.x"}, replyType{"Integer", int64(0)}, "notify.z", []string{"del"}},
+		{[]interface{}{"hset", "notify.h", "f", "v"}, replyType{"Integer", int64(1)}, "notify.h", []string{"hset"}},
+	}
+	for _, step := range steps {
+		runSteps("NOTIFY", []rodisTest{{step.command, step.reply}}, t)
+		subscribeSteps("NOTIFY", c, []interface{}{"ping"}, keyspaceEvents(step.key, step.events...), t)
+	}
+
+	// hdel is published to both channels, the keyevent one with the key as the message
+	runSteps("NOTIFY", []rodisTest{
+		{[]interface{}{"hdel", "notify.h", "f"}, replyType{"Integer", int64(1)}},
+	}, t)
+	subscribeSteps("NOTIFY", c, []interface{}{"ping"}, []replyType{
+		pushed("pmessage", "__keyspace@0__:notify.*", "__keyspace@0__:notify.h", "hdel"),
+		pushed("message", "__keyevent@0__:hdel", "notify.h"),
+		pushed("pmessage", "__keyspace@0__:notify.*", "__keyspace@0__:notify.h", "del"),
+		pushed("pong", ""),
+	}, t)
+
+	// an expired key is told by the expirer or the command finding it, whichever is first
+	runSteps("NOTIFY", []rodisTest{
+		{[]interface{}{"psetex", "notify.e", "50", "foo"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	time.Sleep(200 * time.Millisecond)
+	runSteps("NOTIFY", []rodisTest{
+		{[]interface{}{"get", "notify.e"}, replyType{"BulkString", nil}},
+	}, t)
+	subscribeSteps("NOTIFY", c, []interface{}{"ping"}, keyspaceEvents("notify.e", "set", "expire", "expired"), t)
+
+	// no event in other dbs for the subscribed channels
+	runSteps("NOTIFY", []rodisTest{
+		{[]interface{}{"select", "1"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "notify.s", "foo"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"del", "notify.s"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"select", "0"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	subscribeSteps("NOTIFY", c, []interface{}{"ping"}, keyspaceEvents("notify.s"), t)
+
+	// the events of a transaction are published when it is committed, a killed script has none
+	runSteps("NOTIFY", []rodisTest{
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "notify.t", "foo"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{{"SimpleString", "OK"}}}},
+	}, t)
+	subscribeSteps("NOTIFY", c, []interface{}{"ping"}, keyspaceEvents("notify.t", "set"), t)
+	done := busyDo("eval", "redis.call('set', 'notify.t', 'bar') while true do end", "0")
+	runSteps("NOTIFY", []rodisTest{
+		{[]interface{}{"script", "kill"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	killed("NOTIFY", done, t)
+	subscribeSteps("NOTIFY", c, []interface{}{"ping"}, keyspaceEvents("notify.t"), t)
+}
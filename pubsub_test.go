@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// subscriber dials a connection for the subscriptions, as a pooled connection would be reused
+// in the subscribed mode.
+func subscriber(t *testing.T) redis.Conn {
+	c, err := redis.Dial("tcp", ":6379", redis.DialReadTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	c.Do("AUTH", "password")
+	return c
+}
+
+// pushed builds the expected reply of a subscription or a message
+func pushed(values ...interface{}) replyType {
+	arr := []replyType{}
+	for _, v := range values {
+		switch v := v.(type) {
+		case int:
+			arr = append(arr, replyType{"Integer", int64(v)})
+		case string:
+			arr = append(arr, replyType{"BulkString", []byte(v)})
+		default:
+			arr = append(arr, replyType{"BulkString", nil})
+		}
+	}
+	return replyType{"Array", arr}
+}
+
+// subscribeSteps sends the command on c, and checks the replies received.
+func subscribeSteps(name string, c redis.Conn, command []interface{}, replies []replyType, t *testing.T) {
+	c.Send(command[0].(string), command[1:]...)
+	c.Flush()
+	for i, reply := range replies {
+		r, err := c.Receive()
+		if rerr, ok := err.(redis.Error); ok {
+			r = rerr
+		}
+		if !check(r, reply) {
+			t.Errorf("Error %v[%v](%v), Get: %#v, %#v", name, i, command, r, err)
+		}
+	}
+}
+
+func TestPsubscribe(t *testing.T) {
+	runTest("PSUBSCRIBE", []rodisTest{
+		{[]interface{}{"psubscribe"}, replyType{"Error", "ERR wrong number of arguments for 'psubscribe' command"}},
+	}, t)
+
+	c := subscriber(t)
+	defer c.Close()
+	subscribeSteps("PSUBSCRIBE", c, []interface{}{"subscribe", "psub.a"}, []replyType{pushed("subscribe", "psub.a", 1)}, t)
+	subscribeSteps("PSUBSCRIBE", c, []interface{}{"psubscribe", "psub.*", "psub.[ab]"}, []replyType{
+		pushed("psubscribe", "psub.*", 2),
+		pushed("psubscribe", "psub.[ab]", 3),
+	}, t)
+	runSteps("PSUBSCRIBE", []rodisTest{
+		{[]interface{}{"publish", "psub.c", "foobar"}, replyType{"Integer", int64(1)}},
+	}, t)
+	subscribeSteps("PSUBSCRIBE", c, []interface{}{"ping"}, []replyType{
+		pushed("pmessage", "psub.*", "psub.c", "foobar"),
+		pushed("pong", ""),
+	}, t)
+	runSteps("PSUBSCRIBE", []rodisTest{
+		{[]interface{}{"publish", "psub.a", "dongr"}, replyType{"Integer", int64(3)}},
+	}, t)
+	for i := 0; i < 3; i++ { // a message for each subscription, in any order
+		r, err := c.Receive()
+		if arr, ok := r.([]interface{}); !ok || len(arr) < 3 || string(arr[len(arr)-1].([]byte)) != "dongr" {
+			t.Errorf("Error PSUBSCRIBE message, Get: %#v, %#v", r, err)
+		}
+	}
+	subscribeSteps("PSUBSCRIBE", c, []interface{}{"punsubscribe"}, []replyType{
+		pushed("punsubscribe", "psub.*", 2),
+		pushed("punsubscribe", "psub.[ab]", 1),
+	}, t)
+	subscribeSteps("PSUBSCRIBE", c, []interface{}{"punsubscribe"}, []replyType{pushed("punsubscribe", nil, 1)}, t)
+	subscribeSteps("PSUBSCRIBE", c, []interface{}{"unsubscribe"}, []replyType{pushed("unsubscribe", "psub.a", 0)}, t)
+}
+
+func TestPublish(t *testing.T) {
+	runTest("PUBLISH", []rodisTest{
+		{[]interface{}{"publish", "pub.a"}, replyType{"Error", "ERR wrong number of arguments for 'publish' command"}},
+		{[]interface{}{"publish", "pub.a", "foobar"}, replyType{"Integer", int64(0)}},
+	}, t)
+
+	c1, c2 := subscriber(t), subscriber(t)
+	defer c1.Close()
+	defer c2.Close()
+	subscribeSteps("PUBLISH", c1, []interface{}{"subscribe", "pub.a"}, []replyType{pushed("subscribe", "pub.a", 1)}, t)
+	subscribeSteps("PUBLISH", c2, []interface{}{"subscribe", "pub.a", "pub.b"}, []replyType{
+		pushed("subscribe", "pub.a", 1),
+		pushed("subscribe", "pub.b", 2),
+	}, t)
+	runSteps("PUBLISH", []rodisTest{
+		{[]interface{}{"publish", "pub.a", "foobar"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"publish", "pub.b", "dongr"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"spublish", "pub.a", "foobar"}, replyType{"Integer", int64(0)}},
+	}, t)
+	subscribeSteps("PUBLISH", c1, []interface{}{"ping"}, []replyType{
+		pushed("message", "pub.a", "foobar"),
+		pushed("pong", ""),
+	}, t)
+	subscribeSteps("PUBLISH", c2, []interface{}{"ping"}, []replyType{
+		pushed("message", "pub.a", "foobar"),
+		pushed("message", "pub.b", "dongr"),
+		pushed("pong", ""),
+	}, t)
+
+	// the messages published before a command are all written ahead of its reply, even if
+	// they are too many to be written at once
+	replies := []replyType{}
+	for i := 0; i < 1000; i++ {
+		message := strconv.Itoa(i) + strings.Repeat("x", 8*1024)
+		re.Send("publish", "pub.a", message)
+		replies = append(replies, pushed("message", "pub.a", message))
+	}
+	if _, err := re.Do(""); err != nil {
+		t.Fatalf("Error PUBLISH in order: %v", err)
+	}
+	subscribeSteps("PUBLISH", c1, []interface{}{"ping"}, append(replies, pushed("pong", "")), t)
+
+	// A subscriber too slow to take the messages is disconnected, the publisher goes on
+	slow := subscriber(t)
+	defer slow.Close()
+	subscribeSteps("PUBLISH", slow, []interface{}{"subscribe", "pub.slow"}, []replyType{pushed("subscribe", "pub.slow", 1)}, t)
+	message := strings.Repeat("x", 64*1024)
+	for i := 0; i < 2000; i++ {
+		if _, err := re.Do("publish", "pub.slow", message); err != nil {
+			t.Fatalf("Error PUBLISH slow subscriber: %v", err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r, _ := redis.Values(re.Do("pubsub", "numsub", "pub.slow"))
+		if len(r) == 2 && r[1].(int64) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("Error PUBLISH slow subscriber is not disconnected")
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPubsub(t *testing.T) {
+	c := subscriber(t)
+	defer c.Close()
+	subscribeSteps("PUBSUB", c, []interface{}{"subscribe", "pubsub.a", "pubsub.b"}, []replyType{
+		pushed("subscribe", "pubsub.a", 1),
+		pushed("subscribe", "pubsub.b", 2),
+	}, t)
+	subscribeSteps("PUBSUB", c, []interface{}{"psubscribe", "pubsub.*"}, []replyType{pushed("psubscribe", "pubsub.*", 3)}, t)
+	subscribeSteps("PUBSUB", c, []interface{}{"ssubscribe", "pubsub.s"}, []replyType{pushed("ssubscribe", "pubsub.s", 1)}, t)
+
+	runTest("PUBSUB", []rodisTest{
+		{[]interface{}{"pubsub"}, replyType{"Error", "ERR wrong number of arguments for 'pubsub' command"}},
+		{[]interface{}{"pubsub", "foo"}, replyType{"Error", "ERR unknown subcommand 'foo'. Try PUBSUB HELP."}},
+		{[]interface{}{"pubsub", "channels", "pubsub.*"}, replyType{"Array", bulks("pubsub.a", "pubsub.b")}},
+		{[]interface{}{"pubsub", "channels", "pubsub.[a]"}, replyType{"Array", bulks("pubsub.a")}},
+		{[]interface{}{"pubsub", "channels", "a", "b"}, replyType{"Error", "ERR wrong number of arguments for 'pubsub|channels' command"}},
+		{[]interface{}{"pubsub", "numsub", "pubsub.a", "pubsub.c"}, replyType{"Array", []replyType{
+			{"BulkString", []byte("pubsub.a")}, {"Integer", int64(1)},
+			{"BulkString", []byte("pubsub.c")}, {"Integer", int64(0)},
+		}}},
+		{[]interface{}{"pubsub", "numsub"}, replyType{"Array", []replyType{}}},
+		{[]interface{}{"pubsub", "numpat"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"pubsub", "numpat", "a"}, replyType{"Error", "ERR wrong number of arguments for 'pubsub|numpat' command"}},
+		{[]interface{}{"pubsub", "shardchannels", "pubsub.*"}, replyType{"Array", bulks("pubsub.s")}},
+		{[]interface{}{"pubsub", "shardnumsub", "pubsub.s", "pubsub.a"}, replyType{"Array", []replyType{
+			{"BulkString", []byte("pubsub.s")}, {"Integer", int64(1)},
+			{"BulkString", []byte("pubsub.a")}, {"Integer", int64(0)},
+		}}},
+	}, t)
+
+	subscribeSteps("PUBSUB", c, []interface{}{"punsubscribe"}, []replyType{pushed("punsubscribe", "pubsub.*", 2)}, t)
+	runSteps("PUBSUB", []rodisTest{
+		{[]interface{}{"pubsub", "numpat"}, replyType{"Integer", int64(0)}},
+	}, t)
+}
+
+func TestSsubscribe(t *testing.T) {
+	c := subscriber(t)
+	defer c.Close()
+	subscribeSteps("SSUBSCRIBE", c, []interface{}{"ssubscribe", "ssub.a", "ssub.b"}, []replyType{
+		pushed("ssubscribe", "ssub.a", 1),
+		pushed("ssubscribe", "ssub.b", 2),
+	}, t)
+	runTest("SSUBSCRIBE", []rodisTest{
+		{[]interface{}{"publish", "ssub.a", "foobar"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"spublish", "ssub.a", "foobar"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"spublish", "ssub.a"}, replyType{"Error", "ERR wrong number of arguments for 'spublish' command"}},
+	}, t)
+	subscribeSteps("SSUBSCRIBE", c, []interface{}{"sunsubscribe", "ssub.a"}, []replyType{
+		pushed("smessage", "ssub.a", "foobar"),
+		pushed("sunsubscribe", "ssub.a", 1),
+	}, t)
+	subscribeSteps("SSUBSCRIBE", c, []interface{}{"get", "a"}, []replyType{
+		{"Error", "ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"},
+	}, t)
+	subscribeSteps("SSUBSCRIBE", c, []interface{}{"sunsubscribe"}, []replyType{pushed("sunsubscribe", "ssub.b", 0)}, t)
+	subscribeSteps("SSUBSCRIBE", c, []interface{}{"get", "a"}, []replyType{{"BulkString", nil}}, t)
+}
+
+func TestSubscribe(t *testing.T) {
+	runTest("SUBSCRIBE", []rodisTest{
+		{[]interface{}{"subscribe"}, replyType{"Error", "ERR wrong number of arguments for 'subscribe' command"}},
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"subscribe", "a"}, replyType{"Error", "ERR Command not allowed inside a transaction"}},
+		{[]interface{}{"discard"}, replyType{"SimpleString", "OK"}},
+	}, t)
+
+	c := subscriber(t)
+	defer c.Close()
+	subscribeSteps("SUBSCRIBE", c, []interface{}{"subscribe", "sub.a", "sub.b", "sub.a"}, []replyType{
+		pushed("subscribe", "sub.a", 1),
+		pushed("subscribe", "sub.b", 2),
+		pushed("subscribe", "sub.a", 2),
+	}, t)
+	subscribeSteps("SUBSCRIBE", c, []interface{}{"set", "a", "foobar"}, []replyType{
+		{"Error", "ERR Can't execute 'set': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"},
+	}, t)
+	subscribeSteps("SUBSCRIBE", c, []interface{}{"unsubscribe", "sub.b", "sub.c"}, []replyType{
+		pushed("unsubscribe", "sub.b", 1),
+		pushed("unsubscribe", "sub.c", 1),
+	}, t)
+	subscribeSteps("SUBSCRIBE", c, []interface{}{"unsubscribe"}, []replyType{pushed("unsubscribe", "sub.a", 0)}, t)
+	subscribeSteps("SUBSCRIBE", c, []interface{}{"unsubscribe"}, []replyType{pushed("unsubscribe", nil, 0)}, t)
+	subscribeSteps("SUBSCRIBE", c, []interface{}{"ping"}, []replyType{{"SimpleString", "PONG"}}, t)
+
+	// In RESP3 the messages are pushed, the connection runs any command while subscribed
+	conn, err := net.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+	readReply := func(reply string) {
+		got := make([]byte, len(reply))
+		if _, err := io.ReadFull(reader, got); err != nil || string(got) != reply {
+			t.Errorf("Error SUBSCRIBE RESP3, Get: %q, %v, Expect: %q", got, err, reply)
+		}
+	}
+
+	conn.Write([]byte("HELLO 3 AUTH default password\r\n"))
+	for { // the reply ends with the empty modules
+		line, err := reader.ReadString('\n')
+		if err != nil || line == "*0\r\n" {
+			break
+		}
+	}
+	conn.Write([]byte("SUBSCRIBE sub.resp3\r\n"))
+	readReply(">3\r\n$9\r\nsubscribe\r\n$9\r\nsub.resp3\r\n:1\r\n")
+	runSteps("SUBSCRIBE", []rodisTest{
+		{[]interface{}{"publish", "sub.resp3", "foobar"}, replyType{"Integer", int64(1)}},
+	}, t)
+	readReply(">3\r\n$7\r\nmessage\r\n$9\r\nsub.resp3\r\n$6\r\nfoobar\r\n")
+	conn.Write([]byte("PING\r\n"))
+	readReply("+PONG\r\n")
+}
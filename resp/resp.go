@@ -17,9 +17,140 @@ package resp
 
 import (
 	"bufio"
+	"io"
+	"strconv"
+	"sync/atomic"
 )
 
+// The errors of the malformed input. They are RESP errors, so the server can reply them
+// before closing the connection, as the rest of the input can not be parsed any more.
+const (
+	ErrInvalidBulkLength      = Error("ERR Protocol error: invalid bulk length")
+	ErrBulkNoCRLF             = Error("ERR Protocol error: expected '\\r\\n' after bulk string")
+	ErrInvalidMultibulkLength = Error("ERR Protocol error: invalid multibulk length")
+	ErrInvalidInteger         = Error("ERR Protocol error: invalid integer")
+	ErrLineTooLong            = Error("ERR Protocol error: too big line")
+	ErrTooDeepNesting         = Error("ERR Protocol error: too deep nesting")
+	ErrUnbalancedQuotes       = Error("ERR Protocol error: unbalanced quotes in request")
+	ErrFmtUnexpectedPrefix    = "ERR Protocol error: expected '$', got '%c'"
+)
+
+// The default limits of the input from the client.
+const (
+	DefaultMaxBulkLen      = 512 * 1024 * 1024 // max length of a bulk string, 512M
+	DefaultMaxMultibulkLen = 1024 * 1024       // max number of the elements of an array
+	DefaultMaxNesting      = 8                 // max depth of the arrays in arrays
+)
+
+var (
+	maxBulkLen      int64 = DefaultMaxBulkLen
+	maxMultibulkLen int64 = DefaultMaxMultibulkLen
+	maxNesting      int64 = DefaultMaxNesting
+)
+
+// SetMaxBulkLen sets the max length of a bulk string from the client (proto-max-bulk-len),
+// a longer one is a protocol error.
+func SetMaxBulkLen(n int64) {
+	atomic.StoreInt64(&maxBulkLen, n)
+}
+
+// MaxBulkLen returns the max length of a bulk string from the client.
+func MaxBulkLen() int64 {
+	return atomic.LoadInt64(&maxBulkLen)
+}
+
+// SetMaxMultibulkLen sets the max number of the elements of an array from the client, a
+// longer one is a protocol error.
+func SetMaxMultibulkLen(n int64) {
+	atomic.StoreInt64(&maxMultibulkLen, n)
+}
+
+// MaxMultibulkLen returns the max number of the elements of an array from the client.
+func MaxMultibulkLen() int64 {
+	return atomic.LoadInt64(&maxMultibulkLen)
+}
+
+// SetMaxNesting sets the max depth of the arrays in arrays from the client, the top array
+// is depth 1. A deeper one is a protocol error.
+func SetMaxNesting(n int) {
+	atomic.StoreInt64(&maxNesting, int64(n))
+}
+
+// MaxNesting returns the max depth of the arrays in arrays from the client.
+func MaxNesting() int {
+	return int(atomic.LoadInt64(&maxNesting))
+}
+
+// maxLineLen is the max length of a line: the simple string, the error, the integer, the
+// length of a bulk string or an array, and the inline command.
+const maxLineLen = 64 * 1024
+
+// bulkChunk is the size the buffer of a bulk string grows by, so a client can not make the
+// server allocate the length it claims without sending the bytes.
+const bulkChunk = 64 * 1024
+
+// arrayChunk is bulkChunk for the elements of an array.
+const arrayChunk = 1024
+
+// Parse reads a RESP value. A malformed input gets an error of type Error, the reader is
+// not usable after it.
 func Parse(reader *bufio.Reader) (RESPType, Value, error) {
+	return parse(reader, 0)
+}
+
+// ParseCommand reads a command from the client: an array of bulk strings, or an inline
+// command. Any other value in the array, including a nil bulk string, is a protocol error as
+// redis does, so the arguments are always strings.
+func ParseCommand(reader *bufio.Reader) (CommandArgs, error) {
+	prefix, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if prefix != '*' {
+		if err := reader.UnreadByte(); err != nil {
+			return nil, err
+		}
+		return readInlineArgs(reader)
+	}
+
+	n, err := readInt(reader, ErrInvalidMultibulkLength)
+	if err != nil {
+		return nil, err
+	}
+	if n < -1 || n > MaxMultibulkLen() {
+		return nil, ErrInvalidMultibulkLength
+	}
+
+	capacity := n
+	if capacity > arrayChunk {
+		capacity = arrayChunk
+	}
+	if capacity < 0 {
+		capacity = 0
+	}
+	args := make(CommandArgs, 0, capacity)
+	for len(args) < int(n) {
+		prefix, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if prefix != '$' {
+			return nil, NewError(ErrFmtUnexpectedPrefix, prefix)
+		}
+		_, arg, err := parseBulkString(reader)
+		if err != nil {
+			return nil, err
+		}
+		if arg == nil { // $-1, an empty bulk string is read as not nil
+			return nil, ErrInvalidBulkLength
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// parse reads a RESP value in depth arrays.
+func parse(reader *bufio.Reader, depth int) (RESPType, Value, error) {
 	prefix, err := reader.ReadByte()
 	if err != nil {
 		return WrongType, nil, err
@@ -35,8 +166,11 @@ func Parse(reader *bufio.Reader) (RESPType, Value, error) {
 	case '$': // Bulk String
 		return parseBulkString(reader)
 	case '*': // Array
-		return parseArray(reader)
+		return parseArray(reader, depth+1)
 	default: // Inline Command
+		if depth > 0 { // the elements of an array are RESP values only
+			return WrongType, nil, NewError(ErrFmtUnexpectedPrefix, prefix)
+		}
 		if err := reader.UnreadByte(); err != nil {
 			return WrongType, nil, err
 		}
@@ -44,41 +178,72 @@ func Parse(reader *bufio.Reader) (RESPType, Value, error) {
 	}
 }
 
+// readLine reads a line ended with "\r\n" or "\n", which is not included. A line without
+// the end is an io.ErrUnexpectedEOF.
 func readLine(reader *bufio.Reader) ([]byte, error) {
 	line := []byte{}
-	more := true
 
-	for more {
-		buf, isPrefix, err := reader.ReadLine()
+	for {
+		buf, err := reader.ReadSlice('\n')
+		if len(line)+len(buf) > maxLineLen {
+			return nil, ErrLineTooLong
+		}
+		line = append(line, buf...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
 		if err != nil {
 			return nil, err
 		}
-
-		line = append(line, buf...)
-		more = isPrefix
+		break
 	}
 
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
 	return line, nil
 }
 
-func readInt(reader *bufio.Reader) (int64, error) {
+// readInt reads a line of a decimal integer, returns invalid if it is not.
+func readInt(reader *bufio.Reader, invalid error) (int64, error) {
 	line, err := readLine(reader)
 	if err != nil {
 		return 0, err
 	}
 
-	sign := int64(1)
-	if line[0] == '-' {
-		sign = -1
-		line = line[1:]
+	i, ok := parseInt(line)
+	if !ok {
+		return 0, invalid
 	}
+	return i, nil
+}
 
-	i := int64(0)
-	for _, c := range line {
-		i = i*10 + int64(c-'0')
+// parseInt parses the decimal integer strictly: an optional '-' and the digits, no '+', no
+// spaces and no overflow.
+func parseInt(line []byte) (int64, bool) {
+	digits := line
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 {
+		return 0, false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
 	}
 
-	return i * sign, nil
+	i, err := strconv.ParseInt(string(line), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return i, true
 }
 
 func parseSimpleString(reader *bufio.Reader) (RESPType, SimpleString, error) {
@@ -91,7 +256,7 @@ func parseSimpleString(reader *bufio.Reader) (RESPType, SimpleString, error) {
 }
 
 func parseInteger(reader *bufio.Reader) (RESPType, Integer, error) {
-	i, err := readInt(reader)
+	i, err := readInt(reader, ErrInvalidInteger)
 	if err != nil {
 		return IntegerType, Integer(0), err
 	}
@@ -109,7 +274,7 @@ func parseError(reader *bufio.Reader) (RESPType, Error, error) {
 }
 
 func parseBulkString(reader *bufio.Reader) (RESPType, BulkString, error) {
-	i, err := readInt(reader)
+	i, err := readInt(reader, ErrInvalidBulkLength)
 	if err != nil {
 		return BulkStringType, BulkString(nil), err
 	}
@@ -117,18 +282,58 @@ func parseBulkString(reader *bufio.Reader) (RESPType, BulkString, error) {
 	if i == -1 {
 		return BulkStringType, BulkString(nil), nil
 	}
+	if i < 0 || i > MaxBulkLen() {
+		return BulkStringType, BulkString(nil), ErrInvalidBulkLength
+	}
 
-	b, err := reader.Peek(int(i))
+	b, err := readBulk(reader, int(i))
 	if err != nil {
 		return BulkStringType, BulkString(nil), err
 	}
-	reader.Discard(int(i) + 2) // +2 for \r\n
-
 	return BulkStringType, BulkString(b), nil
 }
 
-func parseArray(reader *bufio.Reader) (RESPType, Array, error) {
-	i, err := readInt(reader)
+// readBulk reads n bytes and the trailing \r\n into an owned buffer, which does not refer
+// to the buffer of reader, so n can be larger than it.
+func readBulk(reader *bufio.Reader, n int) ([]byte, error) {
+	size := n + 2 // +2 for \r\n
+	capacity := size
+	if capacity > bulkChunk {
+		capacity = bulkChunk
+	}
+
+	b := make([]byte, 0, capacity)
+	for len(b) < size {
+		if len(b) == cap(b) { // double the buffer, but no more than size
+			grow := cap(b)
+			if grow > size-len(b) {
+				grow = size - len(b)
+			}
+			b = append(b, make([]byte, grow)...)[:len(b)]
+		}
+		end := cap(b) // append may allocate more than asked
+		if end > size {
+			end = size
+		}
+		m, err := io.ReadFull(reader, b[len(b):end])
+		b = b[:len(b)+m]
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b[n] != '\r' || b[n+1] != '\n' {
+		return nil, ErrBulkNoCRLF
+	}
+	return b[:n], nil
+}
+
+func parseArray(reader *bufio.Reader, depth int) (RESPType, Array, error) {
+	if depth > MaxNesting() {
+		return ArrayType, Array(nil), ErrTooDeepNesting
+	}
+
+	i, err := readInt(reader, ErrInvalidMultibulkLength)
 	if err != nil {
 		return ArrayType, Array(nil), err
 	}
@@ -136,36 +341,162 @@ func parseArray(reader *bufio.Reader) (RESPType, Array, error) {
 	if i == -1 {
 		return ArrayType, Array(nil), nil
 	}
+	if i < 0 || i > MaxMultibulkLen() {
+		return ArrayType, Array(nil), ErrInvalidMultibulkLength
+	}
 
-	arr := make(Array, i)
-	for i := range arr {
-		_, v, err := Parse(reader)
+	// grows with the elements read, rather than allocates the length the client claims
+	capacity := i
+	if capacity > arrayChunk {
+		capacity = arrayChunk
+	}
+	arr := make(Array, 0, capacity)
+	for len(arr) < int(i) {
+		_, v, err := parse(reader, depth)
 		if err != nil {
 			return ArrayType, Array(nil), err
 		}
-		arr[i] = v
+		arr = append(arr, v)
 	}
 	return ArrayType, arr, nil
 }
 
+// parseInlineCommand reads a line of the arguments separated by spaces, as typed in telnet
+// or redis-cli.
 func parseInlineCommand(reader *bufio.Reader) (RESPType, Array, error) {
-	line, err := readLine(reader)
+	args, err := readInlineArgs(reader)
 	if err != nil {
 		return ArrayType, Array(nil), err
 	}
 
-	var arr Array
-	start := 0
-	for i := start; i < len(line); i++ {
-		switch line[i] {
-		case ' ':
-			if i != start {
-				arr = append(arr, append(BulkString(nil), line[start:i]...))
-				start = i
+	arr := make(Array, len(args))
+	for i, arg := range args {
+		arr[i] = arg
+	}
+	return ArrayType, arr, nil
+}
+
+// readInlineArgs reads the arguments of an inline command. The blank lines are skipped.
+func readInlineArgs(reader *bufio.Reader) (CommandArgs, error) {
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+// splitArgs splits the line into the arguments as redis does (sdssplitargs). An argument
+// may be quoted: in double quotes, the escapes \xHH, \n, \r, \t, \b, \a are supported and
+// a backslash quotes any other character; in single quotes, only \' is an escape. A closing
+// quote must be followed by a space or the end of the line.
+func splitArgs(line []byte) (CommandArgs, error) {
+	args := CommandArgs{}
+
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		arg := BulkString{}
+		inDouble, inSingle, done := false, false, false
+		for !done {
+			if inDouble {
+				switch {
+				case i == len(line):
+					return nil, ErrUnbalancedQuotes // no closing quote
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					arg = append(arg, hexValue(line[i+2])<<4|hexValue(line[i+3]))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					arg = append(arg, unescape(line[i]))
+				case line[i] == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes // the closing quote must be followed by a space
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else if inSingle {
+				switch {
+				case i == len(line):
+					return nil, ErrUnbalancedQuotes
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg = append(arg, '\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else {
+				switch {
+				case i == len(line) || isSpace(line[i]):
+					done = true
+				case line[i] == '"':
+					inDouble = true
+				case line[i] == '\'':
+					inSingle = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
 			}
-			start++
 		}
+		args = append(args, arg)
 	}
+}
 
-	return ArrayType, arr, nil
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// unescape returns the character of the escape \c in double quotes.
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
 }
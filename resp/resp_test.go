@@ -0,0 +1,206 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		value string // the value written back, if no error
+		err   error
+	}{
+		{"*2\r\n$3\r\nget\r\n$1\r\na\r\n", "*2\r\n$3\r\nget\r\n$1\r\na\r\n", nil},
+		{"*-1\r\n", "*-1\r\n", nil},
+		{"$-1\r\n", "$-1\r\n", nil},
+		{"$0\r\n\r\n", "$0\r\n\r\n", nil},
+		{":-12\r\n", ":-12\r\n", nil},
+		{"+OK\n", "+OK\r\n", nil},
+		{"*1\r\n*1\r\n:1\r\n", "*1\r\n*1\r\n:1\r\n", nil},
+
+		{"*\r\n", "", ErrInvalidMultibulkLength},
+		{"*-2\r\n", "", ErrInvalidMultibulkLength},
+		{"*1x\r\n", "", ErrInvalidMultibulkLength},
+		{"*+1\r\n", "", ErrInvalidMultibulkLength},
+		{"*999999999\r\n", "", ErrInvalidMultibulkLength},
+		{"*99999999999999999999\r\n", "", ErrInvalidMultibulkLength},
+		{"$\r\n", "", ErrInvalidBulkLength},
+		{"$-\r\n", "", ErrInvalidBulkLength},
+		{"$-2\r\n", "", ErrInvalidBulkLength},
+		{"$ 1\r\na\r\n", "", ErrInvalidBulkLength},
+		{"$1073741824\r\n", "", ErrInvalidBulkLength},
+		{"$1\r\nab\r\n", "", ErrBulkNoCRLF},
+		{":1.5\r\n", "", ErrInvalidInteger},
+		{"*1\r\n*1\r\n*1\r\n*1\r\n*1\r\n*1\r\n*1\r\n*1\r\n*1\r\n:1\r\n", "", ErrTooDeepNesting},
+		{"+" + strings.Repeat("a", maxLineLen) + "\r\n", "", ErrLineTooLong},
+
+		{"*1\r\nping\r\n", "", NewError(ErrFmtUnexpectedPrefix, 'p')},
+
+		// inline commands
+		{"PING\r\n", "*1\r\n$4\r\nPING\r\n", nil},
+		{"\r\n  \n set a  b \n", "*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\nb\r\n", nil},
+		{"set \"a b\" 'c d'\r\n", "*3\r\n$3\r\nset\r\n$3\r\na b\r\n$3\r\nc d\r\n", nil},
+		{"set \"\" ''\r\n", "*3\r\n$3\r\nset\r\n$0\r\n\r\n$0\r\n\r\n", nil},
+		{"echo \"\\x41\\x4a\\n\\t\\\"\\\\\\q\"\r\n", "*2\r\n$4\r\necho\r\n$7\r\nAJ\n\t\"\\q\r\n", nil},
+		{"echo \"\\xZZ\"\n", "*2\r\n$4\r\necho\r\n$3\r\nxZZ\r\n", nil},
+		{"echo 'it\\'s \\n'\n", "*2\r\n$4\r\necho\r\n$7\r\nit's \\n\r\n", nil},
+		{"echo a\"b c\"d\n", "", ErrUnbalancedQuotes},
+		{"echo \"a\n", "", ErrUnbalancedQuotes},
+		{"echo 'a\n", "", ErrUnbalancedQuotes},
+		{"echo 'a'b\n", "", ErrUnbalancedQuotes},
+		{"echo a\"b c\" d\n", "*3\r\n$4\r\necho\r\n$4\r\nab c\r\n$1\r\nd\r\n", nil},
+
+		{"", "", io.EOF},
+		{"*2\r\n$3\r\nget\r\n", "", io.EOF},
+		{"*1\r\n$3\r\nge", "", io.ErrUnexpectedEOF},
+		{"*1", "", io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		_, v, err := Parse(bufio.NewReader(strings.NewReader(tt.input)))
+		if err != tt.err {
+			t.Errorf("Parse %q, error: %v, expected: %v", tt.input, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		var b bytes.Buffer
+		v.WriteTo(&b)
+		if b.String() != tt.value {
+			t.Errorf("Parse %q, value: %q, expected: %q", tt.input, b.String(), tt.value)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		input string
+		args  []string // the arguments, if no error
+		err   error
+	}{
+		{"*2\r\n$3\r\nget\r\n$1\r\na\r\n", []string{"get", "a"}, nil},
+		{"*2\r\n$4\r\necho\r\n$0\r\n\r\n", []string{"echo", ""}, nil},
+		{"*0\r\n", []string{}, nil},
+		{"*-1\r\n", []string{}, nil},
+		{"set a  'b c'\r\n", []string{"set", "a", "b c"}, nil},
+
+		// the elements other than the bulk strings
+		{"*2\r\n$4\r\necho\r\n+5\r\n", nil, NewError(ErrFmtUnexpectedPrefix, '+')},
+		{"*2\r\n$4\r\necho\r\n-5\r\n", nil, NewError(ErrFmtUnexpectedPrefix, '-')},
+		{"*2\r\n$4\r\necho\r\n:5\r\n", nil, NewError(ErrFmtUnexpectedPrefix, ':')},
+		{"*2\r\n$4\r\necho\r\n*1\r\n$1\r\na\r\n", nil, NewError(ErrFmtUnexpectedPrefix, '*')},
+		{"*2\r\n$4\r\necho\r\n*-1\r\n", nil, NewError(ErrFmtUnexpectedPrefix, '*')},
+		{"*2\r\n$4\r\necho\r\n$-1\r\n", nil, ErrInvalidBulkLength},
+		{"*1\r\nping\r\n", nil, NewError(ErrFmtUnexpectedPrefix, 'p')},
+		{"*-2\r\n", nil, ErrInvalidMultibulkLength},
+		{"*1\r\n$3\r\nge", nil, io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		args, err := ParseCommand(bufio.NewReader(strings.NewReader(tt.input)))
+		if err != tt.err {
+			t.Errorf("ParseCommand %q, error: %v, expected: %v", tt.input, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		got := []string{}
+		for _, arg := range args {
+			got = append(got, arg.String())
+		}
+		if strings.Join(got, ",") != strings.Join(tt.args, ",") || len(got) != len(tt.args) {
+			t.Errorf("ParseCommand %q, args: %q, expected: %q", tt.input, got, tt.args)
+		}
+	}
+}
+
+// FuzzParse checks Parse never panics, and a parsed value is parsed the same again after
+// it is written back.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"*2\r\n$3\r\nget\r\n$1\r\na\r\n",
+		"*3\r\n$3\r\nset\r\n$1\r\na\r\n$0\r\n\r\n",
+		"*-1\r\n",
+		"$-1\r\n",
+		"+OK\r\n",
+		"-ERR wrong\r\n",
+		":-12\r\n",
+		"*1\r\n*1\r\n:1\r\n",
+		"ping\r\n",
+		"set a  b\n",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		_, v, err := Parse(bufio.NewReader(bytes.NewReader(input)))
+		if err != nil {
+			return
+		}
+
+		var b bytes.Buffer
+		if err := v.WriteTo(&b); err != nil {
+			t.Fatalf("WriteTo %q: %v", input, err)
+		}
+		_, again, err := Parse(bufio.NewReader(bytes.NewReader(b.Bytes())))
+		if err != nil {
+			t.Fatalf("Parse %q written back as %q: %v", input, b.Bytes(), err)
+		}
+
+		var c bytes.Buffer
+		again.WriteTo(&c)
+		if !bytes.Equal(b.Bytes(), c.Bytes()) {
+			t.Fatalf("Parse %q written back as %q, then %q", input, b.Bytes(), c.Bytes())
+		}
+	})
+}
+
+func TestProtocol(t *testing.T) {
+	tests := []struct {
+		value Value
+		resp2 string
+		resp3 string
+	}{
+		{Map{BulkString("a"), Integer(1)}, "*2\r\n$1\r\na\r\n:1\r\n", "%1\r\n$1\r\na\r\n:1\r\n"},
+		{Set{BulkString("a")}, "*1\r\n$1\r\na\r\n", "~1\r\n$1\r\na\r\n"},
+		{Push{BulkString("a")}, "*1\r\n$1\r\na\r\n", ">1\r\n$1\r\na\r\n"},
+		{NullValue, "$-1\r\n", "_\r\n"},
+		{NilBulkString, "$-1\r\n", "_\r\n"},
+		{Array(nil), "*-1\r\n", "_\r\n"},
+		{Double(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{Double(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{Boolean(true), ":1\r\n", "#t\r\n"},
+		{Boolean(false), ":0\r\n", "#f\r\n"},
+		{BigNumber("-12345678901234567890"), "$21\r\n-12345678901234567890\r\n", "(-12345678901234567890\r\n"},
+		{Verbatim{"txt", []byte("a b")}, "$3\r\na b\r\n", "=7\r\ntxt:a b\r\n"},
+		{Attribute{Map{BulkString("ttl"), Integer(1)}, Integer(2)}, ":2\r\n", "|1\r\n$3\r\nttl\r\n:1\r\n:2\r\n"},
+		{Array{Map{}, Array{Double(2), NilBulkString}}, "*2\r\n*0\r\n*2\r\n$1\r\n2\r\n$-1\r\n", "*2\r\n%0\r\n*2\r\n,2\r\n_\r\n"},
+	}
+
+	for _, tt := range tests {
+		for _, p := range []struct {
+			proto    int
+			expected string
+		}{{2, tt.resp2}, {3, tt.resp3}} {
+			var b bytes.Buffer
+			Protocol(tt.value, p.proto).WriteTo(&b)
+			if b.String() != p.expected {
+				t.Errorf("Protocol %#v in RESP%d: %q, expected: %q", tt.value, p.proto, b.String(), p.expected)
+			}
+		}
+	}
+}
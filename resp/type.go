@@ -8,6 +8,8 @@ package resp
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"strconv"
 )
 
 type RESPType int
@@ -30,6 +32,7 @@ type SimpleString string
 
 const OkSimpleString = SimpleString("OK")
 const PongSimpleString = SimpleString("PONG")
+const QueuedSimpleString = SimpleString("QUEUED")
 
 func (s SimpleString) WriteTo(w *bytes.Buffer) error {
 	_, err := fmt.Fprintf(w, "+%s\r\n", s)
@@ -128,14 +131,6 @@ func (a Array) WriteTo(w *bytes.Buffer) error {
 
 type CommandArgs []BulkString
 
-func (a Array) ToArgs() CommandArgs {
-	c := make(CommandArgs, len(a))
-	for i, v := range a {
-		c[i] = v.(BulkString)
-	}
-	return c
-}
-
 func (args CommandArgs) ToBytes() [][]byte {
 	c := make([][]byte, len(args))
 	for i, v := range args {
@@ -143,3 +138,189 @@ func (args CommandArgs) ToBytes() [][]byte {
 	}
 	return c
 }
+
+// RESP3 types, https://github.com/redis/redis-specifications/blob/master/protocol/RESP3.md
+// They are written to a client in RESP3 only, Protocol converts them for a RESP2 client.
+
+// RESP3 Map, the keys and the values are in turn: key1, value1, key2, value2...
+type Map []Value
+
+func (m Map) WriteTo(w *bytes.Buffer) error {
+	return writeAggregate(w, '%', len(m)/2, m)
+}
+
+// RESP3 Set
+type Set []Value
+
+func (s Set) WriteTo(w *bytes.Buffer) error {
+	return writeAggregate(w, '~', len(s), s)
+}
+
+// RESP3 Push, the out of band data, e.g. the messages of pub/sub.
+type Push []Value
+
+func (p Push) WriteTo(w *bytes.Buffer) error {
+	return writeAggregate(w, '>', len(p), p)
+}
+
+func writeAggregate(w *bytes.Buffer, prefix byte, n int, values []Value) error {
+	if _, err := fmt.Fprintf(w, "%c%d\r\n", prefix, n); err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		if err := v.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP3 Null, for both the null bulk string and the null array of RESP2.
+type Null struct{}
+
+var NullValue = Null{}
+
+func (n Null) WriteTo(w *bytes.Buffer) error {
+	_, err := w.WriteString("_\r\n")
+	return err
+}
+
+// RESP3 Double
+type Double float64
+
+func (d Double) WriteTo(w *bytes.Buffer) error {
+	_, err := fmt.Fprintf(w, ",%s\r\n", d)
+	return err
+}
+
+// String formats d as redis does: inf, -inf and nan for the special values, the shortest
+// decimal for the others.
+func (d Double) String() string {
+	f := float64(d)
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// RESP3 Boolean
+type Boolean bool
+
+func (b Boolean) WriteTo(w *bytes.Buffer) error {
+	if b {
+		_, err := w.WriteString("#t\r\n")
+		return err
+	}
+	_, err := w.WriteString("#f\r\n")
+	return err
+}
+
+// RESP3 BigNumber, the decimal digits with an optional '-'.
+type BigNumber string
+
+func (n BigNumber) WriteTo(w *bytes.Buffer) error {
+	_, err := fmt.Fprintf(w, "(%s\r\n", n)
+	return err
+}
+
+// RESP3 Verbatim string, Format is 3 bytes, e.g. "txt" or "mkd".
+type Verbatim struct {
+	Format string
+	Text   []byte
+}
+
+func (v Verbatim) WriteTo(w *bytes.Buffer) error {
+	if _, err := fmt.Fprintf(w, "=%d\r\n%s:", len(v.Format)+1+len(v.Text), v.Format); err != nil {
+		return err
+	}
+	w.Write(v.Text)
+	w.WriteString("\r\n")
+	return nil
+}
+
+// RESP3 Attribute, the auxiliary data of Value which is written after it.
+type Attribute struct {
+	Attrs Map
+	Value Value
+}
+
+func (a Attribute) WriteTo(w *bytes.Buffer) error {
+	if err := writeAggregate(w, '|', len(a.Attrs)/2, a.Attrs); err != nil {
+		return err
+	}
+	return a.Value.WriteTo(w)
+}
+
+// Protocol converts v for a client in the protocol version proto, 2 or 3. For RESP2, the
+// RESP3 types are replaced by their RESP2 equivalents as redis does: Map, Set and Push by
+// Array, Double, BigNumber and Verbatim by BulkString, Boolean by Integer, Null by the null
+// BulkString, and Attribute by its Value. For RESP3, the null BulkString and Array are
+// replaced by Null.
+func Protocol(v Value, proto int) Value {
+	if proto >= 3 {
+		switch t := v.(type) {
+		case BulkString:
+			if t == nil {
+				return NullValue
+			}
+		case Array:
+			if t == nil {
+				return NullValue
+			}
+			return Array(protocolValues(t, proto))
+		case Map:
+			return Map(protocolValues(t, proto))
+		case Set:
+			return Set(protocolValues(t, proto))
+		case Push:
+			return Push(protocolValues(t, proto))
+		case Attribute:
+			return Attribute{Map(protocolValues(t.Attrs, proto)), Protocol(t.Value, proto)}
+		}
+		return v
+	}
+
+	switch t := v.(type) {
+	case Array:
+		if t == nil {
+			return t
+		}
+		return Array(protocolValues(t, proto))
+	case Map:
+		return Array(protocolValues(t, proto))
+	case Set:
+		return Array(protocolValues(t, proto))
+	case Push:
+		return Array(protocolValues(t, proto))
+	case Null:
+		return NilBulkString
+	case Double:
+		return BulkString(t.String())
+	case Boolean:
+		if t {
+			return OneInteger
+		}
+		return ZeroInteger
+	case BigNumber:
+		return BulkString(t)
+	case Verbatim:
+		return BulkString(t.Text)
+	case Attribute:
+		return Protocol(t.Value, proto)
+	}
+	return v
+}
+
+func protocolValues(values []Value, proto int) []Value {
+	converted := make([]Value, len(values))
+	for i, v := range values {
+		converted[i] = Protocol(v, proto)
+	}
+	return converted
+}
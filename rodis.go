@@ -11,16 +11,20 @@ import (
 	"os/signal"
 	"runtime"
 	"syscall"
+	"time"
 
 	"github.com/rod6/log6"
 
+	"github.com/rod6/rodis/command"
 	"github.com/rod6/rodis/config"
 	"github.com/rod6/rodis/net"
+	"github.com/rod6/rodis/resp"
 	"github.com/rod6/rodis/storage"
 )
 
+var configFile = flag.String("c", "rodis.toml", "Rodis config file path")
+
 func main() {
-	configFile := flag.String("c", "rodis.toml", "Rodis config file path")
 	flag.Parse()
 
 	if err := config.LoadConfig(*configFile); err != nil {
@@ -35,6 +39,25 @@ func main() {
 		log6.Fatal("Open storage error: %v", err)
 	}
 	defer storage.CloseStorage()
+	if err := command.LoadFunctions(); err != nil {
+		log6.Fatal("Load functions error: %v", err)
+	}
+	storage.SetKeyEventFunc(command.NotifyKeyEvent)
+	storage.StartExpire(time.Duration(config.Config.ExpireCycleInterval)*time.Millisecond, config.Config.ExpireMaxKeys)
+
+	if config.Config.ProtoMaxBulkLen > 0 {
+		resp.SetMaxBulkLen(config.Config.ProtoMaxBulkLen)
+	}
+	if config.Config.ProtoMaxMultibulkLen > 0 {
+		resp.SetMaxMultibulkLen(config.Config.ProtoMaxMultibulkLen)
+	}
+	if config.Config.ProtoMaxNesting > 0 {
+		resp.SetMaxNesting(config.Config.ProtoMaxNesting)
+	}
+
+	if err := command.SetNotifyKeyspaceEvents(config.Config.NotifyKeyspaceEvents); err != nil {
+		log6.Fatal("Config notifykeyspaceevents error: %v", err)
+	}
 
 	rs, err := net.NewServer(config.Config)
 	if err != nil {
@@ -48,5 +71,34 @@ func main() {
 
 	go rs.Run()
 
-	<-sc
+	for s := range sc {
+		if s != syscall.SIGHUP {
+			return
+		}
+		reload()
+	}
+}
+
+// reload reads the config file again on SIGHUP and applies it to the running server, a wrong
+// file is rejected with the server left as it is.
+func reload() {
+	log6.Info("Reloading config file %v", *configFile)
+
+	c, err := config.Read()
+	if err != nil {
+		log6.Error("Reload config file error, nothing changed: %v", err)
+		return
+	}
+	changes, err := command.ReloadConfig(c)
+	if err != nil {
+		log6.Error("Reload config file error, nothing changed: %v", err)
+		return
+	}
+
+	if len(changes) == 0 {
+		log6.Info("Config reloaded, nothing changed")
+	}
+	for _, change := range changes {
+		log6.Info("Config reloaded, %v", change)
+	}
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// scripting group
+func TestEval(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"eval", "return 1"}, replyType{"Error", "ERR wrong number of arguments for 'eval' command"}},
+		{[]interface{}{"eval", "return 1", "a"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"eval", "return 1", "-1"}, replyType{"Error", "ERR Number of keys can't be negative"}},
+		{[]interface{}{"eval", "return 1", "2", "a"}, replyType{"Error", "ERR Number of keys can't be greater than number of args"}},
+		{[]interface{}{"eval", "return 1 +", "0"}, replyType{"Error", "ERR Error compiling script (new function): user_script at EOF:   syntax error"}},
+		{[]interface{}{"eval", "error('boom')", "0"}, replyType{"Error", "ERR Error running script (call to f_82903a0434f1503e152f89c03c9acd881a0e8150): user_script:1: boom"}},
+
+		// the conversions of the values
+		{[]interface{}{"eval", "return 3.99", "0"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"eval", "return 'foobar'", "0"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"eval", "return true", "0"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"eval", "return false", "0"}, replyType{"BulkString", nil}},
+		{[]interface{}{"eval", "return nil", "0"}, replyType{"BulkString", nil}},
+		{[]interface{}{"eval", "return {1, 'a', {2}, nil, 3}", "0"}, replyType{"Array", []replyType{
+			{"Integer", int64(1)},
+			{"BulkString", []byte("a")},
+			{"Array", integers(2)},
+		}}},
+		{[]interface{}{"eval", "return {KEYS[1], KEYS[2], ARGV[1]}", "2", "a", "b", "c"}, replyType{"Array", bulks("a", "b", "c")}},
+		{[]interface{}{"eval", "return redis.status_reply('FINE')", "0"}, replyType{"SimpleString", "FINE"}},
+		{[]interface{}{"eval", "return redis.error_reply('MY error')", "0"}, replyType{"Error", "MY error"}},
+		{[]interface{}{"eval", "return redis.sha1hex('return 1')", "0"}, replyType{"BulkString", []byte("e0e1f9fabfc9d4800c877a703b823ac0578ff8db")}},
+
+		// redis.call and redis.pcall
+		{[]interface{}{"eval", "return redis.call('set', KEYS[1], ARGV[1])", "1", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"eval", "return redis.call('get', KEYS[1])", "1", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"eval", "return redis.call('get', 'b')", "0"}, replyType{"BulkString", nil}},
+		{[]interface{}{"eval", "redis.call('rpush', 'b', 1, 2.5); return redis.call('lrange', 'b', 0, -1)", "0"}, replyType{"Array", bulks("1", "2.5")}},
+		{[]interface{}{"eval", "return type(redis.call('get', 'c'))", "0"}, replyType{"BulkString", []byte("boolean")}},
+		{[]interface{}{"eval", "return redis.call('incr', 'a')", "0"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"eval", "local r = redis.pcall('incr', 'a'); return r.err", "0"}, replyType{"BulkString", []byte("ERR value is not an integer or out of range")}},
+		{[]interface{}{"eval", "local ok, r = pcall(redis.call, 'lpush', 'a', 1); return {tostring(ok), r.err}", "0"}, replyType{"Array", bulks("false", "WRONGTYPE Operation against a key holding the wrong kind of value")}},
+		{[]interface{}{"eval", "return redis.call('foo')", "0"}, replyType{"Error", "ERR Unknown Redis command called from script"}},
+		{[]interface{}{"eval", "return redis.call('get')", "0"}, replyType{"Error", "ERR wrong number of arguments for 'get' command"}},
+		{[]interface{}{"eval", "return redis.call()", "0"}, replyType{"Error", "ERR Please specify at least one argument for this redis lib call"}},
+		{[]interface{}{"eval", "return redis.call('get', {})", "0"}, replyType{"Error", "ERR Lua redis lib command arguments must be strings or integers"}},
+		{[]interface{}{"eval", "return redis.call('multi')", "0"}, replyType{"Error", "ERR This Redis command is not allowed from script"}},
+		{[]interface{}{"eval", "return redis.call('select', 1)", "0"}, replyType{"Error", "ERR This Redis command is not allowed from script"}},
+		{[]interface{}{"eval", "return redis.call('eval', 'return 1', 0)", "0"}, replyType{"Error", "ERR This Redis command is not allowed from script"}},
+		{[]interface{}{"eval", "return redis.call('blpop', 'c', 0)", "0"}, replyType{"BulkString", nil}},
+		{[]interface{}{"eval", "return dofile", "0"}, replyType{"BulkString", nil}},
+
+		// the writes before an error are kept
+		{[]interface{}{"eval", "redis.call('set', 'c', 'x'); redis.call('incr', 'a')", "0"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"get", "c"}, replyType{"BulkString", []byte("x")}},
+
+		// in a transaction
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "d", "1"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"eval", "return redis.call('incr', 'd')", "0"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{{"SimpleString", "OK"}, {"Integer", int64(2)}}}},
+	}
+	runTest("EVAL", tests, t)
+
+	// the replies to a script are not limited as the requests, the inline ones are not either
+	conn, err := net.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+
+	rawDo("EVAL", conn, reader, "AUTH password\r\nCONFIG SET proto-max-multibulk-len 2\r\n", "+OK\r\n+OK\r\n", t)
+	rawDo("EVAL", conn, reader, "EVAL \"redis.call('rpush', KEYS[1], 1, 2, 3); return #redis.call('lrange', KEYS[1], 0, -1)\" 1 e\r\n", ":3\r\n", t)
+	rawDo("EVAL", conn, reader, "CONFIG SET proto-max-multibulk-len 1048576\r\n", "+OK\r\n", t)
+}
+
+func TestEvalsha(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"evalsha", "4e6d8fc8bb01276962cce5371fa795a7763657ae"}, replyType{"Error", "ERR wrong number of arguments for 'evalsha' command"}},
+		{[]interface{}{"script", "flush"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"evalsha", "4e6d8fc8bb01276962cce5371fa795a7763657ae", "1", "a"}, replyType{"Error", "NOSCRIPT No matching script. Please use EVAL."}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"eval", "return redis.call('get', KEYS[1])", "1", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"evalsha", "4e6d8fc8bb01276962cce5371fa795a7763657ae", "1", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"evalsha", "4E6D8FC8BB01276962CCE5371FA795A7763657AE", "1", "b"}, replyType{"BulkString", nil}},
+	}
+	runTest("EVALSHA", tests, t)
+}
+
+func TestScript(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"script"}, replyType{"Error", "ERR wrong number of arguments for 'script' command"}},
+		{[]interface{}{"script", "foo"}, replyType{"Error", "ERR unknown subcommand 'foo'. Try SCRIPT HELP."}},
+		{[]interface{}{"script", "load"}, replyType{"Error", "ERR wrong number of arguments for 'script|load' command"}},
+		{[]interface{}{"script", "exists"}, replyType{"Error", "ERR wrong number of arguments for 'script|exists' command"}},
+		{[]interface{}{"script", "flush", "foo"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"script", "flush", "sync"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"script", "exists", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"}, replyType{"Array", integers(0)}},
+		{[]interface{}{"script", "load", "return 1"}, replyType{"BulkString", []byte("e0e1f9fabfc9d4800c877a703b823ac0578ff8db")}},
+		{[]interface{}{"script", "load", "return +"}, replyType{"Error", "ERR Error compiling script (new function): user_script line:1(column:8) near '+':   syntax error"}},
+		{[]interface{}{"script", "exists", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", "foo"}, replyType{"Array", integers(1, 0)}},
+		{[]interface{}{"evalsha", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", "0"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"script", "flush"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"script", "exists", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"}, replyType{"Array", integers(0)}},
+		{[]interface{}{"script", "kill"}, replyType{"Error", "NOTBUSY No scripts in execution right now."}},
+	}
+	runTest("SCRIPT", tests, t)
+
+	// a busy script blocks the other commands of the db, but not SCRIPT KILL, and its writes
+	// are discarded
+	busy := redisPool.Get()
+	defer busy.Close()
+	done := make(chan error)
+	go func() {
+		_, err := busy.Do("eval", "redis.call('set', 'a', 'foobar'); while true do end", "0")
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	runSteps("SCRIPT", []rodisTest{
+		{[]interface{}{"script", "kill"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != "ERR Script killed by user with SCRIPT KILL..." {
+			t.Errorf("Error SCRIPT kill, Get: %#v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Error SCRIPT kill, the script is still running")
+	}
+	runSteps("SCRIPT", []rodisTest{
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"script", "kill"}, replyType{"Error", "NOTBUSY No scripts in execution right now."}},
+	}, t)
+}
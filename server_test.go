@@ -0,0 +1,285 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// server group
+func TestDbsize(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"dbsize", "a"}, replyType{"Error", "ERR wrong number of arguments for 'dbsize' command"}},
+		{[]interface{}{"dbsize"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"hset", "b", "b1", "foobar"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"rpush", "c", "c1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"sadd", "d", "d1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zadd", "e", "1", "e1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"dbsize"}, replyType{"Integer", int64(5)}},
+		{[]interface{}{"set", "a", "dongr"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"hset", "b", "b2", "dongr"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"set", "c", "dongr"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"dbsize"}, replyType{"Integer", int64(5)}},
+		{[]interface{}{"del", "a", "f"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"hdel", "b", "b1", "b2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"spop", "d"}, replyType{"BulkString", []byte("d1")}},
+		{[]interface{}{"dbsize"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"flushdb"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"dbsize"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("DBSIZE", tests, t)
+}
+
+// infoFields runs INFO of the sections, returns the fields and the section headers.
+func infoFields(t *testing.T, sections ...interface{}) map[string]string {
+	r, err := redis.String(re.Do("info", sections...))
+	if err != nil {
+		t.Fatalf("Error INFO(%v), Get: %v", sections, err)
+	}
+	fields := map[string]string{}
+	for _, line := range strings.Split(r, "\r\n") {
+		if strings.HasPrefix(line, "# ") {
+			fields[line] = ""
+		} else if i := strings.IndexByte(line, ':'); i > 0 {
+			fields[line[:i]] = line[i+1:]
+		}
+	}
+	return fields
+}
+
+func TestInfo(t *testing.T) {
+	runTest("INFO", []rodisTest{
+		{[]interface{}{"info", "foo"}, replyType{"BulkString", []byte("")}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "b", "foobar", "ex", "100"}, replyType{"SimpleString", "OK"}},
+	}, t)
+
+	fields := infoFields(t)
+	for _, field := range []string{"# Server", "# Clients", "# Stats", "# Keyspace", "redis_version", "process_id", "uptime_in_seconds", "connected_clients", "total_commands_processed"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("Error INFO, %v is missing", field)
+		}
+	}
+	if !regexp.MustCompile(`^\d+\.\d+\.\d+$`).MatchString(fields["redis_version"]) {
+		t.Errorf("Error INFO, redis_version is %q", fields["redis_version"])
+	}
+	for _, field := range []string{"# Commandstats", "# Leveldb"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("Error INFO, %v is not in the default sections", field)
+		}
+	}
+	ttl, err := strconv.Atoi(strings.TrimPrefix(fields["db0"], "keys=2,expires=1,avg_ttl="))
+	if err != nil || ttl < 90000 || ttl > 100000 {
+		t.Errorf("Error INFO keyspace, Get: %v", fields["db0"])
+	}
+
+	// the keyspace is told in a transaction or a script, which holds the lock of its db
+	re.Send("multi")
+	re.Send("info", "keyspace")
+	if r, err := redis.Values(re.Do("exec")); err != nil || len(r) != 1 || !strings.Contains(string(r[0].([]byte)), "db0:keys=2,") {
+		t.Errorf("Error INFO in MULTI, Get: %q, %v", r, err)
+	}
+	c, err := redis.Dial("tcp", ":6379", redis.DialReadTimeout(time.Second), redis.DialPassword("password"), redis.DialDatabase(5))
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c.Close()
+	if r, err := redis.String(c.Do("eval", "return redis.call('info', 'keyspace')", "0")); err != nil || !strings.Contains(r, "db0:keys=2,") {
+		t.Errorf("Error INFO in EVAL, Get: %q, %v", r, err)
+	}
+
+	// a read command counts a hit or a miss for every key it looks up
+	before := infoFields(t, "stats")
+	runSteps("INFO", []rodisTest{
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"mget", "a", "c", "d"}, replyType{"Array", []replyType{{"BulkString", []byte("foobar")}, {"BulkString", nil}, {"BulkString", nil}}}},
+	}, t)
+	after := infoFields(t, "stats", "commandstats", "leveldb")
+	for field, n := range map[string]int{"keyspace_hits": 2, "keyspace_misses": 2, "total_commands_processed": 3} {
+		b, _ := strconv.Atoi(before[field])
+		a, _ := strconv.Atoi(after[field])
+		if a-b != n {
+			t.Errorf("Error INFO %v, Get: %v before, %v after", field, b, a)
+		}
+	}
+	if !strings.HasPrefix(after["cmdstat_mget"], "calls=") {
+		t.Errorf("Error INFO commandstats, Get: %v", after["cmdstat_mget"])
+	}
+	if _, ok := after["db0_cachedblock"]; !ok {
+		t.Errorf("Error INFO leveldb, db0_cachedblock is missing")
+	}
+	if _, ok := after["# Server"]; ok {
+		t.Errorf("Error INFO stats, the server section is not asked for")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"config"}, replyType{"Error", "ERR wrong number of arguments for 'config' command"}},
+		{[]interface{}{"config", "foo"}, replyType{"Error", "ERR unknown subcommand 'foo'. Try CONFIG HELP."}},
+		{[]interface{}{"config", "get"}, replyType{"Error", "ERR wrong number of arguments for 'config|get' command"}},
+		{[]interface{}{"config", "set", "loglevel"}, replyType{"Error", "ERR wrong number of arguments for 'config|set' command"}},
+		{[]interface{}{"config", "resetstat", "foo"}, replyType{"Error", "ERR wrong number of arguments for 'config|resetstat' command"}},
+
+		{[]interface{}{"config", "get", "foo"}, replyType{"Array", []replyType{}}},
+		{[]interface{}{"config", "get", "PROTO-MAX-*", "proto-max-nesting"}, replyType{"Array", bulks("proto-max-bulk-len", "536870912", "proto-max-multibulk-len", "1048576", "proto-max-nesting", "8")}},
+		{[]interface{}{"config", "get", "notify-keyspace-events"}, replyType{"Array", bulks("notify-keyspace-events", "")}},
+
+		// no value is set if one is wrong
+		{[]interface{}{"config", "set", "foo", "1"}, replyType{"Error", "ERR Unknown option or number of arguments for CONFIG SET - 'foo'"}},
+		{[]interface{}{"config", "set", "listen", ":6380"}, replyType{"Error", "ERR CONFIG SET failed (possibly related to argument 'listen') - can't set immutable config"}},
+		{[]interface{}{"config", "set", "proto-max-nesting", "foo"}, replyType{"Error", "ERR CONFIG SET failed (possibly related to argument 'proto-max-nesting') - argument couldn't be parsed into an integer"}},
+		{[]interface{}{"config", "set", "proto-max-nesting", "0"}, replyType{"Error", "ERR CONFIG SET failed (possibly related to argument 'proto-max-nesting') - argument must be between 1 and 2147483647 inclusive"}},
+		{[]interface{}{"config", "set", "proto-max-nesting", "4", "proto-max-nesting", "5"}, replyType{"Error", "ERR CONFIG SET failed (possibly related to argument 'proto-max-nesting') - duplicate parameter"}},
+		{[]interface{}{"config", "set", "proto-max-nesting", "4", "loglevel", "foo"}, replyType{"Error", "ERR CONFIG SET failed (possibly related to argument 'loglevel') - argument(s) must be one of the following: debug, info, warn, error, fatal"}},
+		{[]interface{}{"config", "set", "notify-keyspace-events", "KZ"}, replyType{"Error", "ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - Invalid event class character 'Z'. Use 'Ag$lshzxeKEtmdn'."}},
+		{[]interface{}{"config", "get", "proto-max-nesting"}, replyType{"Array", bulks("proto-max-nesting", "8")}},
+
+		{[]interface{}{"config", "set", "proto-max-nesting", "4", "notify-keyspace-events", "Kg"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"config", "get", "proto-max-nesting", "notify-keyspace-events"}, replyType{"Array", bulks("proto-max-nesting", "4", "notify-keyspace-events", "gK")}},
+		{[]interface{}{"config", "set", "proto-max-nesting", "8", "notify-keyspace-events", ""}, replyType{"SimpleString", "OK"}},
+
+		// the expirers take the new values without waiting for the db locked by EXEC
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"eval", "for i = 1, 1e7 do end", "0"}, replyType{"SimpleString", "QUEUED"}}, // an expirer waits for the lock
+		{[]interface{}{"config", "set", "expire-max-keys", "50", "expire-cycle-interval", "50"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{{"BulkString", nil}, {"SimpleString", "OK"}}}},
+		{[]interface{}{"config", "get", "expire-*"}, replyType{"Array", bulks("expire-cycle-interval", "50", "expire-max-keys", "50")}},
+		{[]interface{}{"config", "set", "expire-max-keys", "200", "expire-cycle-interval", "100"}, replyType{"SimpleString", "OK"}},
+
+		// the values are the ones loaded, so the file is not changed
+		{[]interface{}{"config", "rewrite"}, replyType{"SimpleString", "OK"}},
+	}
+	runTest("CONFIG", tests, t)
+
+	// the password is checked at once on the new connections, the connections authed stay so
+	defer re.Do("config", "set", "requirepass", "password")
+	runSteps("CONFIG", []rodisTest{
+		{[]interface{}{"config", "set", "requirepass", "foobar"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("Error CONFIG, Dial: %v", err)
+	}
+	defer c.Close()
+	for _, step := range []rodisTest{
+		{[]interface{}{"get", "a"}, replyType{"Error", "NOAUTH Authentication required."}},
+		{[]interface{}{"auth", "password"}, replyType{"Error", "ERR invalid password"}},
+		{[]interface{}{"auth", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"config", "set", "requirepass", "password"}, replyType{"SimpleString", "OK"}},
+	} {
+		r, err := c.Do(step.command[0].(string), step.command[1:]...)
+		if err != nil {
+			r = err
+		}
+		if !check(r, step.reply) {
+			t.Errorf("Error CONFIG(%v), Get: %v, Expected: %v", step.command, r, step.reply)
+		}
+	}
+
+	// RESETSTAT counts the stats from zero
+	runSteps("CONFIG", []rodisTest{
+		{[]interface{}{"config", "resetstat"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	fields := infoFields(t, "stats")
+	if fields["total_commands_processed"] != "1" || fields["keyspace_hits"] != "0" {
+		t.Errorf("Error CONFIG RESETSTAT, Get: %v", fields)
+	}
+}
+
+func TestReload(t *testing.T) {
+	pid, err := strconv.Atoi(infoFields(t, "server")["process_id"])
+	if err != nil {
+		t.Fatalf("Error RELOAD, process_id: %v", err)
+	}
+
+	// the values set but not rewritten are back to the ones in the config file
+	runTest("RELOAD", []rodisTest{
+		{[]interface{}{"config", "set", "proto-max-nesting", "4", "expire-max-keys", "100"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+		t.Fatalf("Error RELOAD, SIGHUP: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if r, _ := redis.Strings(re.Do("config", "get", "proto-max-nesting")); len(r) == 2 && r[1] == "8" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the server is still up, with the connection kept
+	runSteps("RELOAD", []rodisTest{
+		{[]interface{}{"config", "get", "proto-max-nesting", "expire-max-keys"}, replyType{"Array", bulks("expire-max-keys", "200", "proto-max-nesting", "8")}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
+	}, t)
+}
+
+func TestTimeout(t *testing.T) {
+	runTest("TIMEOUT", []rodisTest{
+		{[]interface{}{"config", "get", "timeout"}, replyType{"Array", bulks("timeout", "0")}},
+		{[]interface{}{"config", "set", "timeout", "-1"}, replyType{"Error", "ERR CONFIG SET failed (possibly related to argument 'timeout') - argument must be between 0 and 2147483647 inclusive"}},
+		{[]interface{}{"config", "set", "timeout", "1"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	defer re.Do("config", "set", "timeout", "0")
+
+	dial := func() redis.Conn {
+		c, err := redis.Dial("tcp", ":6379", redis.DialReadTimeout(5*time.Second), redis.DialPassword("password"))
+		if err != nil {
+			t.Fatalf("Error TIMEOUT, Dial: %v", err)
+		}
+		return c
+	}
+	idle, blocked := dial(), dial()
+	defer idle.Close()
+	defer blocked.Close()
+
+	// a blocked client is not idle, it times out by the timeout of the command
+	popped := make(chan error)
+	go func() {
+		_, err := blocked.Do("blpop", "x", "1.5")
+		popped <- err
+	}()
+
+	// re is kept busy, while idle is closed after a second
+	for i := 0; i < 20; i++ {
+		re.Do("ping")
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, err := idle.Do("ping"); err == nil {
+		t.Errorf("Error TIMEOUT, the idle connection is not closed")
+	}
+	if err := <-popped; err != nil {
+		t.Errorf("Error TIMEOUT, BLPOP: %v", err)
+	}
+
+	// the timeout is rewritten to the config file, so it is kept by a reload
+	pid, err := strconv.Atoi(infoFields(t, "server")["process_id"])
+	if err != nil {
+		t.Fatalf("Error TIMEOUT, process_id: %v", err)
+	}
+	runSteps("TIMEOUT", []rodisTest{
+		{[]interface{}{"config", "rewrite"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"config", "set", "timeout", "0"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+		t.Fatalf("Error TIMEOUT, SIGHUP: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if r, _ := redis.Strings(re.Do("config", "get", "timeout")); len(r) == 2 && r[1] == "1" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	runSteps("TIMEOUT", []rodisTest{
+		{[]interface{}{"config", "get", "timeout"}, replyType{"Array", bulks("timeout", "1")}},
+		{[]interface{}{"config", "set", "timeout", "0"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"config", "rewrite"}, replyType{"SimpleString", "OK"}},
+	}, t)
+}
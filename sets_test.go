@@ -0,0 +1,253 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSadd(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"sadd", "a"}, replyType{"Error", "ERR wrong number of arguments for 'sadd' command"}},
+		{[]interface{}{"sadd", "a", "a1", "a2", "a1"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"sadd", "a", "a2", "a3"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"type", "a"}, replyType{"SimpleString", "set"}},
+		{[]interface{}{"smembers", "a"}, replyType{"Array", bulks("a1", "a2", "a3")}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"sadd", "b", "b1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+		{[]interface{}{"expire", "a", "100"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"sadd", "a", "a4"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(100)}},
+		{[]interface{}{"scard", "a"}, replyType{"Integer", int64(4)}},
+	}
+	runTest("SADD", tests, t)
+}
+
+func TestScard(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"scard"}, replyType{"Error", "ERR wrong number of arguments for 'scard' command"}},
+		{[]interface{}{"scard", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"sadd", "a", "a1", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"scard", "a"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"srem", "a", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"scard", "a"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"persist", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"expire", "a", "100"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"scard", "a"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"scard", "b"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("SCARD", tests, t)
+}
+
+func TestSdiff(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"sdiff"}, replyType{"Error", "ERR wrong number of arguments for 'sdiff' command"}},
+		{[]interface{}{"sdiff", "a"}, replyType{"Array", bulks()}},
+		{[]interface{}{"sadd", "a", "a", "b", "c", "d"}, replyType{"Integer", int64(4)}},
+		{[]interface{}{"sadd", "b", "c"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"sadd", "c", "a", "e"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"sdiff", "a", "b", "c", "x"}, replyType{"Array", bulks("b", "d")}},
+		{[]interface{}{"set", "s", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"sdiff", "a", "s"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("SDIFF", tests, t)
+}
+
+func TestSdiffstore(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"sdiffstore", "d"}, replyType{"Error", "ERR wrong number of arguments for 'sdiffstore' command"}},
+		{[]interface{}{"sadd", "a", "a", "b", "c"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"sadd", "b", "c"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"set", "d", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"sdiffstore", "d", "a", "b"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"smembers", "d"}, replyType{"Array", bulks("a", "b")}},
+		{[]interface{}{"sdiffstore", "d", "b", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"exists", "d"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("SDIFFSTORE", tests, t)
+}
+
+func TestSinter(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"sinter"}, replyType{"Error", "ERR wrong number of arguments for 'sinter' command"}},
+		{[]interface{}{"sadd", "a", "a", "b", "c", "d"}, replyType{"Integer", int64(4)}},
+		{[]interface{}{"sadd", "b", "c", "d", "e"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"sadd", "c", "a", "c", "d"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"sinter", "a", "b", "c"}, replyType{"Array", bulks("c", "d")}},
+		{[]interface{}{"sinter", "a", "b", "x"}, replyType{"Array", bulks()}},
+		{[]interface{}{"set", "s", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"sinter", "a", "s"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("SINTER", tests, t)
+}
+
+func TestSintercard(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"sintercard", "1"}, replyType{"Error", "ERR wrong number of arguments for 'sintercard' command"}},
+		{[]interface{}{"sintercard", "0", "a"}, replyType{"Error", "ERR numkeys should be greater than 0"}},
+		{[]interface{}{"sintercard", "3", "a", "b"}, replyType{"Error", "ERR Number of keys can't be greater than number of args"}},
+		{[]interface{}{"sintercard", "1", "a", "limit"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"sintercard", "1", "a", "limit", "-1"}, replyType{"Error", "ERR LIMIT can't be negative"}},
+		{[]interface{}{"sadd", "a", "a", "b", "c", "d"}, replyType{"Integer", int64(4)}},
+		{[]interface{}{"sadd", "b", "b", "c", "d", "e"}, replyType{"Integer", int64(4)}},
+		{[]interface{}{"sintercard", "2", "a", "b"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"sintercard", "2", "a", "b", "limit", "2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"sintercard", "2", "a", "b", "limit", "0"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"sintercard", "2", "a", "x"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("SINTERCARD", tests, t)
+}
+
+func TestSinterstore(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"sinterstore", "d"}, replyType{"Error", "ERR wrong number of arguments for 'sinterstore' command"}},
+		{[]interface{}{"sadd", "a", "a", "b", "c"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"sadd", "b", "b", "c", "d"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"sinterstore", "d", "a", "b"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"smembers", "d"}, replyType{"Array", bulks("b", "c")}},
+		{[]interface{}{"sinterstore", "a", "a", "b"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"smembers", "a"}, replyType{"Array", bulks("b", "c")}},
+	}
+	runTest("SINTERSTORE", tests, t)
+}
+
+func TestSismember(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"sismember", "a"}, replyType{"Error", "ERR wrong number of arguments for 'sismember' command"}},
+		{[]interface{}{"sismember", "a", "a1"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"sadd", "a", "a1", "a2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"sismember", "a", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"sismember", "a", "a3"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"sismember", "b", "b1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("SISMEMBER", tests, t)
+}
+
+func TestSmembers(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"smembers"}, replyType{"Error", "ERR wrong number of arguments for 'smembers' command"}},
+		{[]interface{}{"smembers", "a"}, replyType{"Array", bulks()}},
+		{[]interface{}{"sadd", "a", "c", "b", "a"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"smembers", "a"}, replyType{"Array", bulks("a", "b", "c")}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"smembers", "b"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("SMEMBERS", tests, t)
+}
+
+func TestSmismember(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"smismember", "a"}, replyType{"Error", "ERR wrong number of arguments for 'smismember' command"}},
+		{[]interface{}{"smismember", "a", "a1", "a2"}, replyType{"Array", integers(0, 0)}},
+		{[]interface{}{"sadd", "a", "a1", "a3"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"smismember", "a", "a1", "a2", "a3"}, replyType{"Array", integers(1, 0, 1)}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"smismember", "b", "b1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("SMISMEMBER", tests, t)
+}
+
+func TestSmove(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"smove", "a", "b"}, replyType{"Error", "ERR wrong number of arguments for 'smove' command"}},
+		{[]interface{}{"smove", "a", "b", "a1"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"sadd", "a", "a1", "a2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"smove", "a", "b", "a3"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"smove", "a", "b", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"smembers", "a"}, replyType{"Array", bulks("a2")}},
+		{[]interface{}{"smembers", "b"}, replyType{"Array", bulks("a1")}},
+		{[]interface{}{"smove", "a", "a", "a2"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"smove", "a", "b", "a2"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"scard", "b"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"set", "c", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"smove", "b", "c", "a1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+		{[]interface{}{"smove", "c", "b", "a1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("SMOVE", tests, t)
+}
+
+func TestSpop(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"spop", "a", "1", "2"}, replyType{"Error", "ERR wrong number of arguments for 'spop' command"}},
+		{[]interface{}{"spop", "a"}, replyType{"BulkString", nil}},
+		{[]interface{}{"spop", "a", "2"}, replyType{"Array", bulks()}},
+		{[]interface{}{"spop", "a", "-1"}, replyType{"Error", "ERR value is out of range, must be positive"}},
+		{[]interface{}{"sadd", "a", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"spop", "a"}, replyType{"BulkString", []byte("a1")}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"sadd", "a", "a1", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"spop", "a", "0"}, replyType{"Array", bulks()}},
+		{[]interface{}{"scard", "a"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"sadd", "b", "b1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"spop", "b", "5"}, replyType{"Array", bulks("b1")}},
+		{[]interface{}{"exists", "b"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("SPOP", tests, t)
+
+	// The members are popped randomly
+	r, err := re.Do("spop", "a", "2")
+	members, _ := r.([]interface{})
+	if err != nil || len(members) != 2 {
+		t.Errorf("Error SPOP, Get: %#v, %#v", r, err)
+	}
+	runSteps("SPOP", []rodisTest{
+		{[]interface{}{"scard", "a"}, replyType{"Integer", int64(1)}},
+	}, t)
+}
+
+func TestSrandmember(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"srandmember", "a", "1", "2"}, replyType{"Error", "ERR wrong number of arguments for 'srandmember' command"}},
+		{[]interface{}{"srandmember", "a"}, replyType{"BulkString", nil}},
+		{[]interface{}{"srandmember", "a", "2"}, replyType{"Array", bulks()}},
+		{[]interface{}{"srandmember", "a", "x"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"sadd", "a", "a1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"srandmember", "a"}, replyType{"BulkString", []byte("a1")}},
+		{[]interface{}{"srandmember", "a", "3"}, replyType{"Array", bulks("a1")}},
+		{[]interface{}{"srandmember", "a", "-3"}, replyType{"Array", bulks("a1", "a1", "a1")}},
+		{[]interface{}{"scard", "a"}, replyType{"Integer", int64(1)}},
+	}
+	runTest("SRANDMEMBER", tests, t)
+}
+
+func TestSrem(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"srem", "a"}, replyType{"Error", "ERR wrong number of arguments for 'srem' command"}},
+		{[]interface{}{"srem", "a", "a1"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"sadd", "a", "a1", "a2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"srem", "a", "a1", "a1", "a4"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"smembers", "a"}, replyType{"Array", bulks("a2", "a3")}},
+		{[]interface{}{"srem", "a", "a2", "a3"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"srem", "b", "b1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("SREM", tests, t)
+}
+
+func TestSunion(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"sunion"}, replyType{"Error", "ERR wrong number of arguments for 'sunion' command"}},
+		{[]interface{}{"sadd", "a", "a", "b"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"sadd", "b", "b", "c"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"sunion", "a", "b", "x"}, replyType{"Array", bulks("a", "b", "c")}},
+		{[]interface{}{"set", "s", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"sunion", "a", "s"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("SUNION", tests, t)
+}
+
+func TestSunionstore(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"sunionstore", "d"}, replyType{"Error", "ERR wrong number of arguments for 'sunionstore' command"}},
+		{[]interface{}{"sadd", "a", "a", "b"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"sadd", "b", "b", "c"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"rpush", "d", "d1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"sunionstore", "d", "a", "b"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"type", "d"}, replyType{"SimpleString", "set"}},
+		{[]interface{}{"smembers", "d"}, replyType{"Array", bulks("a", "b", "c")}},
+		{[]interface{}{"scard", "d"}, replyType{"Integer", int64(3)}},
+	}
+	runTest("SUNIONSTORE", tests, t)
+}
@@ -16,22 +16,63 @@
 //      first byte: meta data version
 //      second byte: lower 4 bits: RedisType, upper 4 bits: if has expire value
 //      3rd - 18th: the time binary, represent the expire time.
-// Valuekey always has a prefix: '-'. For hash/set data type, use seperator '|' to seperate the
+//      last 8 bytes: the count of the elements, big endian, for hash, list, set and sorted set.
+// Valuekey always has a prefix: '-', followed by the length of rKey (4 bytes big endian, shown
+// as <n> below) and rKey. For hash/set/list data type, the field/member/index follows. As the
+// length goes first, the value keys of different rKeys never overlap, so rKey and the fields
+// are binary safe.
 //
 // String Type:
-//      +StringKey   -> metadata
-//      -StringKey|  -> string value
+//      +StringKey      -> metadata
+//      -<n>StringKey   -> string value
 //
 // Hash Type:
-//      +HashKey        -> metadata
-//      -HashKey|Field1 -> value1
-//      -HashKey|Field2 -> value2
-//      -HashKey|Field3 -> value3
+//      +HashKey            -> metadata, with the count of the fields
+//      -<n>HashKey Field1  -> value1
+//      -<n>HashKey Field2  -> value2
+//      -<n>HashKey Field3  -> value3
 //
 // List Type:
-//      +ListKey            -> metadata
-//      -ListKey|0x0000     -> start, len
-//      -ListKey|0x00000000 -> 0
-//      -ListKey|0x00000001 -> 1
+//      +ListKey                        -> metadata, with the length
+//      -<n>ListKey 0x0000              -> head, tail
+//      -<n>ListKey 0x8000000000000000  -> element 0
+//      -<n>ListKey 0x8000000000000001  -> element 1
+//      The elements are in [head, tail), indexes are 8 bytes big endian. A new list starts from
+//      the middle of the uint64 range, so the push/pop on both ends is O(1): decrease head for
+//      lpush, increase tail for rpush.
+//
+// Set Type:
+//      +SetKey              -> metadata, with the count of the members
+//      -<n>SetKey Member1   -> empty
+//      -<n>SetKey Member2   -> empty
+//
+// Sorted Set Type:
+//      +ZSetKey                            -> metadata, with the count of the members
+//      -<n>ZSetKey 0x01Member1             -> score1
+//      -<n>ZSetKey 0x02<score1>Member1     -> empty
+//      The member entries (0x01) are for the score lookup and the lex range, the index entries
+//      (0x02) are ordered by score then member, for the rank and score range. The score is 8
+//      bytes, the float64 bits with the sign bit flipped (all bits for a negative score), so
+//      the bytes order is the same as the scores order.
+//
+// TTL Index:
+//      Every key with an expire time has an index entry with prefix '@', the expire time is
+//      encoded as 8 bytes big endian unix nano, so the entries are ordered by expire time.
+//      The background expirer walks the index from the start to find the due keys.
+//      @<expire time>StringKey -> nil
+//      @<expire time>HashKey   -> nil
+//
+// System Entries:
+//      Entries with prefix '!' are kept by rodis itself.
+//      !version -> the MetaVersion of the db. A db in an older version is migrated on open:
+//                  version 0 used '-' + rKey + '|' + field as the value key, which is
+//                  ambiguous when rKey contains '|'; version 1 had no count for hash and list.
+//                  The ttl index is rebuilt at the end of the migration, the keys written
+//                  before it have no index entry, and the counters are set.
+//      !keys    -> the number of the keys, 8 bytes big endian. It is updated in the same
+//                  batch as the meta entries created or deleted.
+//      !expires -> the number of the ttl index entries and the sum of their expire times in
+//                  unix milliseconds (wrapping around), 8 bytes big endian each, for the
+//                  average ttl. It is updated in the same batch as the index entries.
 
 package storage
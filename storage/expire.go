@@ -0,0 +1,155 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Active expiration.
+// Lazy expiry (see Has) only removes the keys which are accessed again, the keys nobody
+// reads would stay on disk forever. So every LevelDB runs a background expirer, which
+// walks the ttl index ('@' + expire time + rKey) from the oldest entry, and deletes the
+// due keys, at most maxKeys keys in one cycle to keep the write lock short.
+
+const (
+	DefaultExpireInterval = 100 * time.Millisecond
+	DefaultExpireMaxKeys  = 200
+)
+
+// The parameters of the background expirers, read in every cycle, so they are changed by
+// SetExpireParams without restarting the expirers.
+var (
+	expireInterval = int64(DefaultExpireInterval)
+	expireMaxKeys  = int64(DefaultExpireMaxKeys)
+)
+
+// SetExpireParams sets the interval of the cycles of the background expirers, and the number
+// of the keys deleted at most in a cycle. A zero or negative parameter means to use the
+// default one.
+func SetExpireParams(interval time.Duration, maxKeys int) {
+	if interval <= 0 {
+		interval = DefaultExpireInterval
+	}
+	if maxKeys <= 0 {
+		maxKeys = DefaultExpireMaxKeys
+	}
+	atomic.StoreInt64(&expireInterval, int64(interval))
+	atomic.StoreInt64(&expireMaxKeys, int64(maxKeys))
+}
+
+func expireParams() (time.Duration, int) {
+	return time.Duration(atomic.LoadInt64(&expireInterval)), int(atomic.LoadInt64(&expireMaxKeys))
+}
+
+// StartExpire starts the background expirer of ldb, with the parameters set by
+// SetExpireParams.
+func (ldb *LevelDB) StartExpire() {
+	ldb.expireQuit = make(chan struct{})
+	ldb.expireDone = make(chan struct{})
+	go ldb.expireLoop()
+}
+
+// StopExpire stops the background expirer and waits for it to exit.
+func (ldb *LevelDB) StopExpire() {
+	if ldb.expireQuit == nil {
+		return
+	}
+	close(ldb.expireQuit)
+	<-ldb.expireDone
+	ldb.expireQuit = nil
+}
+
+func (ldb *LevelDB) expireLoop() {
+	defer close(ldb.expireDone)
+
+	interval, _ := expireParams()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ldb.expireQuit:
+			return
+		case <-ticker.C:
+		}
+
+		// a new interval takes effect from the next cycle
+		next, maxKeys := expireParams()
+		if next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+
+		// A full cycle means more keys may be due, run again without waiting,
+		// but give the other goroutines the chance to get the lock in between.
+		for ldb.expireCycle(maxKeys) == maxKeys {
+			select {
+			case <-ldb.expireQuit:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// expireCycle deletes at most maxKeys due keys, returns the number of the ttl index
+// entries it handled.
+func (ldb *LevelDB) expireCycle(maxKeys int) int {
+	ldb.Lock()
+	defer ldb.Unlock()
+
+	now := time.Now()
+	ttlKeys := [][]byte{}
+
+	iter := ldb.newIterator(util.BytesPrefix([]byte{TTLPrefix}))
+	for iter.Next() && len(ttlKeys) < maxKeys {
+		_, expireAt, err := parseTTLKey(iter.Key())
+		if err == nil && expireAt.After(now) {
+			break // the index is ordered by expire time, no more due keys
+		}
+		ttlKeys = append(ttlKeys, append([]byte{}, iter.Key()...))
+	}
+	iter.Release()
+
+	for _, ttlKey := range ttlKeys {
+		key, expireAt, err := parseTTLKey(ttlKey)
+		if err != nil {
+			ldb.delete([][]byte{ttlKey})
+			continue
+		}
+
+		exists, tipe, keyExpireAt := ldb.has(encodeMetaKey(key))
+		if exists && keyExpireAt != nil && keyExpireAt.Equal(expireAt) {
+			ldb.deleteKey(key, tipe) // deleteKey removes the index entry as well
+			keyEvent(ldb, "expired", key)
+		} else {
+			ldb.delete([][]byte{ttlKey}) // stale index entry
+		}
+	}
+	return len(ttlKeys)
+}
+
+// Expires returns the number of the keys with an expire time, by the ttl index, and their
+// average time to live, from the counters, so it is cheap.
+func (ldb *LevelDB) Expires() (int, time.Duration) {
+	ldb.wm.Lock()
+	defer ldb.wm.Unlock()
+
+	if ldb.expires == 0 {
+		return 0, 0
+	}
+	// the sum wraps around, the one of the ttls does not
+	total := int64(ldb.expireSum - uint64(ldb.expires)*uint64(time.Now().UnixMilli()))
+	avg := time.Duration(total/int64(ldb.expires)) * time.Millisecond
+	if avg < 0 { // the expired keys not deleted yet
+		avg = 0
+	}
+	return ldb.expires, avg
+}
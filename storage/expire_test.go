@@ -0,0 +1,117 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestExpireCycle(t *testing.T) {
+	ldb := openTest(t)
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+	for i := 0; i < 5; i++ {
+		ldb.PutString([]byte(strconv.Itoa(i)), []byte("foobar"), &past)
+	}
+	ldb.PutHash([]byte("h"), map[string][]byte{"f": []byte("v")}, &past)
+	ldb.PutString([]byte("later"), []byte("foobar"), &future)
+	ldb.PutString([]byte("never"), []byte("foobar"), nil)
+	stale := new(leveldb.Batch)
+	stale.Put(encodeTTLKey([]byte("gone"), past), nil) // the key does not exist
+	ldb.write(stale)
+	events := keyEvents(t)
+
+	// the due entries are handled by the expire time, at most maxKeys in a cycle
+	if n := ldb.expireCycle(4); n != 4 {
+		t.Errorf("Error expireCycle, Get: %v", n)
+	}
+	if n := ldb.expireCycle(4); n != 3 {
+		t.Errorf("Error expireCycle, Get: %v", n)
+	}
+	if n := ldb.expireCycle(4); n != 0 {
+		t.Errorf("Error expireCycle, Get: %v", n)
+	}
+
+	if got := events(); len(got) != 6 {
+		t.Errorf("Error expired events, Get: %v", got)
+	}
+	if n := ldb.KeyCount(); n != 2 {
+		t.Errorf("Error KeyCount, Get: %v", n)
+	}
+	if n, _ := ldb.Expires(); n != 1 {
+		t.Errorf("Error Expires, Get: %v", n)
+	}
+	for _, key := range []string{"later", "never"} {
+		if exists, _, _ := ldb.Has([]byte(key)); !exists {
+			t.Errorf("Error %v is expired", key)
+		}
+	}
+}
+
+func TestStartExpire(t *testing.T) {
+	ldb := openTest(t)
+	expireAt := time.Now().Add(50 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		ldb.PutString([]byte(strconv.Itoa(i)), []byte("foobar"), &expireAt)
+	}
+
+	SetExpireParams(10*time.Millisecond, 3)
+	t.Cleanup(func() { SetExpireParams(0, 0) })
+	ldb.StartExpire()
+	deadline := time.Now().Add(2 * time.Second)
+	for ldb.KeyCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := ldb.KeyCount(); n != 0 {
+		t.Errorf("Error background expirer, %v keys left", n)
+	}
+}
+
+func TestExpires(t *testing.T) {
+	dir := t.TempDir()
+	ldb, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	check := func(ldb *LevelDB, n int, avg time.Duration) {
+		t.Helper()
+		if got, gotAvg := ldb.Expires(); got != n || gotAvg < avg-time.Second || gotAvg > avg {
+			t.Errorf("Error Expires, Get: %v %v, expected: %v %v", got, gotAvg, n, avg)
+		}
+	}
+
+	hour, hours := time.Now().Add(time.Hour), time.Now().Add(3*time.Hour)
+	ldb.PutString([]byte("a"), []byte("foobar"), &hour)
+	ldb.PutHash([]byte("h"), map[string][]byte{"f": []byte("v")}, &hours)
+	ldb.PutString([]byte("never"), []byte("foobar"), nil)
+	check(ldb, 2, 2*time.Hour)
+	ldb.SetExpire([]byte("a"), &hours)
+	check(ldb, 2, 3*time.Hour)
+	ldb.SetExpire([]byte("h"), nil)
+	check(ldb, 1, 3*time.Hour)
+
+	view := ldb.Begin()
+	view.PutString([]byte("b"), []byte("foobar"), &hour)
+	check(view, 2, 2*time.Hour)
+	view.Commit()
+	check(ldb, 2, 2*time.Hour)
+
+	// the counters are kept in the db
+	ldb.Close()
+	ldb, err = Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer ldb.Close()
+	check(ldb, 2, 2*time.Hour)
+	if err := ldb.Flush(); err != nil {
+		t.Fatalf("Flush error: %v", err)
+	}
+	check(ldb, 0, 0)
+}
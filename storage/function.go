@@ -0,0 +1,58 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// The function libraries are kept in db 0, as FunctionPrefix + name => code. They belong to
+// no db, so they are not keys, and are left by Flush.
+
+func encodeFunctionKey(name string) []byte {
+	return append([]byte{FunctionPrefix}, name...)
+}
+
+// FunctionLibraries returns the code of the function libraries saved, by their names.
+func FunctionLibraries() (map[string][]byte, error) {
+	ldb := storage[0]
+	libs := make(map[string][]byte)
+	iter := ldb.db.NewIterator(util.BytesPrefix([]byte{FunctionPrefix}), nil)
+	for iter.Next() {
+		libs[string(iter.Key()[1:])] = append([]byte{}, iter.Value()...)
+	}
+	iter.Release()
+	return libs, iter.Error()
+}
+
+// SaveFunctionLibraries saves the libraries in one batch, a nil code deletes the library.
+// If flush is true, all the libraries saved before are deleted first.
+func SaveFunctionLibraries(libs map[string][]byte, flush bool) error {
+	ldb := storage[0]
+	ldb.wm.Lock()
+	defer ldb.wm.Unlock()
+
+	batch := new(leveldb.Batch)
+	if flush {
+		iter := ldb.db.NewIterator(util.BytesPrefix([]byte{FunctionPrefix}), nil)
+		for iter.Next() {
+			batch.Delete(append([]byte{}, iter.Key()...))
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	for name, code := range libs {
+		if code == nil {
+			batch.Delete(encodeFunctionKey(name))
+		} else {
+			batch.Put(encodeFunctionKey(name), code)
+		}
+	}
+	return ldb.db.Write(batch, nil)
+}
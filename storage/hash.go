@@ -1,55 +1,66 @@
 package storage
 
 import (
-	"strings"
 	"time"
-	
+
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
-
 func (ldb *LevelDB) DeleteHash(key []byte) {
-	keys := [][]byte{encodeMetaKey(key)}
+	batch := new(leveldb.Batch)
+	ldb.deleteMeta(batch, key)
 
 	// enum fields, and delete all
 	hashPrefix := encodeHashFieldKey(key, nil)
-	iter := ldb.db.NewIterator(util.BytesPrefix(hashPrefix), nil)
+	iter := ldb.newIterator(util.BytesPrefix(hashPrefix))
 	for iter.Next() {
-		key := append([]byte{}, iter.Key()...)
-		keys = append(keys, key)
+		batch.Delete(iter.Key())
 	}
 	iter.Release()
-	ldb.delete(keys)
+	ldb.write(batch)
 }
 
-func (ldb *LevelDB) DeleteHashFields(key []byte, fields [][]byte) {
-	// Delete fields
-	keys := make([][]byte, len(fields))
-	for i, field := range fields {
-		keys[i] = encodeHashFieldKey(key, field)
+// DeleteHashFields deletes the fields of the hash, and the hash itself if no field is left.
+// It returns the number of the fields deleted.
+func (ldb *LevelDB) DeleteHashFields(key []byte, fields [][]byte) int {
+	batch := new(leveldb.Batch)
+	deleted := make(map[string]bool)
+	for _, field := range fields {
+		fieldKey := encodeHashFieldKey(key, field)
+		if deleted[string(field)] || !ldb.hasEntry(fieldKey) {
+			continue
+		}
+		deleted[string(field)] = true
+		batch.Delete(fieldKey)
+	}
+	if len(deleted) == 0 {
+		return 0
 	}
-	ldb.delete(keys)
 
-	// After delete, remove the hash meta entry if no fields in this hash
-	hashPrefix := encodeHashFieldKey(key, nil)
-	iter := ldb.db.NewIterator(util.BytesPrefix(hashPrefix), nil)
-	if !iter.Next() {
-		ldb.delete([][]byte{encodeMetaKey(key)}) // No field, delete the hash
+	count := ldb.count(key) - len(deleted)
+	if count <= 0 {
+		ldb.deleteMeta(batch, key) // No field, delete the hash
+	} else {
+		ldb.putCount(batch, key, Hash, count)
 	}
-	iter.Release()
+	ldb.write(batch)
+	return len(deleted)
+}
+
+// HashLength returns the number of the fields in the hash.
+func (ldb *LevelDB) HashLength(key []byte) int {
+	return ldb.count(key)
 }
 
 func (ldb *LevelDB) GetHash(key []byte) map[string][]byte {
 	hash := make(map[string][]byte)
 
 	hashPrefix := encodeHashFieldKey(key, nil)
-	iter := ldb.db.NewIterator(util.BytesPrefix(hashPrefix), nil)
+	iter := ldb.newIterator(util.BytesPrefix(hashPrefix))
 	for iter.Next() {
-		// Find the seperator '|'
-		sepIndex := strings.IndexByte(string(iter.Key()), '|')
-		// The field name should be the string after '|'
-		key := append([]byte{}, iter.Key()[sepIndex+1:]...)
+		// The field name is the rest after the prefix
+		key := append([]byte{}, iter.Key()[len(hashPrefix):]...)
 		value := append([]byte{}, iter.Value()...)
 		hash[string(key)] = value
 	}
@@ -61,12 +72,10 @@ func (ldb *LevelDB) GetHashFieldNames(key []byte) [][]byte {
 	fields := [][]byte{}
 
 	hashPrefix := encodeHashFieldKey(key, nil)
-	iter := ldb.db.NewIterator(util.BytesPrefix(hashPrefix), nil)
+	iter := ldb.newIterator(util.BytesPrefix(hashPrefix))
 	for iter.Next() {
-		// Find the seperator '|'
-		sepIndex := strings.IndexByte(string(iter.Key()), '|')
-		// The field name should be the string after '|'
-		key := append([]byte{}, iter.Key()[sepIndex+1:]...)
+		// The field name is the rest after the prefix
+		key := append([]byte{}, iter.Key()[len(hashPrefix):]...)
 		fields = append(fields, key)
 	}
 	iter.Release()
@@ -83,15 +92,15 @@ func (ldb *LevelDB) GetHashFields(key []byte, fields [][]byte) map[string][]byte
 }
 
 func (ldb *LevelDB) PutHash(key []byte, hash map[string][]byte, expireAt *time.Time) {
-	metaKey := encodeMetaKey(key)
-
 	batch := new(leveldb.Batch)
-	batch.Put(metaKey, encodeMetadata(Hash, expireAt))
+	count := ldb.count(key)
 	for k, v := range hash {
 		fieldKey := encodeHashFieldKey(key, []byte(k))
+		if !ldb.hasEntry(fieldKey) {
+			count++
+		}
 		batch.Put(fieldKey, v)
 	}
-	if err := ldb.db.Write(batch, nil); err != nil {
-		panic(err)
-	}
+	ldb.putCountMeta(batch, key, Hash, expireAt, count)
+	ldb.write(batch)
 }
@@ -6,17 +6,45 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
-	"time"
 	"sync"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type LevelDB struct {
-	db  *leveldb.DB
-	rwm *sync.RWMutex
+	db    *leveldb.DB
+	rwm   rwLocker
+	txn   *txn // not nil for the view of a transaction, see Begin
+	index int  // the index of the db, see SelectStorage
+
+	wm sync.Mutex // serializes the writes, so the counters are in step with the db
+	em sync.Mutex // serializes the lazy expiry of the readers, see Has
+	counters
+
+	watches map[string]map[*Watch]bool // the watches of each key, guarded by wm
+
+	expireQuit chan struct{} // closed to stop the background expirer
+	expireDone chan struct{} // closed when the background expirer exits
+}
+
+// counters are the counts of a db, kept in the system entries as well and updated in the same
+// batch as the entries counted.
+type counters struct {
+	keys      int    // the number of the keys, in keysKey
+	expires   int    // the number of the ttl index entries, in expiresKey
+	expireSum uint64 // the sum of the expire times of the entries in unix milliseconds, in expiresKey
+}
+
+type rwLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
 }
 
 const STRBYTE byte = 0x00
@@ -32,16 +60,65 @@ func Open(dbPath string, options *opt.Options) (*LevelDB, error) {
 
 	var rwmutex sync.RWMutex
 
-	return &LevelDB{db: db, rwm: &rwmutex}, nil
+	ldb := &LevelDB{db: db, rwm: &rwmutex}
+	if err := ldb.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	ldb.keys = parseCount(ldb.get(keysKey))
+	ldb.expires, ldb.expireSum = parseExpires(ldb.get(expiresKey))
+	return ldb, nil
 }
 
+// Has checks the key, returns if the key exists, and its type and expire time.
+// An expired key is treated as absent and is deleted on the way out (lazy expiry),
+// so every command looking up a key through Has never sees a stale value.
+// Has may be called while holding only the read lock: no writer can run concurrently with
+// the readers, and the readers deleting the same expired key are serialized by expire, so
+// the key is told expired only once.
 func (ldb *LevelDB) Has(key []byte) (bool, byte, *time.Time) {
 	metaKey := encodeMetaKey(key)
-	return ldb.has(metaKey)
+	exists, tipe, expireAt := ldb.has(metaKey)
+	if exists && isExpired(expireAt) {
+		if ldb.expire(key, tipe, *expireAt) {
+			key := append([]byte{}, key...)
+			ldb.Defer(func() { keyEvent(ldb, "expired", key) })
+		}
+		return false, None, nil
+	}
+	return exists, tipe, expireAt
+}
+
+// expire deletes the expired key, returns false if another reader has deleted it already.
+func (ldb *LevelDB) expire(key []byte, tipe byte, expireAt time.Time) bool {
+	ldb.em.Lock()
+	defer ldb.em.Unlock()
+
+	exists, _, at := ldb.has(encodeMetaKey(key))
+	if !exists || at == nil || !at.Equal(expireAt) {
+		return false
+	}
+	ldb.deleteKey(key, tipe)
+	return true
+}
+
+// Index returns the index of the db.
+func (ldb *LevelDB) Index() int {
+	return ldb.index
+}
+
+// Delete removes the key whatever its type is, returns false if the key does not exist.
+func (ldb *LevelDB) Delete(key []byte) bool {
+	exists, tipe, _ := ldb.Has(key)
+	if !exists {
+		return false
+	}
+	ldb.deleteKey(key, tipe)
+	return true
 }
 
 func (ldb *LevelDB) has(metaKey []byte) (bool, byte, *time.Time) {
-	metadata, err := ldb.db.Get(metaKey, nil)
+	metadata, err := ldb.read(metaKey)
 
 	if err != nil && err != leveldb.ErrNotFound {
 		panic(err)
@@ -51,42 +128,312 @@ func (ldb *LevelDB) has(metaKey []byte) (bool, byte, *time.Time) {
 		return false, None, nil
 	}
 
-	tipe, expireAt, err := parseMetadata(metadata)
+	tipe, expireAt, _, err := parseMetadata(metadata)
 	if err != nil {
 		panic(err)
 	}
 	return true, tipe, expireAt
 }
 
+// count returns the count of the elements kept in the metadata of key, see hasCount.
+func (ldb *LevelDB) count(key []byte) int {
+	metadata := ldb.get(encodeMetaKey(key))
+	if metadata == nil {
+		return 0
+	}
+
+	_, _, count, err := parseMetadata(metadata)
+	if err != nil {
+		panic(err)
+	}
+	return count
+}
+
+// SetExpire rewrites the metadata of key with a new expire time, nil to persist the key.
+// Only the meta entry (and the ttl index) is written, the value entries are untouched.
+func (ldb *LevelDB) SetExpire(key []byte, expireAt *time.Time) bool {
+	exists, tipe, _ := ldb.Has(key)
+	if !exists {
+		return false
+	}
+
+	batch := new(leveldb.Batch)
+	ldb.putCountMeta(batch, key, tipe, expireAt, ldb.count(key))
+	ldb.write(batch)
+	return true
+}
+
+// deleteKey removes the meta entry and all value entries of the key with type tipe.
+func (ldb *LevelDB) deleteKey(key []byte, tipe byte) {
+	switch tipe {
+	case String:
+		ldb.DeleteString(key)
+	case Hash:
+		ldb.DeleteHash(key)
+	case List:
+		ldb.DeleteList(key)
+	case Set:
+		ldb.DeleteSet(key)
+	case SortedSet:
+		ldb.DeleteZSet(key)
+	}
+}
+
+func isExpired(expireAt *time.Time) bool {
+	return expireAt != nil && !expireAt.IsZero() && !expireAt.After(time.Now())
+}
+
+// putMeta puts the metadata of key into batch, and keeps the ttl index in step with it.
+func (ldb *LevelDB) putMeta(batch *leveldb.Batch, key []byte, tipe byte, expireAt *time.Time) {
+	ldb.putCountMeta(batch, key, tipe, expireAt, 0)
+}
+
+// putCountMeta is putMeta for the types which keep the count of the elements in metadata.
+func (ldb *LevelDB) putCountMeta(batch *leveldb.Batch, key []byte, tipe byte, expireAt *time.Time, count int) {
+	ldb.unindexTTL(batch, key)
+	batch.Put(encodeMetaKey(key), encodeMetadata(tipe, expireAt, count))
+	if expireAt != nil && !expireAt.IsZero() {
+		batch.Put(encodeTTLKey(key, *expireAt), nil)
+	}
+}
+
+// putCount rewrites the count of the elements in the metadata of the existing key.
+func (ldb *LevelDB) putCount(batch *leveldb.Batch, key []byte, tipe byte, count int) {
+	_, _, expireAt := ldb.has(encodeMetaKey(key))
+	ldb.putCountMeta(batch, key, tipe, expireAt, count)
+}
+
+// deleteMeta deletes the metadata of key and its ttl index entry in batch.
+func (ldb *LevelDB) deleteMeta(batch *leveldb.Batch, key []byte) {
+	ldb.unindexTTL(batch, key)
+	batch.Delete(encodeMetaKey(key))
+}
+
+func (ldb *LevelDB) unindexTTL(batch *leveldb.Batch, key []byte) {
+	exists, _, expireAt := ldb.has(encodeMetaKey(key))
+	if exists && expireAt != nil {
+		batch.Delete(encodeTTLKey(key, *expireAt))
+	}
+}
+
+// KeyCount returns the number of the keys, including the expired ones not deleted yet.
+func (ldb *LevelDB) KeyCount() int {
+	ldb.wm.Lock()
+	defer ldb.wm.Unlock()
+	return ldb.keys
+}
+
+// Property returns the property of the LevelDB engine, e.g. "leveldb.stats".
+func (ldb *LevelDB) Property(name string) (string, error) {
+	return ldb.db.GetProperty(name)
+}
+
+// write writes batch, with the counters updated in the same batch by the meta entries and the
+// ttl index entries created or deleted in it.
+func (ldb *LevelDB) write(batch *leveldb.Batch) {
+	if ldb.txn != nil {
+		ldb.writeTxn(batch)
+		return
+	}
+
+	ldb.wm.Lock()
+	defer ldb.wm.Unlock()
+
+	c, created := ldb.countBatch(batch)
+	ldb.putCounters(batch, c)
+
+	if err := ldb.db.Write(batch, nil); err != nil {
+		panic(err)
+	}
+	ldb.counters = c
+	ldb.touch(batch)
+	ldb.keysCreated(created)
+}
+
+// writeTxn is write for the view of a transaction, batch goes into the transaction.
+func (ldb *LevelDB) writeTxn(batch *leveldb.Batch) {
+	c, created := ldb.countBatch(batch)
+	ldb.putCounters(batch, c)
+
+	if err := batch.Replay(ldb.txn); err != nil {
+		panic(err)
+	}
+	ldb.counters = c
+	ldb.keysCreated(created)
+}
+
+// countBatch returns the counters after batch, and the meta keys created by it.
+func (ldb *LevelDB) countBatch(batch *leveldb.Batch) (counters, []string) {
+	entries := countReplay{}
+	if err := batch.Replay(entries); err != nil {
+		panic(err)
+	}
+
+	c := ldb.counters
+	created := []string{}
+	for key, exists := range entries {
+		if exists == ldb.hasEntry([]byte(key)) {
+			continue
+		}
+		delta := 1
+		if !exists {
+			delta = -1
+		}
+		if key[0] == TTLPrefix {
+			_, expireAt, err := parseTTLKey([]byte(key))
+			if err != nil {
+				panic(err)
+			}
+			c.expires += delta
+			c.expireSum += uint64(delta) * uint64(expireAt.UnixMilli()) // wraps around
+			continue
+		}
+		c.keys += delta
+		if exists {
+			created = append(created, key)
+		}
+	}
+	return c, created
+}
+
+// putCounters puts the counters changed from the ones of ldb into batch.
+func (ldb *LevelDB) putCounters(batch *leveldb.Batch, c counters) {
+	if c.keys != ldb.keys {
+		batch.Put(keysKey, encodeCount(c.keys))
+	}
+	if c.expires != ldb.expires || c.expireSum != ldb.expireSum {
+		batch.Put(expiresKey, encodeExpires(c.expires, c.expireSum))
+	}
+}
+
+// keysCreated tells the keys created by the meta keys, when they are committed for a view.
+func (ldb *LevelDB) keysCreated(metaKeys []string) {
+	ldb.Defer(func() {
+		for _, metaKey := range metaKeys {
+			keyEvent(ldb, "new", []byte(metaKey[1:]))
+		}
+	})
+}
+
+// countReplay collects if each meta key and ttl index entry in a batch exists after the batch.
+type countReplay map[string]bool
+
+func (r countReplay) Put(key, value []byte) {
+	if len(key) > 0 && (key[0] == MetaPrefix || key[0] == TTLPrefix) {
+		r[string(key)] = true
+	}
+}
+
+func (r countReplay) Delete(key []byte) {
+	if len(key) > 0 && (key[0] == MetaPrefix || key[0] == TTLPrefix) {
+		r[string(key)] = false
+	}
+}
+
 func (ldb *LevelDB) delete(keys [][]byte) {
 	batch := new(leveldb.Batch)
 	for _, key := range keys {
 		batch.Delete(key)
 	}
-	if err := ldb.db.Write(batch, nil); err != nil && err != leveldb.ErrNotFound {
-		panic(err)
-	}
+	ldb.write(batch)
 }
 
 func (ldb *LevelDB) get(key []byte) []byte {
-	value, err := ldb.db.Get(key, nil)
+	value, err := ldb.read(key)
 	if err != nil && err != ErrNotFound {
 		panic(err)
 	}
 	return value
 }
 
+// hasEntry checks the leveldb key, for the entries whose value may be empty.
+func (ldb *LevelDB) hasEntry(key []byte) bool {
+	_, err := ldb.read(key)
+	if err != nil && err != ErrNotFound {
+		panic(err)
+	}
+	return err == nil
+}
+
+// read gets the leveldb key, through the writes of the transaction for its view.
+func (ldb *LevelDB) read(key []byte) ([]byte, error) {
+	if ldb.txn != nil {
+		if value, found, err := ldb.txn.get(key); found {
+			return value, err
+		}
+	}
+	return ldb.db.Get(key, nil)
+}
+
+// newIterator iterates the leveldb keys in r, through the writes of the transaction for its
+// view.
+func (ldb *LevelDB) newIterator(r *util.Range) iterator.Iterator {
+	if ldb.txn != nil {
+		return newTxnIterator(ldb.db.NewIterator(r, nil), ldb.txn.overlay.NewIterator(r))
+	}
+	return ldb.db.NewIterator(r, nil)
+}
+
+// Flush deletes all the keys, the db keeps its version and the function libraries. The keys
+// are deleted in one batch with the counters reset, so a crash never leaves the counters out
+// of step with the db.
 func (ldb *LevelDB) Flush() error {
+	if ldb.txn != nil {
+		return ldb.flushTxn()
+	}
+
+	ldb.wm.Lock()
+	defer ldb.wm.Unlock()
+
+	batch := new(leveldb.Batch)
 	iter := ldb.db.NewIterator(nil, nil)
 	for iter.Next() {
-		key := iter.Key()
-		ldb.db.Delete(key, nil)
+		if flushed(iter.Key()) {
+			batch.Delete(iter.Key())
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	batch.Put(keysKey, encodeCount(0))
+	batch.Put(expiresKey, encodeExpires(0, 0))
+
+	ldb.touchAll()
+	if err := ldb.db.Write(batch, nil); err != nil {
+		return err
+	}
+	ldb.counters = counters{}
+	return nil
+}
+
+// flushTxn is Flush for the view of a transaction, the deletes go into the transaction.
+func (ldb *LevelDB) flushTxn() error {
+	batch := new(leveldb.Batch)
+	iter := ldb.newIterator(nil)
+	for iter.Next() {
+		if flushed(iter.Key()) {
+			batch.Delete(iter.Key())
+		}
 	}
 	iter.Release()
-	return iter.Error()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	ldb.counters = counters{}
+	batch.Put(keysKey, encodeCount(0))
+	batch.Put(expiresKey, encodeExpires(0, 0))
+	return batch.Replay(ldb.txn)
+}
+
+// flushed reports if the entry is deleted by Flush.
+func flushed(key []byte) bool {
+	return !bytes.Equal(key, versionKey) && (len(key) == 0 || key[0] != FunctionPrefix)
 }
 
 func (ldb *LevelDB) Close() {
+	ldb.StopExpire()
 	if ldb.db != nil {
 		ldb.db.Close()
 	}
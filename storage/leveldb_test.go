@@ -0,0 +1,138 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openTest opens a db in a temp dir, closed when the test ends.
+func openTest(t *testing.T) *LevelDB {
+	ldb, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	t.Cleanup(ldb.Close)
+	return ldb
+}
+
+// keyEvents records the key events told, see SetKeyEventFunc.
+func keyEvents(t *testing.T) func() []string {
+	var mu sync.Mutex
+	events := []string{}
+	SetKeyEventFunc(func(ldb *LevelDB, event string, key []byte) {
+		mu.Lock()
+		events = append(events, event+" "+string(key))
+		mu.Unlock()
+	})
+	t.Cleanup(func() { SetKeyEventFunc(func(*LevelDB, string, []byte) {}) })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, events...)
+	}
+}
+
+func TestHasExpired(t *testing.T) {
+	ldb := openTest(t)
+	expireAt := time.Now().Add(-time.Second)
+	for i := 0; i < 100; i++ {
+		ldb.PutString([]byte(strconv.Itoa(i)), []byte("foobar"), &expireAt)
+	}
+	events := keyEvents(t)
+
+	// the readers of the expired keys at once, holding the read lock only
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			ldb.RLock()
+			defer ldb.RUnlock()
+			for i := 0; i < 100; i++ {
+				if exists, _, _ := ldb.Has([]byte(strconv.Itoa(i))); exists {
+					t.Errorf("Has the expired key %v", i)
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// every key is told expired once
+	if got := events(); len(got) != 100 {
+		t.Errorf("Error expired events, Get: %v", got)
+	}
+	if n := ldb.KeyCount(); n != 0 {
+		t.Errorf("Error KeyCount, Get: %v", n)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	dir := t.TempDir()
+	ldb, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	expireAt := time.Now().Add(time.Hour)
+	ldb.PutString([]byte("a"), []byte("foobar"), &expireAt)
+	ldb.PutHash([]byte("h"), map[string][]byte{"f": []byte("v")}, nil)
+	ldb.db.Put(encodeFunctionKey("lib"), []byte("code"), nil)
+	if err := ldb.Flush(); err != nil {
+		t.Fatalf("Flush error: %v", err)
+	}
+	ldb.Close()
+
+	// the counter and the version are kept on disk, only the keys are gone
+	ldb, err = Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer ldb.Close()
+	if n := ldb.KeyCount(); n != 0 {
+		t.Errorf("Error KeyCount, Get: %v", n)
+	}
+	if v := ldb.get(keysKey); parseCount(v) != 0 || v == nil {
+		t.Errorf("Error key counter, Get: %v", v)
+	}
+	if n, _ := ldb.Expires(); n != 0 {
+		t.Errorf("Error Expires, Get: %v", n)
+	}
+	if !ldb.hasEntry(encodeFunctionKey("lib")) || !ldb.hasEntry(versionKey) {
+		t.Errorf("Error Flush, the function libraries or the version are deleted")
+	}
+}
+
+func TestViewEvents(t *testing.T) {
+	ldb := openTest(t)
+	expireAt := time.Now().Add(-time.Second)
+	ldb.PutString([]byte("x"), []byte("foobar"), &expireAt)
+	events := keyEvents(t)
+
+	// the events of a view are told when it is committed
+	view := ldb.Begin()
+	view.PutString([]byte("a"), []byte("foobar"), nil)
+	view.Has([]byte("x"))
+	if got := events(); len(got) != 0 {
+		t.Errorf("Error events before Commit, Get: %v", got)
+	}
+	view.Commit()
+	if got := events(); len(got) != 2 || got[0] != "new a" || got[1] != "expired x" {
+		t.Errorf("Error events after Commit, Get: %v", got)
+	}
+
+	// and never if it is dropped
+	view = ldb.Begin()
+	view.PutString([]byte("b"), []byte("foobar"), nil)
+	if got := events(); len(got) != 2 {
+		t.Errorf("Error events of a dropped view, Get: %v", got)
+	}
+}
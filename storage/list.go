@@ -0,0 +1,240 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const listInitialIndex uint64 = 1 << 63
+
+// listInfo is the value of the list info entry, the elements are in [head, tail).
+type listInfo struct {
+	head uint64
+	tail uint64
+}
+
+func (info listInfo) length() int {
+	return int(info.tail - info.head)
+}
+
+func (ldb *LevelDB) getListInfo(key []byte) listInfo {
+	info := listInfo{head: listInitialIndex, tail: listInitialIndex}
+
+	value := ldb.get(encodeListInfoKey(key))
+	if len(value) == 16 {
+		info.head = binary.BigEndian.Uint64(value[0:8])
+		info.tail = binary.BigEndian.Uint64(value[8:16])
+	}
+	return info
+}
+
+func (ldb *LevelDB) putListInfo(batch *leveldb.Batch, key []byte, info listInfo) {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value[0:8], info.head)
+	binary.BigEndian.PutUint64(value[8:16], info.tail)
+	batch.Put(encodeListInfoKey(key), value)
+}
+
+// listRange normalizes the redis style range [start, stop] of a list with length,
+// negative index is from the end of the list. It returns false for an empty range.
+func listRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start = length + start
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+func (ldb *LevelDB) DeleteList(key []byte) {
+	info := ldb.getListInfo(key)
+
+	batch := new(leveldb.Batch)
+	ldb.deleteMeta(batch, key)
+	batch.Delete(encodeListInfoKey(key))
+	for i := info.head; i != info.tail; i++ {
+		batch.Delete(encodeListElementKey(key, i))
+	}
+	ldb.write(batch)
+}
+
+// ListLength returns the length of the list, which is kept in the metadata.
+func (ldb *LevelDB) ListLength(key []byte) int {
+	return ldb.count(key)
+}
+
+// ListPush pushes the values one by one to the head (left is true) or the tail of the
+// list, creates the list if it does not exist. Returns the length after the push.
+func (ldb *LevelDB) ListPush(key []byte, values [][]byte, left bool, expireAt *time.Time) int {
+	info := ldb.getListInfo(key)
+
+	batch := new(leveldb.Batch)
+	for _, value := range values {
+		if left {
+			info.head--
+			batch.Put(encodeListElementKey(key, info.head), value)
+		} else {
+			batch.Put(encodeListElementKey(key, info.tail), value)
+			info.tail++
+		}
+	}
+	ldb.putCountMeta(batch, key, List, expireAt, info.length())
+	ldb.putListInfo(batch, key, info)
+	ldb.write(batch)
+	return info.length()
+}
+
+// ListPop pops at most count elements from the head (left is true) or the tail of the
+// list. The list is deleted when the last element is popped.
+func (ldb *LevelDB) ListPop(key []byte, count int, left bool) [][]byte {
+	info := ldb.getListInfo(key)
+	if count > info.length() {
+		count = info.length()
+	}
+
+	batch := new(leveldb.Batch)
+	values := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		var elementKey []byte
+		if left {
+			elementKey = encodeListElementKey(key, info.head)
+			info.head++
+		} else {
+			info.tail--
+			elementKey = encodeListElementKey(key, info.tail)
+		}
+		values = append(values, ldb.get(elementKey))
+		batch.Delete(elementKey)
+	}
+
+	if info.length() == 0 {
+		ldb.deleteMeta(batch, key)
+		batch.Delete(encodeListInfoKey(key))
+	} else {
+		ldb.putCount(batch, key, List, info.length())
+		ldb.putListInfo(batch, key, info)
+	}
+	ldb.write(batch)
+	return values
+}
+
+// ListIndex returns the element at index, negative index is from the tail. Returns nil if
+// the index is out of range.
+func (ldb *LevelDB) ListIndex(key []byte, index int) []byte {
+	info := ldb.getListInfo(key)
+	if index < 0 {
+		index = info.length() + index
+	}
+	if index < 0 || index >= info.length() {
+		return nil
+	}
+	return ldb.get(encodeListElementKey(key, info.head+uint64(index)))
+}
+
+// ListSet sets the element at index, returns false if the index is out of range.
+func (ldb *LevelDB) ListSet(key []byte, index int, value []byte) bool {
+	info := ldb.getListInfo(key)
+	if index < 0 {
+		index = info.length() + index
+	}
+	if index < 0 || index >= info.length() {
+		return false
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(encodeListElementKey(key, info.head+uint64(index)), value)
+	ldb.write(batch)
+	return true
+}
+
+// ListRange returns the elements in [start, stop], the range is redis style.
+func (ldb *LevelDB) ListRange(key []byte, start, stop int) [][]byte {
+	info := ldb.getListInfo(key)
+	start, stop, ok := listRange(start, stop, info.length())
+	if !ok {
+		return [][]byte{}
+	}
+
+	values := make([][]byte, 0, stop-start+1)
+	r := &util.Range{
+		Start: encodeListElementKey(key, info.head+uint64(start)),
+		Limit: encodeListElementKey(key, info.head+uint64(stop)+1),
+	}
+	iter := ldb.newIterator(r)
+	for iter.Next() {
+		values = append(values, append([]byte{}, iter.Value()...))
+	}
+	iter.Release()
+	return values
+}
+
+func (ldb *LevelDB) GetList(key []byte) [][]byte {
+	return ldb.ListRange(key, 0, -1)
+}
+
+// ListTrim keeps the elements in [start, stop] only, the range is redis style. The list is
+// deleted if the range is empty.
+func (ldb *LevelDB) ListTrim(key []byte, start, stop int) {
+	info := ldb.getListInfo(key)
+	start, stop, ok := listRange(start, stop, info.length())
+	if !ok {
+		ldb.DeleteList(key)
+		return
+	}
+
+	batch := new(leveldb.Batch)
+	head := info.head + uint64(start)
+	tail := info.head + uint64(stop) + 1
+	for i := info.head; i != head; i++ {
+		batch.Delete(encodeListElementKey(key, i))
+	}
+	for i := tail; i != info.tail; i++ {
+		batch.Delete(encodeListElementKey(key, i))
+	}
+	ldb.putCount(batch, key, List, stop-start+1)
+	ldb.putListInfo(batch, key, listInfo{head: head, tail: tail})
+	ldb.write(batch)
+}
+
+// PutList replaces the whole list with values, deletes the list if values is empty.
+// It is for the operations in the middle of the list, e.g. lrem and linsert.
+func (ldb *LevelDB) PutList(key []byte, values [][]byte, expireAt *time.Time) {
+	if len(values) == 0 {
+		ldb.DeleteList(key)
+		return
+	}
+
+	info := ldb.getListInfo(key)
+
+	batch := new(leveldb.Batch)
+	for i := info.head; i != info.tail; i++ {
+		batch.Delete(encodeListElementKey(key, i))
+	}
+
+	ldb.putCountMeta(batch, key, List, expireAt, len(values))
+	info = listInfo{head: listInitialIndex, tail: listInitialIndex}
+	for _, value := range values {
+		batch.Put(encodeListElementKey(key, info.tail), value)
+		info.tail++
+	}
+	ldb.putListInfo(batch, key, info)
+	ldb.write(batch)
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Version 0 encodes the value keys as '-' + rKey for string, '-' + rKey + '|' + suffix for
+// the others. Version 1 has the count in the metadata of set and sorted set only.
+const (
+	metaVersion0 byte = 0x00
+	metaVersion1 byte = 0x01
+	seperator0   byte = '|'
+)
+
+// migrateFunc puts the entries of key in the version next to the one of metadata into batch.
+type migrateFunc func(snap *leveldb.Snapshot, batch *leveldb.Batch, key []byte, metadata []byte) error
+
+// migrate upgrades the db to MetaVersion version by version, then rebuilds the ttl index and
+// sets the counters. Every key is migrated in its own batch, with its metadata rewritten to the
+// next version, so an interrupted migration continues with the keys left in the old versions
+// on the next open.
+func (ldb *LevelDB) migrate() error {
+	version, err := ldb.db.Get(versionKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	if len(version) == 1 && version[0] == MetaVersion {
+		return nil
+	}
+
+	if err := ldb.migrateKeys(metaVersion0, migrateKey0); err != nil {
+		return err
+	}
+	if err := ldb.migrateKeys(metaVersion1, migrateKey1); err != nil {
+		return err
+	}
+
+	c, err := ldb.rebuildTTLIndex()
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(keysKey, encodeCount(c.keys))
+	batch.Put(expiresKey, encodeExpires(c.expires, c.expireSum))
+	batch.Put(versionKey, []byte{MetaVersion})
+	return ldb.db.Write(batch, nil)
+}
+
+// migrateBatchSize is the number of the entries written in one batch by rebuildTTLIndex.
+const migrateBatchSize = 1024
+
+// rebuildTTLIndex puts the ttl index entry of every key with an expire time, the keys written
+// before the index have none, and deletes the stale entries. It returns the counters of the db.
+func (ldb *LevelDB) rebuildTTLIndex() (counters, error) {
+	var c counters
+	snap, err := ldb.db.GetSnapshot()
+	if err != nil {
+		return c, err
+	}
+	defer snap.Release()
+
+	batch := new(leveldb.Batch)
+	flush := func() error {
+		if batch.Len() < migrateBatchSize {
+			return nil
+		}
+		err := ldb.db.Write(batch, nil)
+		batch.Reset()
+		return err
+	}
+
+	iter := snap.NewIterator(util.BytesPrefix([]byte{TTLPrefix}), nil)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+		if err := flush(); err != nil {
+			iter.Release()
+			return c, err
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return c, err
+	}
+
+	iter = snap.NewIterator(util.BytesPrefix([]byte{MetaPrefix}), nil)
+	defer iter.Release()
+	for iter.Next() {
+		c.keys++
+		_, expireAt, _, err := parseMetadata(iter.Value())
+		if err != nil {
+			return c, err
+		}
+		if expireAt != nil {
+			batch.Put(encodeTTLKey(iter.Key()[1:], *expireAt), nil)
+			c.expires++
+			c.expireSum += uint64(expireAt.UnixMilli())
+		}
+		if err := flush(); err != nil {
+			return c, err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return c, err
+	}
+	return c, ldb.db.Write(batch, nil)
+}
+
+// migrateKeys calls fn for every key in version.
+func (ldb *LevelDB) migrateKeys(version byte, fn migrateFunc) error {
+	snap, err := ldb.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	iter := snap.NewIterator(util.BytesPrefix([]byte{MetaPrefix}), nil)
+	defer iter.Release()
+	for iter.Next() {
+		metadata := iter.Value()
+		if len(metadata) < 2 || metadata[0] != version {
+			continue
+		}
+
+		key := append([]byte{}, iter.Key()[1:]...)
+		batch := new(leveldb.Batch)
+		if err := fn(snap, batch, key, metadata); err != nil {
+			return err
+		}
+		if err := ldb.db.Write(batch, nil); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// migrateKey0 moves the value entries of key from the version 0 encoding into batch, and
+// rewrites its metadata. The metadata layout is unchanged except the version byte.
+func migrateKey0(snap *leveldb.Snapshot, batch *leveldb.Batch, key []byte, metadata []byte) error {
+	batch.Put(encodeMetaKey(key), append([]byte{metaVersion1}, metadata[1:]...))
+
+	if metadata[1]&0x0F == String {
+		oldKey := append([]byte{ValuePrefix}, key...)
+		value, err := snap.Get(oldKey, nil)
+		if err == leveldb.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		batch.Delete(oldKey)
+		batch.Put(encodeStringKey(key), value)
+		return nil
+	}
+
+	prefix := append(append([]byte{ValuePrefix}, key...), seperator0)
+	iter := snap.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	entries := 0
+	for iter.Next() {
+		suffix := iter.Key()[len(prefix):]
+		if ownedByLonger0(snap, key, suffix) {
+			continue
+		}
+		batch.Delete(iter.Key())
+		batch.Put(encodeValueKey(key, suffix), iter.Value())
+		entries++
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	// All the entries belong to the longer keys, the key is left with no element, so it is
+	// dropped rather than kept empty.
+	if entries == 0 {
+		batch.Delete(encodeMetaKey(key))
+	}
+	return nil
+}
+
+// ownedByLonger0 reports if the version 0 value entry of key with suffix belongs to a longer
+// key, e.g. '-a|b|c' is the field 'c' of the hash 'a|b' rather than the field 'b|c' of the
+// hash 'a' if the hash 'a|b' exists. Such entries are ambiguous in version 0, the longer key
+// wins.
+func ownedByLonger0(snap *leveldb.Snapshot, key []byte, suffix []byte) bool {
+	for i := 0; i <= len(suffix); i++ {
+		if i < len(suffix) && suffix[i] != seperator0 {
+			continue
+		}
+
+		longer := append(append(append([]byte{}, key...), seperator0), suffix[:i]...)
+		metadata, err := snap.Get(encodeMetaKey(longer), nil)
+		if err != nil || len(metadata) < 2 {
+			continue
+		}
+		// The whole suffix can only be the value of a string: '-a|b' is the string 'a|b',
+		// while a part of it only the prefix of a collection.
+		if (i == len(suffix)) == (metadata[1]&0x0F == String) {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateKey1 appends the count of the elements to the metadata of hash and list.
+func migrateKey1(snap *leveldb.Snapshot, batch *leveldb.Batch, key []byte, metadata []byte) error {
+	metadata = append([]byte{MetaVersion}, metadata[1:]...)
+
+	switch metadata[1] & 0x0F {
+	case Hash:
+		count := 0
+		iter := snap.NewIterator(util.BytesPrefix(encodeHashFieldKey(key, nil)), nil)
+		for iter.Next() {
+			count++
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+		metadata = append(metadata, encodeCount(count)...)
+	case List:
+		value, err := snap.Get(encodeListInfoKey(key), nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			return err
+		}
+		count := 0
+		if len(value) == 16 {
+			count = int(binary.BigEndian.Uint64(value[8:16]) - binary.BigEndian.Uint64(value[0:8]))
+		}
+		metadata = append(metadata, encodeCount(count)...)
+	}
+
+	batch.Put(encodeMetaKey(key), metadata)
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// metadata0 encodes the metadata in version 0.
+func metadata0(tipe byte, expireAt *time.Time) []byte {
+	metadata := []byte{metaVersion0, tipe}
+	if expireAt != nil {
+		expire, _ := expireAt.MarshalBinary()
+		metadata[1] |= 0x10
+		metadata = append(metadata, expire...)
+	}
+	return metadata
+}
+
+func TestMigrate0(t *testing.T) {
+	dir := t.TempDir()
+	expireAt := time.Now().Add(time.Hour).Round(0)
+	entries := []struct{ key, value string }{
+		{"+s", string(metadata0(String, &expireAt))}, // written before the ttl index
+		{"-s", "foobar"},
+		{"+h", string(metadata0(Hash, nil))},
+		{"-h|f1", "v1"},
+		{"-h|f2", "v2"},
+		{"+a|b", string(metadata0(Hash, nil))},
+		{"-a|b|c", "x"}, // the field 'c' of 'a|b', or the field 'b|c' of 'a'
+		{"+a", string(metadata0(Hash, &expireAt))},
+		{string(encodeTTLKey([]byte("gone"), expireAt)), ""}, // stale
+	}
+
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatalf("OpenFile error: %v", err)
+	}
+	for _, e := range entries {
+		db.Put([]byte(e.key), []byte(e.value), nil)
+	}
+	db.Close()
+
+	ldb, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer ldb.Close()
+
+	if v := ldb.GetString([]byte("s")); string(v) != "foobar" {
+		t.Errorf("Error migrated string, Get: %q", v)
+	}
+	if exists, _, at := ldb.Has([]byte("s")); !exists || at == nil || !at.Equal(expireAt) {
+		t.Errorf("Error migrated expire, Get: %v, %v", exists, at)
+	}
+	if h := ldb.GetHash([]byte("h")); len(h) != 2 || string(h["f1"]) != "v1" || string(h["f2"]) != "v2" || ldb.HashLength([]byte("h")) != 2 {
+		t.Errorf("Error migrated hash, Get: %q", h)
+	}
+	if h := ldb.GetHash([]byte("a|b")); len(h) != 1 || string(h["c"]) != "x" {
+		t.Errorf("Error migrated hash of the longer key, Get: %q", h)
+	}
+	if exists, _, _ := ldb.Has([]byte("a")); exists {
+		t.Errorf("Error migrated hash left with no field, it exists")
+	}
+
+	if n := ldb.KeyCount(); n != 3 {
+		t.Errorf("Error KeyCount, Get: %v", n)
+	}
+	if n, _ := ldb.Expires(); n != 1 {
+		t.Errorf("Error Expires, Get: %v", n)
+	}
+	if !ldb.hasEntry(encodeTTLKey([]byte("s"), expireAt)) {
+		t.Errorf("Error ttl index of the migrated key, not found")
+	}
+}
+
+func TestMigrateDone(t *testing.T) {
+	dir := t.TempDir()
+	ldb, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	ldb.PutString([]byte("s"), []byte("foobar"), nil)
+	ldb.Close()
+
+	// a db in MetaVersion is opened as it is
+	ldb, err = Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer ldb.Close()
+	if v := ldb.GetString([]byte("s")); string(v) != "foobar" || ldb.KeyCount() != 1 {
+		t.Errorf("Error reopened db, Get: %q, %v keys", v, ldb.KeyCount())
+	}
+}
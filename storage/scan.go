@@ -0,0 +1,66 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Scan iterates the keys in order, starting after cursor (from the first key if cursor is
+// nil), and calls fn with each key and its type, at most count keys (0 for all). The
+// expired keys are skipped. It returns the last key visited as the cursor to continue, nil
+// if there are no more keys.
+// As the cursor is a key, the iteration is resumable whatever is written in between: the
+// keys existing all the time are visited exactly once.
+func (ldb *LevelDB) Scan(cursor []byte, count int, fn func(key []byte, tipe byte)) []byte {
+	r := util.BytesPrefix([]byte{MetaPrefix})
+	if cursor != nil {
+		r.Start = append(encodeMetaKey(cursor), 0x00)
+	}
+
+	return ldb.scan(r, 1, count, func(k, v []byte) {
+		tipe, expireAt, _, err := parseMetadata(v)
+		if err != nil || isExpired(expireAt) {
+			return
+		}
+		fn(k[1:], tipe)
+	})
+}
+
+// HashScan is Scan for the fields of a hash, fn is called with each field and its value.
+func (ldb *LevelDB) HashScan(key []byte, cursor []byte, count int, fn func(field, value []byte)) []byte {
+	prefix := encodeHashFieldKey(key, nil)
+	r := util.BytesPrefix(prefix)
+	if cursor != nil {
+		r.Start = append(encodeHashFieldKey(key, cursor), 0x00)
+	}
+
+	return ldb.scan(r, len(prefix), count, func(k, v []byte) {
+		fn(k[len(prefix):], v)
+	})
+}
+
+// scan calls fn with at most count (0 for all) entries in r, returns the key of the last
+// entry with the prefix (prefixLen bytes) trimmed, nil if r is exhausted.
+func (ldb *LevelDB) scan(r *util.Range, prefixLen int, count int, fn func(k, v []byte)) []byte {
+	iter := ldb.newIterator(r)
+	defer iter.Release()
+
+	visited := 0
+	for iter.Next() {
+		k := append([]byte{}, iter.Key()...)
+		fn(k, append([]byte{}, iter.Value()...))
+
+		visited++
+		if count > 0 && visited == count {
+			if !iter.Next() {
+				return nil
+			}
+			return k[prefixLen:]
+		}
+	}
+	return nil
+}
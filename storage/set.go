@@ -0,0 +1,106 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func (ldb *LevelDB) DeleteSet(key []byte) {
+	batch := new(leveldb.Batch)
+	ldb.deleteMeta(batch, key)
+
+	setPrefix := encodeSetMemberKey(key, nil)
+	iter := ldb.newIterator(util.BytesPrefix(setPrefix))
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	iter.Release()
+	ldb.write(batch)
+}
+
+// SetCard returns the number of the members, which is kept in the metadata.
+func (ldb *LevelDB) SetCard(key []byte) int {
+	return ldb.count(key)
+}
+
+func (ldb *LevelDB) SetIsMember(key []byte, member []byte) bool {
+	// the value of a member is empty, so check the key rather than get the value
+	return ldb.hasEntry(encodeSetMemberKey(key, member))
+}
+
+// SetAdd adds the members to the set, creates the set if it does not exist. Returns the
+// number of the members added, not including the ones already in the set.
+func (ldb *LevelDB) SetAdd(key []byte, members [][]byte, expireAt *time.Time) int {
+	count := ldb.count(key)
+	added := make(map[string]bool)
+
+	batch := new(leveldb.Batch)
+	for _, member := range members {
+		if added[string(member)] || ldb.SetIsMember(key, member) {
+			continue
+		}
+		added[string(member)] = true
+		batch.Put(encodeSetMemberKey(key, member), []byte{})
+	}
+	ldb.putCountMeta(batch, key, Set, expireAt, count+len(added))
+	ldb.write(batch)
+	return len(added)
+}
+
+// SetRemove removes the members from the set, returns the number of the members removed.
+// The set is deleted when the last member is removed.
+func (ldb *LevelDB) SetRemove(key []byte, members [][]byte) int {
+	_, _, expireAt := ldb.has(encodeMetaKey(key))
+	count := ldb.count(key)
+	removed := make(map[string]bool)
+
+	batch := new(leveldb.Batch)
+	for _, member := range members {
+		if removed[string(member)] || !ldb.SetIsMember(key, member) {
+			continue
+		}
+		removed[string(member)] = true
+		batch.Delete(encodeSetMemberKey(key, member))
+	}
+	if len(removed) == 0 {
+		return 0
+	}
+
+	if count-len(removed) <= 0 {
+		ldb.deleteMeta(batch, key)
+	} else {
+		ldb.putCountMeta(batch, key, Set, expireAt, count-len(removed))
+	}
+	ldb.write(batch)
+	return len(removed)
+}
+
+// GetSet returns all the members, in the order of the member bytes.
+func (ldb *LevelDB) GetSet(key []byte) [][]byte {
+	members := [][]byte{}
+
+	setPrefix := encodeSetMemberKey(key, nil)
+	iter := ldb.newIterator(util.BytesPrefix(setPrefix))
+	for iter.Next() {
+		members = append(members, append([]byte{}, iter.Key()[len(setPrefix):]...))
+	}
+	iter.Release()
+	return members
+}
+
+// PutSet replaces the value of key, whatever its type is, with a set of the members.
+// The key is deleted if members is empty.
+func (ldb *LevelDB) PutSet(key []byte, members [][]byte, expireAt *time.Time) {
+	ldb.Delete(key)
+	if len(members) == 0 {
+		return
+	}
+	ldb.SetAdd(key, members, expireAt)
+}
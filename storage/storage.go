@@ -7,6 +7,7 @@ package storage
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb/opt"
 )
@@ -20,11 +21,33 @@ func OpenStorage(dbPath string, options *opt.Options) error {
 		if err != nil {
 			return err
 		}
+		db.index = i
 		storage[i] = db
 	}
 	return nil
 }
 
+// StartExpire starts the background expirer of every db with the parameters, which are
+// changed later by SetExpireParams. It should be called once.
+func StartExpire(interval time.Duration, maxKeys int) {
+	SetExpireParams(interval, maxKeys)
+	for _, ldb := range storage {
+		ldb.StartExpire()
+	}
+}
+
+// KeyEventFunc is called on the events of the keys found by the storage rather than told by
+// the commands: "new" when a key is created, "expired" when an expired key is deleted. It is
+// called with the db locked, so it should not block. For a view it is called by Commit.
+type KeyEventFunc func(ldb *LevelDB, event string, key []byte)
+
+var keyEvent KeyEventFunc = func(*LevelDB, string, []byte) {}
+
+// SetKeyEventFunc sets the function called on the key events, before the dbs are used.
+func SetKeyEventFunc(f KeyEventFunc) {
+	keyEvent = f
+}
+
 func SelectStorage(i int) *LevelDB {
 	return storage[i]
 }
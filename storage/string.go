@@ -7,10 +7,10 @@ import (
 )
 
 func (ldb *LevelDB) DeleteString(key []byte) {
-	metaKey := encodeMetaKey(key)
-	valueKey := encodeStringKey(key)
-
-	ldb.delete([][]byte{metaKey, valueKey})
+	batch := new(leveldb.Batch)
+	ldb.deleteMeta(batch, key)
+	batch.Delete(encodeStringKey(key))
+	ldb.write(batch)
 }
 
 func (ldb *LevelDB) GetString(key []byte) []byte {
@@ -24,13 +24,11 @@ func (ldb *LevelDB) PutString(key []byte, value []byte, expireAt *time.Time) {
 
 	exists, tipe, _ := ldb.has(metaKey)
 	if exists && tipe != String { // If exists data is not string, should delete it.
-		ldb.delete([][]byte{metaKey, valueKey})
+		ldb.deleteKey(key, tipe)
 	}
 
 	batch := new(leveldb.Batch)
-	batch.Put(metaKey, encodeMetadata(String, expireAt))
+	ldb.putMeta(batch, key, String, expireAt)
 	batch.Put(valueKey, value)
-	if err := ldb.db.Write(batch, nil); err != nil {
-		panic(err)
-	}
+	ldb.write(batch)
 }
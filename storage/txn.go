@@ -0,0 +1,284 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"bytes"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// A transaction is a view of the db: the writes are collected in one batch, which is
+// written by Commit at once, so a crash never leaves a half-applied transaction. The reads
+// of the view see the writes before them, which are kept in an overlay as well: the value
+// of a put is prefixed by txnPut, a delete is a txnDelete entry.
+type txn struct {
+	base     *LevelDB
+	batch    *leveldb.Batch
+	overlay  *memdb.DB
+	deferred []func() // called by Commit, see Defer
+}
+
+const (
+	txnDelete byte = 0
+	txnPut    byte = 1
+)
+
+// Begin starts a transaction, returns its view. The caller should hold the write lock of
+// the db until the view is committed or dropped, the lock of the view itself does nothing.
+func (ldb *LevelDB) Begin() *LevelDB {
+	t := &txn{base: ldb, batch: new(leveldb.Batch), overlay: memdb.New(comparer.DefaultComparer, 0)}
+	return &LevelDB{db: ldb.db, rwm: nopLocker{}, txn: t, index: ldb.index, counters: ldb.counters}
+}
+
+// Commit writes the transaction of the view, a view without any write writes nothing. Then
+// the functions deferred by the view are called. A view is dropped simply by not committing
+// it.
+func (ldb *LevelDB) Commit() {
+	t := ldb.txn
+	if t.batch.Len() > 0 {
+		t.base.commit(t.batch, ldb.counters)
+	}
+
+	for _, f := range t.deferred {
+		f()
+	}
+	t.deferred = nil
+}
+
+// commit writes the batch of a view, c are the counters after it.
+func (ldb *LevelDB) commit(batch *leveldb.Batch, c counters) {
+	ldb.wm.Lock()
+	defer ldb.wm.Unlock()
+
+	if err := ldb.db.Write(batch, nil); err != nil {
+		panic(err)
+	}
+	ldb.counters = c
+	ldb.touch(batch)
+}
+
+// Defer calls f when the view is committed, or at once if ldb is not a view. So the events of
+// the writes of a transaction are told only after they are written, and never if the view is
+// dropped.
+func (ldb *LevelDB) Defer(f func()) {
+	if ldb.txn == nil {
+		f()
+		return
+	}
+	ldb.txn.deferred = append(ldb.txn.deferred, f)
+}
+
+// Put and Delete make txn a leveldb.BatchReplay, which applies a batch to the overlay.
+func (t *txn) Put(key, value []byte) {
+	t.batch.Put(key, value)
+	t.overlay.Put(key, append([]byte{txnPut}, value...))
+}
+
+func (t *txn) Delete(key []byte) {
+	t.batch.Delete(key)
+	t.overlay.Put(key, []byte{txnDelete})
+}
+
+// get reads key in the overlay, found is false if the key is not written by the view.
+func (t *txn) get(key []byte) (value []byte, found bool, err error) {
+	v, err := t.overlay.Get(key)
+	if err == memdb.ErrNotFound {
+		return nil, false, nil
+	}
+	if v[0] == txnDelete {
+		return nil, true, leveldb.ErrNotFound
+	}
+	return append([]byte{}, v[1:]...), true, nil
+}
+
+type nopLocker struct{}
+
+func (nopLocker) Lock()    {}
+func (nopLocker) Unlock()  {}
+func (nopLocker) RLock()   {}
+func (nopLocker) RUnlock() {}
+
+// txnIterator merges the iterators of the db and the overlay, an entry of the overlay hides
+// the one of the db with the same key, a delete hides both.
+type txnIterator struct {
+	base, overlay iterator.Iterator
+	releaser      util.Releaser
+
+	started           bool
+	forward           bool
+	valid             bool
+	fromBase, fromOvl bool // which iterators are at the current entry
+	key, value        []byte
+}
+
+func newTxnIterator(base, overlay iterator.Iterator) *txnIterator {
+	return &txnIterator{base: base, overlay: overlay}
+}
+
+func (it *txnIterator) First() bool {
+	it.base.First()
+	it.overlay.First()
+	it.forward = true
+	return it.settle()
+}
+
+func (it *txnIterator) Last() bool {
+	it.base.Last()
+	it.overlay.Last()
+	it.forward = false
+	return it.settle()
+}
+
+func (it *txnIterator) Seek(key []byte) bool {
+	it.base.Seek(key)
+	it.overlay.Seek(key)
+	it.forward = true
+	return it.settle()
+}
+
+func (it *txnIterator) Next() bool {
+	if !it.valid { // from the start, as well as from the end of a backward iteration
+		if !it.started || !it.forward {
+			return it.First()
+		}
+		return false
+	}
+
+	if !it.forward { // change the direction: step over the current key on both
+		key := append([]byte{}, it.key...)
+		for _, iter := range []iterator.Iterator{it.base, it.overlay} {
+			if iter.Seek(key) && bytes.Equal(iter.Key(), key) {
+				iter.Next()
+			}
+		}
+		it.forward = true
+		return it.settle()
+	}
+
+	if it.fromBase {
+		it.base.Next()
+	}
+	if it.fromOvl {
+		it.overlay.Next()
+	}
+	return it.settle()
+}
+
+func (it *txnIterator) Prev() bool {
+	if !it.valid {
+		if !it.started || it.forward {
+			return it.Last()
+		}
+		return false
+	}
+
+	if it.forward { // change the direction: step back from the current key on both
+		key := append([]byte{}, it.key...)
+		for _, iter := range []iterator.Iterator{it.base, it.overlay} {
+			if iter.Seek(key) {
+				iter.Prev()
+			} else {
+				iter.Last()
+			}
+		}
+		it.forward = false
+		return it.settle()
+	}
+
+	if it.fromBase {
+		it.base.Prev()
+	}
+	if it.fromOvl {
+		it.overlay.Prev()
+	}
+	return it.settle()
+}
+
+// settle moves to the next visible entry in the direction, from the current positions.
+func (it *txnIterator) settle() bool {
+	it.started = true
+	for {
+		baseValid, ovlValid := it.base.Valid(), it.overlay.Valid()
+		if !baseValid && !ovlValid {
+			it.valid, it.key, it.value = false, nil, nil
+			return false
+		}
+
+		// c < 0: the base entry goes first in the direction, c > 0: the overlay one
+		var c int
+		switch {
+		case !ovlValid:
+			c = -1
+		case !baseValid:
+			c = 1
+		default:
+			c = bytes.Compare(it.base.Key(), it.overlay.Key())
+			if !it.forward {
+				c = -c
+			}
+		}
+
+		it.fromBase, it.fromOvl = c <= 0, c >= 0
+		if it.fromOvl && it.overlay.Value()[0] == txnDelete {
+			it.step()
+			continue
+		}
+
+		it.valid = true
+		if it.fromOvl {
+			it.key, it.value = it.overlay.Key(), it.overlay.Value()[1:]
+		} else {
+			it.key, it.value = it.base.Key(), it.base.Value()
+		}
+		return true
+	}
+}
+
+// step moves the iterators at the current entry.
+func (it *txnIterator) step() {
+	for _, at := range []struct {
+		iter iterator.Iterator
+		ok   bool
+	}{{it.base, it.fromBase}, {it.overlay, it.fromOvl}} {
+		if !at.ok {
+			continue
+		}
+		if it.forward {
+			at.iter.Next()
+		} else {
+			at.iter.Prev()
+		}
+	}
+}
+
+func (it *txnIterator) Valid() bool   { return it.valid }
+func (it *txnIterator) Key() []byte   { return it.key }
+func (it *txnIterator) Value() []byte { return it.value }
+
+func (it *txnIterator) Error() error {
+	if err := it.base.Error(); err != nil {
+		return err
+	}
+	return it.overlay.Error()
+}
+
+func (it *txnIterator) Release() {
+	it.base.Release()
+	it.overlay.Release()
+	if it.releaser != nil {
+		it.releaser.Release()
+		it.releaser = nil
+	}
+}
+
+func (it *txnIterator) SetReleaser(releaser util.Releaser) {
+	it.releaser = releaser
+}
@@ -6,6 +6,7 @@
 package storage
 
 import (
+	"encoding/binary"
 	"errors"
 	"time"
 
@@ -47,10 +48,13 @@ type RodisData interface {
 }
 
 const (
-	MetaVersion byte = 0x00
+	MetaVersion byte = 0x02
 	MetaPrefix  byte = '+'
 	ValuePrefix byte = '-'
-	Seperator   byte = '|'
+	TTLPrefix   byte = '@'
+	SysPrefix   byte = '!'
+
+	FunctionPrefix byte = '#' // the function libraries, which belong to no db, see function.go
 )
 
 var (
@@ -64,56 +68,184 @@ func encodeMetaKey(key []byte) []byte {
 	return metaKey
 }
 
-func encodeMetadata(tipe byte, expireAt *time.Time) []byte {
-	if expireAt == nil || expireAt.IsZero() {
-		return []byte{MetaVersion, tipe}
-	}
+// The system entries, see doc.go.
+var (
+	versionKey = []byte{SysPrefix, 'v', 'e', 'r', 's', 'i', 'o', 'n'}
+	keysKey    = []byte{SysPrefix, 'k', 'e', 'y', 's'}
+	expiresKey = []byte{SysPrefix, 'e', 'x', 'p', 'i', 'r', 'e', 's'}
+)
+
+// hasCount reports if the metadata of the type ends with the count of the elements, 8 bytes
+// big endian.
+func hasCount(tipe byte) bool {
+	return tipe == Hash || tipe == List || tipe == Set || tipe == SortedSet
+}
 
-	expire, _ := expireAt.MarshalBinary()
-	metadata := make([]byte, 1 /*version*/ +1 /*hasExpire + type*/ +len(expire))
-	metadata[0] = MetaVersion
-	metadata[1] = 0x10 | tipe
-	copy(metadata[2:], expire)
+func encodeMetadata(tipe byte, expireAt *time.Time, count int) []byte {
+	metadata := []byte{MetaVersion, tipe}
+	if expireAt != nil && !expireAt.IsZero() {
+		expire, _ := expireAt.MarshalBinary()
+		metadata[1] = 0x10 | tipe
+		metadata = append(metadata, expire...)
+	}
 
+	if hasCount(tipe) {
+		metadata = append(metadata, encodeCount(count)...)
+	}
 	return metadata
 }
 
-func parseMetadata(metadata []byte) (byte, *time.Time, error) {
+func encodeCount(count int) []byte {
+	c := make([]byte, 8)
+	binary.BigEndian.PutUint64(c, uint64(count))
+	return c
+}
+
+func parseCount(c []byte) int {
+	if len(c) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(c))
+}
+
+// encodeExpires encodes the number of the ttl index entries and the sum of their expire times,
+// see counters.
+func encodeExpires(expires int, expireSum uint64) []byte {
+	c := make([]byte, 16)
+	binary.BigEndian.PutUint64(c, uint64(expires))
+	binary.BigEndian.PutUint64(c[8:], expireSum)
+	return c
+}
+
+func parseExpires(c []byte) (int, uint64) {
+	if len(c) != 16 {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint64(c)), binary.BigEndian.Uint64(c[8:])
+}
+
+func parseMetadata(metadata []byte) (byte, *time.Time, int, error) {
 	if len(metadata) < 2 {
-		return None, nil, ErrMetaFormat
+		return None, nil, 0, ErrMetaFormat
 	}
 	if metadata[0] != MetaVersion {
-		return None, nil, ErrMetaFormat
+		return None, nil, 0, ErrMetaFormat
 	}
 
 	tipe := metadata[1] & 0x0F // lower 4 bits of metadata[1] is type
 	hasExpire := metadata[1]&byte(0xF0) == byte(0x10)
 
+	count := 0
+	if hasCount(tipe) {
+		if len(metadata) < 2+8 {
+			return None, nil, 0, ErrMetaFormat
+		}
+		count = parseCount(metadata[len(metadata)-8:])
+		metadata = metadata[:len(metadata)-8]
+	}
+
 	if !hasExpire {
-		return tipe, nil, nil
+		return tipe, nil, count, nil
 	}
 
 	var expireAt time.Time
 	err := expireAt.UnmarshalBinary(metadata[2:])
 	if err != nil {
-		return None, nil, ErrMetaFormat
+		return None, nil, 0, ErrMetaFormat
 	}
 
-	return tipe, &expireAt, nil
+	return tipe, &expireAt, count, nil
 }
 
 func encodeStringKey(key []byte) []byte {
-	valueKey := make([]byte, 1 /* '+' */ +len(key))
+	return encodeValueKey(key, nil)
+}
+
+// encodeValueKey encodes the value key: '-' + len(rKey) (4 bytes big endian) + rKey + suffix.
+// As the length of rKey goes first, the value keys of a rKey never share the prefix with the
+// ones of another rKey, whatever bytes are in rKey and suffix.
+func encodeValueKey(key []byte, suffix []byte) []byte {
+	valueKey := make([]byte, 1 /* '-' */ +4+len(key)+len(suffix))
 	valueKey[0] = ValuePrefix
-	copy(valueKey[1:], key)
+	binary.BigEndian.PutUint32(valueKey[1:], uint32(len(key)))
+	copy(valueKey[5:], key)
+	copy(valueKey[5+len(key):], suffix)
 	return valueKey
 }
 
+// parseRKey returns rKey of a meta key, value key or ttl index key, nil for the others.
+func parseRKey(k []byte) []byte {
+	switch {
+	case len(k) >= 1 && k[0] == MetaPrefix:
+		return k[1:]
+	case len(k) >= 5 && k[0] == ValuePrefix:
+		n := int(binary.BigEndian.Uint32(k[1:5]))
+		if 5+n <= len(k) {
+			return k[5 : 5+n]
+		}
+	case len(k) >= 9 && k[0] == TTLPrefix:
+		return k[9:]
+	}
+	return nil
+}
+
 func encodeHashFieldKey(key []byte, field []byte) []byte {
-	fieldKey := make([]byte, 1 /* '-' */ +len(key)+1 /* '|' */ +len(field))
-	fieldKey[0] = ValuePrefix
-	copy(fieldKey[1:], key)
-	fieldKey[1+len(key)] = Seperator
-	copy(fieldKey[1+len(key)+1:], field)
-	return fieldKey
+	return encodeValueKey(key, field)
+}
+
+// The list info entry has a 2 bytes suffix, it never conflicts with the 8 bytes suffix of
+// the elements.
+func encodeListInfoKey(key []byte) []byte {
+	return encodeValueKey(key, []byte{0x00, 0x00})
+}
+
+func encodeSetMemberKey(key []byte, member []byte) []byte {
+	return encodeValueKey(key, member)
+}
+
+// A sorted set has two entries for each member, both under the value key prefix: the member
+// entry keeps the score, and the score index entry is ordered by the score then the member.
+const (
+	zsetMemberTag byte = 0x01
+	zsetScoreTag  byte = 0x02
+)
+
+func encodeZSetMemberKey(key []byte, member []byte) []byte {
+	return encodeValueKey(key, append([]byte{zsetMemberTag}, member...))
+}
+
+func encodeZSetScorePrefix(key []byte) []byte {
+	return encodeValueKey(key, []byte{zsetScoreTag})
+}
+
+func encodeZSetScoreKey(key []byte, score float64, member []byte) []byte {
+	suffix := make([]byte, 0, 1+8+len(member))
+	suffix = append(suffix, zsetScoreTag)
+	suffix = append(suffix, encodeScore(score)...)
+	suffix = append(suffix, member...)
+	return encodeValueKey(key, suffix)
+}
+
+func encodeListElementKey(key []byte, index uint64) []byte {
+	var suffix [8]byte
+	binary.BigEndian.PutUint64(suffix[:], index)
+	return encodeValueKey(key, suffix[:])
+}
+
+// TTL index key: '@' + expire time (unix nano, 8 bytes big endian) + rKey.
+// LevelDB keeps the keys ordered, so the index entries are ordered by expire time.
+func encodeTTLKey(key []byte, expireAt time.Time) []byte {
+	ttlKey := make([]byte, 1 /* '@' */ +8+len(key))
+	ttlKey[0] = TTLPrefix
+	binary.BigEndian.PutUint64(ttlKey[1:], uint64(expireAt.UnixNano()))
+	copy(ttlKey[9:], key)
+	return ttlKey
+}
+
+func parseTTLKey(ttlKey []byte) ([]byte, time.Time, error) {
+	if len(ttlKey) < 9 || ttlKey[0] != TTLPrefix {
+		return nil, time.Time{}, ErrMetaFormat
+	}
+	expireAt := time.Unix(0, int64(binary.BigEndian.Uint64(ttlKey[1:9])))
+	return ttlKey[9:], expireAt, nil
 }
@@ -0,0 +1,98 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"sync/atomic"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// A Watch is the keys watched by a client, maybe in more than one db. It is dirty once any
+// of the keys is modified: every batch written to the db (including the deletes of the
+// expired keys and the commit of a transaction) touches the keys of its entries.
+type Watch struct {
+	dirty int32
+}
+
+// Dirty reports if any watched key is modified since it is watched.
+func (w *Watch) Dirty() bool {
+	return atomic.LoadInt32(&w.dirty) != 0
+}
+
+// Watch adds key to w, the caller should hold the db lock, so the key is not modified in
+// between being checked by the caller and watched.
+func (ldb *LevelDB) Watch(key []byte, w *Watch) {
+	ldb.wm.Lock()
+	defer ldb.wm.Unlock()
+
+	if ldb.watches == nil {
+		ldb.watches = make(map[string]map[*Watch]bool)
+	}
+	ws, ok := ldb.watches[string(key)]
+	if !ok {
+		ws = make(map[*Watch]bool)
+		ldb.watches[string(key)] = ws
+	}
+	ws[w] = true
+}
+
+// Unwatch removes key from w.
+func (ldb *LevelDB) Unwatch(key []byte, w *Watch) {
+	ldb.wm.Lock()
+	defer ldb.wm.Unlock()
+
+	ws := ldb.watches[string(key)]
+	delete(ws, w)
+	if len(ws) == 0 {
+		delete(ldb.watches, string(key))
+	}
+}
+
+// touch marks the watches of the keys written in batch, the caller should hold wm.
+func (ldb *LevelDB) touch(batch *leveldb.Batch) {
+	if len(ldb.watches) == 0 {
+		return
+	}
+	if err := batch.Replay(touchReplay{ldb}); err != nil {
+		panic(err)
+	}
+}
+
+// touchAll marks the watches of all the existing keys, before the db is flushed. The caller
+// should hold wm.
+func (ldb *LevelDB) touchAll() {
+	for key, ws := range ldb.watches {
+		if !ldb.hasEntry(encodeMetaKey([]byte(key))) {
+			continue
+		}
+		for w := range ws {
+			atomic.StoreInt32(&w.dirty, 1)
+		}
+	}
+}
+
+func (ldb *LevelDB) touchKey(k []byte) {
+	key := parseRKey(k)
+	if key == nil {
+		return
+	}
+	for w := range ldb.watches[string(key)] {
+		atomic.StoreInt32(&w.dirty, 1)
+	}
+}
+
+type touchReplay struct {
+	ldb *LevelDB
+}
+
+func (r touchReplay) Put(key, value []byte) {
+	r.ldb.touchKey(key)
+}
+
+func (r touchReplay) Delete(key []byte) {
+	r.ldb.touchKey(key)
+}
@@ -0,0 +1,345 @@
+// Copyright (c) 2015, Rod Dong <rod.dong@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by The MIT License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ZMember is a member of a sorted set with its score.
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ScoreBound is the min or max of a score range.
+type ScoreBound struct {
+	Score     float64
+	Exclusive bool
+}
+
+// LexBound is the min or max of a lex range. Inf is -1 for '-', 1 for '+', and 0 for a
+// bound with Member.
+type LexBound struct {
+	Member    []byte
+	Exclusive bool
+	Inf       int
+}
+
+// encodeScore encodes the score to 8 bytes, which keep the order of the scores when they
+// are compared as bytes: flip the sign bit of a positive score, flip all bits of a negative
+// one.
+func encodeScore(score float64) []byte {
+	if score == 0 {
+		score = 0 // -0 and 0 are the same score
+	}
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, bits)
+	return b
+}
+
+func decodeScore(b []byte) float64 {
+	bits := binary.BigEndian.Uint64(b)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+// nextScoreKey returns the first key after all index entries with the score of scoreKey,
+// which ends with an encoded score.
+func nextScoreKey(scoreKey []byte) []byte {
+	next := append([]byte{}, scoreKey...)
+	s := next[len(next)-8:]
+	binary.BigEndian.PutUint64(s, binary.BigEndian.Uint64(s)+1)
+	return next
+}
+
+func (ldb *LevelDB) DeleteZSet(key []byte) {
+	batch := new(leveldb.Batch)
+	ldb.deleteMeta(batch, key)
+
+	iter := ldb.newIterator(util.BytesPrefix(encodeValueKey(key, nil)))
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	iter.Release()
+	ldb.write(batch)
+}
+
+// ZSetCard returns the number of the members, which is kept in the metadata.
+func (ldb *LevelDB) ZSetCard(key []byte) int {
+	return ldb.count(key)
+}
+
+// ZSetScore returns the score of the member, false if it is not in the sorted set.
+func (ldb *LevelDB) ZSetScore(key []byte, member []byte) (float64, bool) {
+	value := ldb.get(encodeZSetMemberKey(key, member))
+	if len(value) != 8 {
+		return 0, false
+	}
+	return decodeScore(value), true
+}
+
+// ZSetPut sets the scores of the members, creates the sorted set if it does not exist.
+// Returns the number of the members added, not including the ones updated.
+func (ldb *LevelDB) ZSetPut(key []byte, members []ZMember, expireAt *time.Time) int {
+	origin := ldb.count(key)
+	count := origin
+	scores := make(map[string]float64) // the scores put in this batch, which is not readable yet
+
+	batch := new(leveldb.Batch)
+	for _, m := range members {
+		old, exists := scores[string(m.Member)]
+		if !exists {
+			old, exists = ldb.ZSetScore(key, m.Member)
+		}
+		if exists {
+			batch.Delete(encodeZSetScoreKey(key, old, m.Member))
+		} else {
+			count++
+		}
+		scores[string(m.Member)] = m.Score
+
+		batch.Put(encodeZSetMemberKey(key, m.Member), encodeScore(m.Score))
+		batch.Put(encodeZSetScoreKey(key, m.Score, m.Member), []byte{})
+	}
+	ldb.putCountMeta(batch, key, SortedSet, expireAt, count)
+	ldb.write(batch)
+	return count - origin
+}
+
+// ZSetRemove removes the members, returns the number of the members removed. The sorted
+// set is deleted when the last member is removed.
+func (ldb *LevelDB) ZSetRemove(key []byte, members [][]byte) int {
+	_, _, expireAt := ldb.has(encodeMetaKey(key))
+	count := ldb.count(key)
+	removed := make(map[string]bool)
+
+	batch := new(leveldb.Batch)
+	for _, member := range members {
+		if removed[string(member)] {
+			continue
+		}
+		score, ok := ldb.ZSetScore(key, member)
+		if !ok {
+			continue
+		}
+		removed[string(member)] = true
+		batch.Delete(encodeZSetMemberKey(key, member))
+		batch.Delete(encodeZSetScoreKey(key, score, member))
+	}
+	if len(removed) == 0 {
+		return 0
+	}
+
+	if count-len(removed) <= 0 {
+		ldb.deleteMeta(batch, key)
+	} else {
+		ldb.putCountMeta(batch, key, SortedSet, expireAt, count-len(removed))
+	}
+	ldb.write(batch)
+	return len(removed)
+}
+
+// PutZSet replaces the value of key, whatever its type is, with a sorted set of the members.
+// The key is deleted if members is empty.
+func (ldb *LevelDB) PutZSet(key []byte, members []ZMember, expireAt *time.Time) {
+	ldb.Delete(key)
+	if len(members) == 0 {
+		return
+	}
+	ldb.ZSetPut(key, members, expireAt)
+}
+
+// ZSetRank returns the rank of the member, in the order of the scores, or the reversed
+// order. It returns false if the member is not in the sorted set.
+func (ldb *LevelDB) ZSetRank(key []byte, member []byte, reverse bool) (int, bool) {
+	score, ok := ldb.ZSetScore(key, member)
+	if !ok {
+		return 0, false
+	}
+
+	prefix := encodeZSetScorePrefix(key)
+	scoreKey := encodeZSetScoreKey(key, score, member)
+	r := &util.Range{Start: prefix, Limit: scoreKey}
+	if reverse {
+		r = &util.Range{Start: append(scoreKey, 0x00), Limit: util.BytesPrefix(prefix).Limit}
+	}
+
+	rank := 0
+	iter := ldb.newIterator(r)
+	for iter.Next() {
+		rank++
+	}
+	iter.Release()
+	return rank, true
+}
+
+// ZSetRangeByRank returns the members in [start, stop], the range is redis style.
+func (ldb *LevelDB) ZSetRangeByRank(key []byte, start, stop int, reverse bool) []ZMember {
+	start, stop, ok := listRange(start, stop, ldb.count(key))
+	if !ok {
+		return []ZMember{}
+	}
+
+	r := util.BytesPrefix(encodeZSetScorePrefix(key))
+	return ldb.zsetRange(r, reverse, start, stop-start+1, scoreKeyMember(key))
+}
+
+// ZSetRangeByScore returns the members with the score in [min, max], skipping offset
+// members, at most count members (negative for all).
+func (ldb *LevelDB) ZSetRangeByScore(key []byte, min, max ScoreBound, reverse bool, offset, count int) []ZMember {
+	return ldb.zsetRange(scoreRange(key, min, max), reverse, offset, count, scoreKeyMember(key))
+}
+
+// ZSetRangeByLex returns the members in [min, max] in the lex order, skipping offset
+// members, at most count members (negative for all).
+func (ldb *LevelDB) ZSetRangeByLex(key []byte, min, max LexBound, reverse bool, offset, count int) []ZMember {
+	prefixLen := len(encodeZSetMemberKey(key, nil))
+	return ldb.zsetRange(lexRange(key, min, max), reverse, offset, count, func(iter iterator.Iterator) ZMember {
+		member := append([]byte{}, iter.Key()[prefixLen:]...)
+		return ZMember{Member: member, Score: decodeScore(iter.Value())}
+	})
+}
+
+// ZSetCount returns the number of the members with the score in [min, max].
+func (ldb *LevelDB) ZSetCount(key []byte, min, max ScoreBound) int {
+	r := scoreRange(key, min, max)
+	if bytes.Compare(r.Start, r.Limit) >= 0 {
+		return 0
+	}
+
+	count := 0
+	iter := ldb.newIterator(r)
+	for iter.Next() {
+		count++
+	}
+	iter.Release()
+	return count
+}
+
+// ZSetPop pops at most count members with the lowest scores, or the highest ones if max is
+// true. The sorted set is deleted when the last member is popped.
+func (ldb *LevelDB) ZSetPop(key []byte, count int, max bool) []ZMember {
+	if count <= 0 {
+		return []ZMember{}
+	}
+
+	members := ldb.ZSetRangeByRank(key, 0, count-1, max)
+	names := make([][]byte, len(members))
+	for i, m := range members {
+		names[i] = m.Member
+	}
+	ldb.ZSetRemove(key, names)
+	return members
+}
+
+func (ldb *LevelDB) GetZSet(key []byte) []ZMember {
+	return ldb.ZSetRangeByRank(key, 0, -1, false)
+}
+
+// scoreKeyMember returns the function to decode the member from an index entry.
+func scoreKeyMember(key []byte) func(iterator.Iterator) ZMember {
+	prefixLen := len(encodeZSetScorePrefix(key))
+	return func(iter iterator.Iterator) ZMember {
+		k := iter.Key()
+		member := append([]byte{}, k[prefixLen+8:]...)
+		return ZMember{Member: member, Score: decodeScore(k[prefixLen : prefixLen+8])}
+	}
+}
+
+// zsetRange walks the entries in r, in the reversed order if reverse is true, skips offset
+// entries and returns at most count (negative for all) members decoded by decode.
+func (ldb *LevelDB) zsetRange(r *util.Range, reverse bool, offset, count int, decode func(iterator.Iterator) ZMember) []ZMember {
+	members := []ZMember{}
+	if offset < 0 || count == 0 || bytes.Compare(r.Start, r.Limit) >= 0 {
+		return members
+	}
+
+	iter := ldb.newIterator(r)
+	defer iter.Release()
+
+	next := iter.Next
+	if reverse {
+		next = iter.Prev
+		if !iter.Last() {
+			return members
+		}
+	} else if !iter.First() {
+		return members
+	}
+
+	for ok := true; ok; ok = next() {
+		if offset > 0 {
+			offset--
+			continue
+		}
+		members = append(members, decode(iter))
+		if count > 0 && len(members) == count {
+			break
+		}
+	}
+	return members
+}
+
+// scoreRange returns the range of the index entries with the score in [min, max].
+func scoreRange(key []byte, min, max ScoreBound) *util.Range {
+	start := encodeZSetScoreKey(key, min.Score, nil)
+	if min.Exclusive {
+		start = nextScoreKey(start)
+	}
+	limit := encodeZSetScoreKey(key, max.Score, nil)
+	if !max.Exclusive {
+		limit = nextScoreKey(limit)
+	}
+	return &util.Range{Start: start, Limit: limit}
+}
+
+// lexRange returns the range of the member entries in [min, max].
+func lexRange(key []byte, min, max LexBound) *util.Range {
+	prefix := encodeZSetMemberKey(key, nil)
+
+	var start, limit []byte
+	switch {
+	case min.Inf < 0:
+		start = prefix
+	case min.Inf > 0:
+		start = util.BytesPrefix(prefix).Limit
+	case min.Exclusive:
+		start = append(encodeZSetMemberKey(key, min.Member), 0x00)
+	default:
+		start = encodeZSetMemberKey(key, min.Member)
+	}
+
+	switch {
+	case max.Inf < 0:
+		limit = prefix
+	case max.Inf > 0:
+		limit = util.BytesPrefix(prefix).Limit
+	case max.Exclusive:
+		limit = encodeZSetMemberKey(key, max.Member)
+	default:
+		limit = append(encodeZSetMemberKey(key, max.Member), 0x00)
+	}
+	return &util.Range{Start: start, Limit: limit}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -160,6 +161,7 @@ func TestDecrby(t *testing.T) {
 }
 
 func TestGet(t *testing.T) {
+	big := strings.Repeat("0123456789", 100000)
 	tests := []rodisTest{
 		{[]interface{}{"get"}, replyType{"Error", "ERR wrong number of arguments for 'get' command"}},
 		{[]interface{}{"get", "a", "b"}, replyType{"Error", "ERR wrong number of arguments for 'get' command"}},
@@ -167,6 +169,11 @@ func TestGet(t *testing.T) {
 		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
 		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
 		{[]interface{}{"get", "b"}, replyType{"BulkString", nil}},
+
+		// larger than the read buffer of the connection
+		{[]interface{}{"set", "a", big}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte(big)}},
+		{[]interface{}{"strlen", "a"}, replyType{"Integer", int64(len(big))}},
 	}
 	runTest("GET", tests, t)
 }
@@ -187,6 +194,43 @@ func TestGetBit(t *testing.T) {
 	runTest("GETBIT", tests, t)
 }
 
+func TestGetDel(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"getdel"}, replyType{"Error", "ERR wrong number of arguments for 'getdel' command"}},
+		{[]interface{}{"getdel", "a"}, replyType{"BulkString", nil}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"getdel", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"hset", "b", "b1", "foobar"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"getdel", "b"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("GETDEL", tests, t)
+}
+
+func TestGetEx(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"getex"}, replyType{"Error", "ERR wrong number of arguments for 'getex' command"}},
+		{[]interface{}{"getex", "a"}, replyType{"BulkString", nil}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"getex", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"getex", "a", "ex"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"getex", "a", "ex", "b"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"getex", "a", "ex", "0"}, replyType{"Error", "ERR invalid expire time in 'getex' command"}},
+		{[]interface{}{"getex", "a", "ex", "10", "px", "100"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"getex", "a", "ex", "100"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(100)}},
+		{[]interface{}{"getex", "a", "exat", "4102444800"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"expiretime", "a"}, replyType{"Integer", int64(4102444800)}},
+		{[]interface{}{"getex", "a", "pxat", "4102444800123"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"pexpiretime", "a"}, replyType{"Integer", int64(4102444800123)}},
+		{[]interface{}{"getex", "a", "persist"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(-1)}},
+		{[]interface{}{"getex", "a", "pxat", "1"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("GETEX", tests, t)
+}
+
 func TestGetRange(t *testing.T) {
 	tests := []rodisTest{
 		{[]interface{}{"getrange"}, replyType{"Error", "ERR wrong number of arguments for 'getrange' command"}},
@@ -349,6 +393,18 @@ func TestMsetnx(t *testing.T) {
 	runTest("MSETNX", tests, t)
 }
 
+func TestPsetex(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"psetex", "a", "10"}, replyType{"Error", "ERR wrong number of arguments for 'psetex' command"}},
+		{[]interface{}{"psetex", "a", "b", "foobar"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"psetex", "a", "-10", "foobar"}, replyType{"Error", "ERR invalid expire time in 'psetex' command"}},
+		{[]interface{}{"psetex", "a", "10000", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(10)}},
+	}
+	runTest("PSETEX", tests, t)
+}
+
 func TestSet(t *testing.T) {
 	tests := []rodisTest{
 		{[]interface{}{"set"}, replyType{"Error", "ERR wrong number of arguments for 'set' command"}},
@@ -357,25 +413,59 @@ func TestSet(t *testing.T) {
 		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
 		{[]interface{}{"set", "a", "dong", "a"}, replyType{"Error", "ERR syntax error"}},
 		{[]interface{}{"set", "a", "dong", "nx"}, replyType{"BulkString", nil}},
-		{[]interface{}{"set", "a", "dong", "nx", "xx"}, replyType{"BulkString", nil}},
+		{[]interface{}{"set", "a", "dong", "nx", "xx"}, replyType{"Error", "ERR syntax error"}},
 		{[]interface{}{"set", "a", "dong", "xx"}, replyType{"SimpleString", "OK"}},
-		{[]interface{}{"set", "a", "dong", "xx", "nx"}, replyType{"BulkString", nil}},
+		{[]interface{}{"set", "a", "dong", "xx", "nx"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"set", "a", "dong", "xx", "xx"}, replyType{"Error", "ERR syntax error"}},
 		{[]interface{}{"set", "a", "dong", "ex"}, replyType{"Error", "ERR syntax error"}},
 		{[]interface{}{"set", "a", "dong", "ex", "nx"}, replyType{"Error", "ERR value is not an integer or out of range"}},
 		{[]interface{}{"set", "a", "dong", "px"}, replyType{"Error", "ERR syntax error"}},
 		{[]interface{}{"set", "a", "dong", "px", "nx"}, replyType{"Error", "ERR value is not an integer or out of range"}},
 		{[]interface{}{"set", "a", "dong", "ex", "1"}, replyType{"SimpleString", "OK"}},
 		{[]interface{}{"set", "a", "dong", "px", "1"}, replyType{"SimpleString", "OK"}},
-		{[]interface{}{"set", "a", "dong", "px", "1", "px", "100"}, replyType{"SimpleString", "OK"}},
-		{[]interface{}{"set", "a", "dong", "px", "1", "ex", "100"}, replyType{"SimpleString", "OK"}},
-		{[]interface{}{"set", "a", "foobar", "px", "1", "ex", "100", "xx"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "a", "dong", "px", "1", "px", "100"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"set", "a", "dong", "ex", "10", "px", "100"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"set", "a", "dong", "ex", "10", "px", "foo"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"set", "a", "dong", "keepttl", "ex", "1"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"set", "a", "dong", "exat", "4102444800", "keepttl"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"set", "a", "dong", "get", "get"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"set", "a", "foobar", "ex", "100", "xx"}, replyType{"SimpleString", "OK"}},
 		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
-		{[]interface{}{"set", "b", "foobar", "px", "1", "ex", "100", "nx"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "b", "foobar", "ex", "100", "nx"}, replyType{"SimpleString", "OK"}},
 		{[]interface{}{"get", "b"}, replyType{"BulkString", []byte("foobar")}},
 	}
 	runTest("SET", tests, t)
 }
 
+func TestSetOptions(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"set", "a", "foobar", "get"}, replyType{"BulkString", nil}},
+		{[]interface{}{"set", "a", "dong", "get"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"set", "a", "rod", "nx", "get"}, replyType{"BulkString", []byte("dong")}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("dong")}},
+		{[]interface{}{"set", "b", "rod", "xx", "get"}, replyType{"BulkString", nil}},
+		{[]interface{}{"exists", "b"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "a", "dong", "ex", "0"}, replyType{"Error", "ERR invalid expire time in 'set' command"}},
+		{[]interface{}{"set", "a", "dong", "px", "-1"}, replyType{"Error", "ERR invalid expire time in 'set' command"}},
+		{[]interface{}{"set", "a", "dong", "exat"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"set", "a", "dong", "exat", "4102444800"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"expiretime", "a"}, replyType{"Integer", int64(4102444800)}},
+		{[]interface{}{"set", "a", "foobar", "keepttl"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"expiretime", "a"}, replyType{"Integer", int64(4102444800)}},
+		{[]interface{}{"set", "a", "foobar", "pxat", "4102444800123"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"pexpiretime", "a"}, replyType{"Integer", int64(4102444800123)}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(-1)}},
+		{[]interface{}{"set", "a", "foobar", "pxat", "1"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", nil}},
+		{[]interface{}{"hset", "c", "c1", "foobar"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"set", "c", "foobar", "get"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+		{[]interface{}{"set", "c", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"get", "c"}, replyType{"BulkString", []byte("foobar")}},
+	}
+	runTest("SETOPTIONS", tests, t)
+}
+
 func TestSetbit(t *testing.T) {
 	tests := []rodisTest{
 		{[]interface{}{"setbit"}, replyType{"Error", "ERR wrong number of arguments for 'setbit' command"}},
@@ -390,6 +480,18 @@ func TestSetbit(t *testing.T) {
 	runTest("SETBIT", tests, t)
 }
 
+func TestSetex(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"setex", "a", "10"}, replyType{"Error", "ERR wrong number of arguments for 'setex' command"}},
+		{[]interface{}{"setex", "a", "b", "foobar"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"setex", "a", "0", "foobar"}, replyType{"Error", "ERR invalid expire time in 'setex' command"}},
+		{[]interface{}{"setex", "a", "10", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"ttl", "a"}, replyType{"Integer", int64(10)}},
+	}
+	runTest("SETEX", tests, t)
+}
+
 func TestSetnx(t *testing.T) {
 	tests := []rodisTest{
 		{[]interface{}{"setnx"}, replyType{"Error", "ERR wrong number of arguments for 'setnx' command"}},
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// transactions group
+func TestDiscard(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"discard"}, replyType{"Error", "ERR DISCARD without MULTI"}},
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"discard"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", nil}},
+		{[]interface{}{"exec"}, replyType{"Error", "ERR EXEC without MULTI"}},
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"foo"}, replyType{"Error", "ERR unknown command 'foo'"}},
+		{[]interface{}{"discard"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{{"SimpleString", "OK"}}}},
+	}
+	runTest("DISCARD", tests, t)
+}
+
+func TestExec(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"exec"}, replyType{"Error", "ERR EXEC without MULTI"}},
+		{[]interface{}{"set", "a", "1"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"rpush", "b", "b1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{}}},
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"incr", "a"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"lpush", "a", "a1"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"rpush", "b", "b2"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"lrange", "b", "0", "-1"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"blpop", "c", "0"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"dbsize"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"hset", "d", "d1", "foobar"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"hgetall", "d"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"dbsize"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{
+			{"Integer", int64(2)},
+			{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+			{"Integer", int64(2)},
+			{"Array", bulks("b1", "b2")},
+			{"Array", nil},
+			{"Integer", int64(2)},
+			{"Integer", int64(1)},
+			{"Array", bulks("d1", "foobar")},
+			{"Integer", int64(3)},
+		}}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("2")}},
+		{[]interface{}{"hget", "d", "d1"}, replyType{"BulkString", []byte("foobar")}},
+		{[]interface{}{"dbsize"}, replyType{"Integer", int64(3)}},
+
+		// rejected while queuing, nothing runs
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"incr", "a"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"multi"}, replyType{"Error", "ERR MULTI calls can not be nested"}},
+		{[]interface{}{"foo", "a"}, replyType{"Error", "ERR unknown command 'foo'"}},
+		{[]interface{}{"get"}, replyType{"Error", "ERR wrong number of arguments for 'get' command"}},
+		{[]interface{}{"set", "a"}, replyType{"Error", "ERR wrong number of arguments for 'set' command"}},
+		{[]interface{}{"select", "1"}, replyType{"Error", "ERR Command not allowed inside a transaction"}},
+		{[]interface{}{"exec"}, replyType{"Error", "EXECABORT Transaction discarded because of previous errors."}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("2")}},
+
+		// the commands see the writes before them
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"del", "a"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"zadd", "e", "1", "e1", "2", "e2"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"zpopmax", "e"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"keys", "*"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{
+			{"Integer", int64(1)},
+			{"Integer", int64(2)},
+			{"Array", bulks("e2", "2")},
+			{"Array", bulks("b", "d", "e")},
+		}}},
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"set", "x", "foobar"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"flushdb"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"set", "y", "foobar"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"dbsize"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{
+			{"SimpleString", "OK"},
+			{"SimpleString", "OK"},
+			{"SimpleString", "OK"},
+			{"Integer", int64(1)},
+		}}},
+		{[]interface{}{"keys", "*"}, replyType{"Array", bulks("y")}},
+		{[]interface{}{"dbsize"}, replyType{"Integer", int64(1)}},
+	}
+	runTest("EXEC", tests, t)
+
+	// The blocked clients are served after the transaction is committed
+	reply := blockedDo("blpop", "q", "0")
+	runSteps("EXEC", []rodisTest{
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"rpush", "q", "q1", "q2"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"llen", "q"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", integers(2, 2)}},
+	}, t)
+	checkBlocked("EXEC", reply, replyType{"Array", bulks("q", "q1")}, t)
+	runSteps("EXEC", []rodisTest{
+		{[]interface{}{"lrange", "q", "0", "-1"}, replyType{"Array", bulks("q2")}},
+	}, t)
+}
+
+func TestMulti(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"multi", "a"}, replyType{"Error", "ERR wrong number of arguments for 'multi' command"}},
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"multi"}, replyType{"Error", "ERR MULTI calls can not be nested"}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"get", "a"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", []replyType{{"SimpleString", "OK"}, {"BulkString", []byte("foobar")}}}},
+		{[]interface{}{"get", "a"}, replyType{"BulkString", []byte("foobar")}},
+	}
+	runTest("MULTI", tests, t)
+}
+
+func TestUnwatch(t *testing.T) {
+	other := redisPool.Get()
+	defer other.Close()
+
+	runTest("UNWATCH", []rodisTest{
+		{[]interface{}{"unwatch", "a"}, replyType{"Error", "ERR wrong number of arguments for 'unwatch' command"}},
+		{[]interface{}{"unwatch"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"watch", "a"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"unwatch"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	other.Do("set", "a", "foobar")
+	runSteps("UNWATCH", []rodisTest{
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"get", "a"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, replyType{"Array", bulks("foobar")}},
+	}, t)
+}
+
+func TestWatch(t *testing.T) {
+	other := redisPool.Get()
+	defer other.Close()
+
+	// watchExec runs a transaction after the watched keys are touched by other
+	watchExec := func(keys []interface{}, touch func(), reply replyType) {
+		runSteps("WATCH", []rodisTest{{append([]interface{}{"watch"}, keys...), replyType{"SimpleString", "OK"}}}, t)
+		touch()
+		runSteps("WATCH", []rodisTest{
+			{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+			{[]interface{}{"ping"}, replyType{"SimpleString", "QUEUED"}},
+			{[]interface{}{"exec"}, reply},
+		}, t)
+	}
+	aborted := replyType{"Array", nil}
+	executed := replyType{"Array", []replyType{{"SimpleString", "PONG"}}}
+
+	runTest("WATCH", []rodisTest{
+		{[]interface{}{"watch"}, replyType{"Error", "ERR wrong number of arguments for 'watch' command"}},
+		{[]interface{}{"set", "a", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"watch", "a"}, replyType{"Error", "ERR Command not allowed inside a transaction"}},
+		{[]interface{}{"exec"}, replyType{"Error", "EXECABORT Transaction discarded because of previous errors."}},
+	}, t)
+
+	watchExec([]interface{}{"a", "b"}, func() {}, executed)
+	watchExec([]interface{}{"a", "b"}, func() { other.Do("set", "a", "dongr") }, aborted)
+	watchExec([]interface{}{"a", "b"}, func() { other.Do("set", "b", "dongr") }, aborted)
+	watchExec([]interface{}{"a"}, func() {}, executed) // EXEC unwatches the keys
+	watchExec([]interface{}{"a"}, func() { other.Do("del", "a") }, aborted)
+	watchExec([]interface{}{"a"}, func() { other.Do("del", "a") }, executed) // no such key
+	watchExec([]interface{}{"c"}, func() { other.Do("hset", "c", "c1", "foobar") }, aborted)
+	watchExec([]interface{}{"c"}, func() { other.Do("hset", "c", "c1", "dongr") }, aborted)
+	watchExec([]interface{}{"c"}, func() { other.Do("expire", "c", "100") }, aborted)
+	watchExec([]interface{}{"c"}, func() {
+		other.Send("multi")
+		other.Send("hdel", "c", "c1")
+		other.Do("exec")
+	}, aborted)
+
+	// expired, deleted by EXEC or the expirer
+	runSteps("WATCH", []rodisTest{{[]interface{}{"set", "e", "foobar", "px", "100"}, replyType{"SimpleString", "OK"}}}, t)
+	watchExec([]interface{}{"e"}, func() { time.Sleep(200 * time.Millisecond) }, aborted)
+
+	// flushed, if the key exists
+	runSteps("WATCH", []rodisTest{{[]interface{}{"set", "f", "foobar"}, replyType{"SimpleString", "OK"}}}, t)
+	watchExec([]interface{}{"f"}, func() { other.Do("flushdb") }, aborted)
+	watchExec([]interface{}{"f"}, func() { other.Do("flushdb") }, executed)
+
+	// the key is in the db selected by WATCH
+	runSteps("WATCH", []rodisTest{
+		{[]interface{}{"watch", "g"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"select", "1"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	other.Do("set", "g", "foobar")
+	runSteps("WATCH", []rodisTest{
+		{[]interface{}{"multi"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"ping"}, replyType{"SimpleString", "QUEUED"}},
+		{[]interface{}{"exec"}, aborted},
+		{[]interface{}{"select", "0"}, replyType{"SimpleString", "OK"}},
+	}, t)
+	watchExec([]interface{}{"g"}, func() {
+		other.Do("select", "1")
+		other.Do("set", "g", "dongr")
+		other.Do("select", "0")
+	}, executed)
+	other.Do("select", "1")
+	other.Do("flushdb")
+	other.Do("select", "0")
+}
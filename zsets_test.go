@@ -0,0 +1,249 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestZadd(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zadd", "a", "1"}, replyType{"Error", "ERR wrong number of arguments for 'zadd' command"}},
+		{[]interface{}{"zadd", "a", "1", "a1", "2"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"zadd", "a", "x", "a1"}, replyType{"Error", "ERR value is not a valid float"}},
+		{[]interface{}{"zadd", "a", "nx", "xx", "1", "a1"}, replyType{"Error", "ERR XX and NX options at the same time are not compatible"}},
+		{[]interface{}{"zadd", "a", "gt", "lt", "1", "a1"}, replyType{"Error", "ERR GT, LT, and/or NX options at the same time are not compatible"}},
+		{[]interface{}{"zadd", "a", "incr", "1", "a1", "2", "a2"}, replyType{"Error", "ERR INCR option supports a single increment-element pair"}},
+		{[]interface{}{"zadd", "a", "1", "a1", "2", "a2", "3", "a1"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zrange", "a", "0", "-1", "withscores"}, replyType{"Array", bulks("a2", "2", "a1", "3")}},
+		{[]interface{}{"zadd", "a", "nx", "5", "a1", "4", "a3"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zadd", "a", "xx", "ch", "5", "a1", "6", "a4"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zadd", "a", "gt", "ch", "1", "a1", "7", "a2"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zadd", "a", "lt", "ch", "0", "a1", "8", "a3"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zrange", "a", "0", "-1", "withscores"}, replyType{"Array", bulks("a1", "0", "a3", "4", "a2", "7")}},
+		{[]interface{}{"zadd", "a", "incr", "1.5", "a1"}, replyType{"BulkString", []byte("1.5")}},
+		{[]interface{}{"zadd", "a", "nx", "incr", "1", "a1"}, replyType{"BulkString", nil}},
+		{[]interface{}{"zadd", "a", "-inf", "a5"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zscore", "a", "a5"}, replyType{"BulkString", []byte("-inf")}},
+		{[]interface{}{"zadd", "b", "inf", "b1"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zadd", "b", "incr", "-inf", "b1"}, replyType{"Error", "ERR resulting score is not a number (NaN)"}},
+		{[]interface{}{"type", "a"}, replyType{"SimpleString", "zset"}},
+		{[]interface{}{"set", "c", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"zadd", "c", "1", "c1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("ZADD", tests, t)
+}
+
+func TestZcard(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zcard"}, replyType{"Error", "ERR wrong number of arguments for 'zcard' command"}},
+		{[]interface{}{"zcard", "a"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"zadd", "a", "1", "a1", "2", "a2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zadd", "a", "3", "a2", "3", "a3"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zcard", "a"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"expire", "a", "100"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zcard", "a"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"zcard", "b"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("ZCARD", tests, t)
+}
+
+func TestZcount(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zcount", "a", "0"}, replyType{"Error", "ERR wrong number of arguments for 'zcount' command"}},
+		{[]interface{}{"zcount", "a", "x", "1"}, replyType{"Error", "ERR min or max is not a float"}},
+		{[]interface{}{"zcount", "a", "0", "1"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"zadd", "a", "-1", "a0", "1", "a1", "2", "a2", "2", "a3", "3", "a4"}, replyType{"Integer", int64(5)}},
+		{[]interface{}{"zcount", "a", "-inf", "+inf"}, replyType{"Integer", int64(5)}},
+		{[]interface{}{"zcount", "a", "1", "2"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zcount", "a", "(1", "2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zcount", "a", "1", "(2"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zcount", "a", "-0.5", "0"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"zcount", "a", "3", "1"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("ZCOUNT", tests, t)
+}
+
+func TestZincrby(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zincrby", "a", "1"}, replyType{"Error", "ERR wrong number of arguments for 'zincrby' command"}},
+		{[]interface{}{"zincrby", "a", "x", "a1"}, replyType{"Error", "ERR value is not a valid float"}},
+		{[]interface{}{"zincrby", "a", "1.5", "a1"}, replyType{"BulkString", []byte("1.5")}},
+		{[]interface{}{"zincrby", "a", "-3", "a1"}, replyType{"BulkString", []byte("-1.5")}},
+		{[]interface{}{"zadd", "a", "0", "a2"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zrange", "a", "0", "-1"}, replyType{"Array", bulks("a1", "a2")}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"zincrby", "b", "1", "b1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("ZINCRBY", tests, t)
+}
+
+func TestZinterstore(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zinterstore", "d", "1"}, replyType{"Error", "ERR wrong number of arguments for 'zinterstore' command"}},
+		{[]interface{}{"zinterstore", "d", "0", "a"}, replyType{"Error", "ERR at least 1 input key is needed for 'zinterstore' command"}},
+		{[]interface{}{"zinterstore", "d", "3", "a", "b"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"zinterstore", "d", "2", "a", "b", "weights", "1", "x"}, replyType{"Error", "ERR weight value is not a float"}},
+		{[]interface{}{"zinterstore", "d", "2", "a", "b", "aggregate", "avg"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"zadd", "a", "1", "x", "2", "y", "3", "z"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zadd", "b", "10", "y", "20", "z", "30", "w"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zinterstore", "d", "2", "a", "b"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zrange", "d", "0", "-1", "withscores"}, replyType{"Array", bulks("y", "12", "z", "23")}},
+		{[]interface{}{"zinterstore", "d", "2", "a", "b", "weights", "2", "0.5", "aggregate", "max"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zrange", "d", "0", "-1", "withscores"}, replyType{"Array", bulks("y", "5", "z", "10")}},
+		{[]interface{}{"sadd", "s", "x", "y"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zinterstore", "d", "2", "a", "s", "aggregate", "min"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zrange", "d", "0", "-1", "withscores"}, replyType{"Array", bulks("x", "1", "y", "1")}},
+		{[]interface{}{"zinterstore", "d", "2", "a", "x"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"exists", "d"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"set", "c", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"zinterstore", "d", "2", "a", "c"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("ZINTERSTORE", tests, t)
+}
+
+func TestZpopmax(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zpopmax"}, replyType{"Error", "ERR wrong number of arguments for 'zpopmax' command"}},
+		{[]interface{}{"zpopmax", "a"}, replyType{"Array", bulks()}},
+		{[]interface{}{"zadd", "a", "1", "a1", "2", "a2", "3", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zpopmax", "a", "-1"}, replyType{"Error", "ERR value is out of range, must be positive"}},
+		{[]interface{}{"zpopmax", "a"}, replyType{"Array", bulks("a3", "3")}},
+		{[]interface{}{"zpopmax", "a", "5"}, replyType{"Array", bulks("a2", "2", "a1", "1")}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("ZPOPMAX", tests, t)
+}
+
+func TestZpopmin(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zpopmin"}, replyType{"Error", "ERR wrong number of arguments for 'zpopmin' command"}},
+		{[]interface{}{"zpopmin", "a"}, replyType{"Array", bulks()}},
+		{[]interface{}{"zadd", "a", "1", "a1", "2", "a2", "3", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zpopmin", "a", "0"}, replyType{"Array", bulks()}},
+		{[]interface{}{"zpopmin", "a", "2"}, replyType{"Array", bulks("a1", "1", "a2", "2")}},
+		{[]interface{}{"zcard", "a"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"zpopmin", "b"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("ZPOPMIN", tests, t)
+}
+
+func TestZrange(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zrange", "a", "0"}, replyType{"Error", "ERR wrong number of arguments for 'zrange' command"}},
+		{[]interface{}{"zrange", "a", "0", "-1"}, replyType{"Array", bulks()}},
+		{[]interface{}{"zadd", "a", "-1.5", "m", "0", "b", "0", "a", "2", "c", "2", "d", "10", "e"}, replyType{"Integer", int64(6)}},
+
+		// by rank
+		{[]interface{}{"zrange", "a", "0", "-1"}, replyType{"Array", bulks("m", "a", "b", "c", "d", "e")}},
+		{[]interface{}{"zrange", "a", "1", "2", "withscores"}, replyType{"Array", bulks("a", "0", "b", "0")}},
+		{[]interface{}{"zrange", "a", "0", "1", "rev"}, replyType{"Array", bulks("e", "d")}},
+		{[]interface{}{"zrange", "a", "-2", "100"}, replyType{"Array", bulks("d", "e")}},
+		{[]interface{}{"zrange", "a", "3", "1"}, replyType{"Array", bulks()}},
+		{[]interface{}{"zrange", "a", "x", "1"}, replyType{"Error", "ERR value is not an integer or out of range"}},
+		{[]interface{}{"zrange", "a", "0", "1", "limit", "0", "1"}, replyType{"Error", "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"}},
+
+		// by score
+		{[]interface{}{"zrange", "a", "0", "2", "byscore"}, replyType{"Array", bulks("a", "b", "c", "d")}},
+		{[]interface{}{"zrange", "a", "(0", "(10", "byscore", "withscores"}, replyType{"Array", bulks("c", "2", "d", "2")}},
+		{[]interface{}{"zrange", "a", "-inf", "+inf", "byscore", "limit", "1", "2"}, replyType{"Array", bulks("a", "b")}},
+		{[]interface{}{"zrange", "a", "+inf", "-inf", "byscore", "rev", "limit", "1", "-1"}, replyType{"Array", bulks("d", "c", "b", "a", "m")}},
+		{[]interface{}{"zrange", "a", "2", "0", "byscore", "rev"}, replyType{"Array", bulks("d", "c", "b", "a")}},
+		{[]interface{}{"zrange", "a", "0", "2", "byscore", "rev"}, replyType{"Array", bulks()}},
+		{[]interface{}{"zrange", "a", "0", "x", "byscore"}, replyType{"Error", "ERR min or max is not a float"}},
+
+		// by lex
+		{[]interface{}{"zrange", "a", "-", "+", "bylex"}, replyType{"Array", bulks("a", "b", "c", "d", "e", "m")}},
+		{[]interface{}{"zrange", "a", "[b", "(e", "bylex"}, replyType{"Array", bulks("b", "c", "d")}},
+		{[]interface{}{"zrange", "a", "(b", "+", "bylex", "limit", "1", "2"}, replyType{"Array", bulks("d", "e")}},
+		{[]interface{}{"zrange", "a", "[d", "-", "bylex", "rev"}, replyType{"Array", bulks("d", "c", "b", "a")}},
+		{[]interface{}{"zrange", "a", "b", "+", "bylex"}, replyType{"Error", "ERR min or max not valid string range item"}},
+		{[]interface{}{"zrange", "a", "-", "+", "bylex", "withscores"}, replyType{"Error", "ERR syntax error, WITHSCORES not supported in combination with BYLEX"}},
+
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"zrange", "b", "0", "-1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("ZRANGE", tests, t)
+}
+
+func TestZrangestore(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zrangestore", "d", "a", "0"}, replyType{"Error", "ERR wrong number of arguments for 'zrangestore' command"}},
+		{[]interface{}{"zadd", "a", "1", "a1", "2", "a2", "3", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zrangestore", "d", "a", "0", "-1", "withscores"}, replyType{"Error", "ERR syntax error"}},
+		{[]interface{}{"zrangestore", "d", "a", "(1", "3", "byscore"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zrange", "d", "0", "-1", "withscores"}, replyType{"Array", bulks("a2", "2", "a3", "3")}},
+		{[]interface{}{"zrangestore", "d", "a", "5", "10"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"exists", "d"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("ZRANGESTORE", tests, t)
+}
+
+func TestZrank(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zrank", "a"}, replyType{"Error", "ERR wrong number of arguments for 'zrank' command"}},
+		{[]interface{}{"zrank", "a", "a1"}, replyType{"BulkString", nil}},
+		{[]interface{}{"zadd", "a", "1", "a1", "2", "a2", "2", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zrank", "a", "a1"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"zrank", "a", "a3"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zrank", "a", "a4"}, replyType{"BulkString", nil}},
+		{[]interface{}{"zrank", "a", "a2", "withscore"}, replyType{"Array", []replyType{{"Integer", int64(1)}, {"BulkString", []byte("2")}}}},
+		{[]interface{}{"zrank", "a", "a4", "withscore"}, replyType{"Array", nil}},
+		{[]interface{}{"set", "b", "foobar"}, replyType{"SimpleString", "OK"}},
+		{[]interface{}{"zrank", "b", "b1"}, replyType{"Error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	}
+	runTest("ZRANK", tests, t)
+}
+
+func TestZrem(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zrem", "a"}, replyType{"Error", "ERR wrong number of arguments for 'zrem' command"}},
+		{[]interface{}{"zrem", "a", "a1"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"zadd", "a", "1", "a1", "2", "a2", "3", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zrem", "a", "a1", "a1", "a4"}, replyType{"Integer", int64(1)}},
+		{[]interface{}{"zrange", "a", "0", "-1"}, replyType{"Array", bulks("a2", "a3")}},
+		{[]interface{}{"zcount", "a", "-inf", "+inf"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zrem", "a", "a2", "a3"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"exists", "a"}, replyType{"Integer", int64(0)}},
+	}
+	runTest("ZREM", tests, t)
+}
+
+func TestZrevrank(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zrevrank", "a"}, replyType{"Error", "ERR wrong number of arguments for 'zrevrank' command"}},
+		{[]interface{}{"zadd", "a", "1", "a1", "2", "a2", "3", "a3"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zrevrank", "a", "a1"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zrevrank", "a", "a3"}, replyType{"Integer", int64(0)}},
+		{[]interface{}{"zrevrank", "a", "a4"}, replyType{"BulkString", nil}},
+	}
+	runTest("ZREVRANK", tests, t)
+}
+
+func TestZscore(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zscore", "a"}, replyType{"Error", "ERR wrong number of arguments for 'zscore' command"}},
+		{[]interface{}{"zscore", "a", "a1"}, replyType{"BulkString", nil}},
+		{[]interface{}{"zadd", "a", "1.25", "a1", "-0", "a2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zscore", "a", "a1"}, replyType{"BulkString", []byte("1.25")}},
+		{[]interface{}{"zscore", "a", "a2"}, replyType{"BulkString", []byte("0")}},
+		{[]interface{}{"zscore", "a", "a3"}, replyType{"BulkString", nil}},
+	}
+	runTest("ZSCORE", tests, t)
+}
+
+func TestZunionstore(t *testing.T) {
+	tests := []rodisTest{
+		{[]interface{}{"zunionstore", "d", "1"}, replyType{"Error", "ERR wrong number of arguments for 'zunionstore' command"}},
+		{[]interface{}{"zunionstore", "d", "0", "a"}, replyType{"Error", "ERR at least 1 input key is needed for 'zunionstore' command"}},
+		{[]interface{}{"zadd", "a", "1", "x", "2", "y"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zadd", "b", "10", "y", "20", "z"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zunionstore", "d", "3", "a", "b", "c"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zrange", "d", "0", "-1", "withscores"}, replyType{"Array", bulks("x", "1", "y", "12", "z", "20")}},
+		{[]interface{}{"zunionstore", "d", "2", "a", "b", "weights", "-1", "1", "aggregate", "min"}, replyType{"Integer", int64(3)}},
+		{[]interface{}{"zrange", "d", "0", "-1", "withscores"}, replyType{"Array", bulks("y", "-2", "x", "-1", "z", "20")}},
+		{[]interface{}{"zunionstore", "a", "1", "a", "weights", "2"}, replyType{"Integer", int64(2)}},
+		{[]interface{}{"zrange", "a", "0", "-1", "withscores"}, replyType{"Array", bulks("x", "2", "y", "4")}},
+	}
+	runTest("ZUNIONSTORE", tests, t)
+}